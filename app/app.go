@@ -1,18 +1,22 @@
 package app
 
 import (
-	"encoding/json"
+	"context"
+	"errors"
+	"io"
 	"net/http"
 	"os"
 	"os/signal"
+	"sync"
 	"syscall"
 	"time"
 
-	uuid "github.com/satori/go.uuid"
-
 	"github.com/go-chi/chi"
+	"github.com/javking07/toadlester/artifacts"
 	"github.com/javking07/toadlester/conf"
+	"github.com/javking07/toadlester/influx"
 	"github.com/javking07/toadlester/model"
+	"github.com/javking07/toadlester/spool"
 	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
 )
@@ -24,17 +28,43 @@ type App struct {
 	Router   *chi.Mux
 	Logger   *zerolog.Logger
 	Channels map[string]chan ChannelMessage
+	Runs     *RunRegistry
+	Metrics  *MetricsRegistry
+	Influx   *influx.Writer
+	// Artifacts keeps the raw results of runs when configured.
+	Artifacts artifacts.Store
+	// Spool queues results while storage is unavailable when configured.
+	Spool *spool.Spool
+
+	// artifactsFormat is the encoding of recorded raw results.
+	artifactsFormat string
+
+	// ctx is cancelled on shutdown, which aborts storage work and running
+	// tests that are still in flight.
+	ctx    context.Context
+	cancel context.CancelFunc
+	timer  timerState
+	// slots bounds the on-demand runs in progress.
+	slots runSlots
+	// producers tracks the goroutines that write results. Shutdown waits
+	// for them before closing storage and the influx writer.
+	producers sync.WaitGroup
 }
 
 type ChannelMessage struct {
 	message string
 }
 
-const timerChannel = "timerChannel"
+const (
+	timerChannel    = "timerChannel"
+	shutdownTimeout = 5 * time.Second
+)
 
 // Bootstrap prepares app for run by setting things up based on provided config.
 func (a *App) Bootstrap(c *conf.Config) {
 	log.Info().Msg("bootstrapping app")
+	a.ctx, a.cancel = context.WithCancel(context.Background())
+	c.ServerDefaults()
 
 	var err error
 	a.Logger, err = InitLogger(c)
@@ -46,75 +76,203 @@ func (a *App) Bootstrap(c *conf.Config) {
 	time.Sleep(*c.Sleep)
 
 	a.Logger.Info().Msgf("initializing database")
-	a.Storage, err = InitDatabase(c)
+	a.Storage, err = InitDatabase(a.ctx, c)
 	if err != nil {
 		log.Fatal().Err(err).Msg("error preparing storage")
 	}
 
+	a.SeedTests(c)
+
+	if c.Influx != nil {
+		a.Logger.Info().Msgf("exporting results to influx at %s", c.Influx.Url)
+		a.Influx, err = influx.NewWriter(*c.Influx, a.Logger)
+		if err != nil {
+			log.Fatal().Err(err).Msg("error preparing influx exporter")
+		}
+	}
+
+	if c.Artifacts != nil {
+		store, err := artifacts.NewStore(*c.Artifacts, a.Storage)
+		if err != nil {
+			log.Fatal().Err(err).Msg("error preparing artifacts store")
+		}
+		if c.Artifacts.Format != "" && !artifacts.ValidFormat(c.Artifacts.Format) {
+			log.Fatal().Msgf("unknown artifacts format %q, expected gob or csv", c.Artifacts.Format)
+		}
+		a.Logger.Info().Msgf("keeping raw results of runs in %T", store)
+		a.Artifacts, a.artifactsFormat = store, c.Artifacts.Format
+	}
+
+	if c.Spool != nil {
+		if c.Spool.Path == "" {
+			log.Fatal().Msg("spool path is required")
+		}
+		a.Spool, err = spool.Open(c.Spool.Path)
+		if err != nil {
+			log.Fatal().Err(err).Msg("error opening spool")
+		}
+		if n := a.Spool.Len(); n > 0 {
+			a.Logger.Info().Msgf("found %d spooled results to replay", n)
+		}
+		if store, ok := a.Artifacts.(artifacts.DatabaseStore); ok {
+			a.Artifacts = spooledArtifacts{store, a.Spool}
+		}
+	}
+
 	a.Channels = InitChans()
+	a.Runs = NewRunRegistry(maxTrackedRuns)
+	a.slots.limit = maxRuns(c)
+	a.Metrics = NewMetricsRegistry()
+
+	a.InitRouter()
+	a.Server = a.InitServer(c)
 }
 
 // RunApp starts app functionality and ensures a graceful shutdown.
 func (a *App) RunApp(c *conf.Config) {
-	var gracefulStop = make(chan os.Signal)
+	var gracefulStop = make(chan os.Signal, 1)
 	signal.Notify(gracefulStop, syscall.SIGTERM)
 	signal.Notify(gracefulStop, syscall.SIGINT)
-	signal.Notify(gracefulStop, syscall.SIGKILL)
 
-	go a.InitTimer(c)
+	serverErrors := make(chan error, 1)
+	go func() {
+		a.Logger.Info().Msgf("starting api server on %s", a.Server.Addr)
+		serverErrors <- a.Server.ListenAndServe()
+	}()
+
+	a.goProduce(func() { a.InitTimer(c) })
+	a.goProduce(func() { a.InitCompactor(c) })
+	if a.Spool != nil {
+		a.goProduce(func() { a.InitSpool(c) })
+	}
 	select {
+	case err := <-serverErrors:
+		a.Logger.Fatal().Msgf("api server stopped: %v", err)
 	case sig := <-gracefulStop:
 		a.Logger.Info().Msgf("caught sig: %+v", sig)
+		ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		if err := a.Server.Shutdown(ctx); err != nil {
+			a.Logger.Error().Msgf("error shutting down api server: %v", err)
+		}
+		cancel()
+		a.cancel()
 		for _, v := range a.Channels {
 			v <- struct{ message string }{sig.String()}
 			a.Logger.Info().Msg("Wait for 1 second to finish processing")
 			time.Sleep(time.Second)
 		}
+		// runs are cancelled along with the app context; wait for them to
+		// record their results, which they store regardless, before closing
+		// where they are written to
+		a.producers.Wait()
+		if a.Influx != nil {
+			a.Influx.Close()
+		}
+		if closer, ok := a.Storage.(io.Closer); ok {
+			if err := closer.Close(); err != nil {
+				a.Logger.Error().Msgf("error closing storage: %v", err)
+			}
+		}
 		os.Exit(0)
 	}
 }
 
+// goProduce runs fn in the background as one of the producers shutdown waits
+// for.
+func (a *App) goProduce(fn func()) {
+	a.producers.Add(1)
+	go func() {
+		defer a.producers.Done()
+		fn()
+	}()
+}
+
 // InitTimer kicks off the timer process intended to run in the background.
+// Each stored test runs on its own schedule; tests without one run every
+// timer interval. Test definitions are reloaded from storage every interval.
 func (a *App) InitTimer(c *conf.Config) {
-	// todo add functionality to export metrics to influx
-	ticker := time.NewTicker(*c.Timer.Interval)
-
+	interval := *c.Timer.Interval
 	if a.Logger != nil {
-		a.Logger.Info().Msgf("initializing background process to run every: %s", *c.Timer.Interval)
+		a.Logger.Info().Msgf("initializing background process, reloading tests every: %s", interval)
+	}
+
+	staleAfter := defaultStaleIntervals * interval
+	if c.Timer.StaleAfter != nil {
+		staleAfter = *c.Timer.StaleAfter
+	}
+	a.timer.start(staleAfter)
+	defer a.timer.stop()
+
+	workers := c.Timer.Workers
+	if workers < 1 {
+		workers = 1
+	}
+	pool := newWorkerPool(workers, c.Timer.Queue)
+	guard := newOverlapGuard()
+	a.Logger.Info().Msgf("running scheduled tests on %d workers", workers)
+
+	sched := newScheduler(interval, a.Logger)
+	reload := func(now time.Time) {
+		tests, err := a.Storage.SelectTests(a.ctx)
+		if err != nil {
+			// the last loaded tests keep running while storage is unavailable
+			a.Logger.Warn().Msgf("error loading tests, keeping the %d last loaded: %v", len(sched.tests), err)
+			return
+		}
+		sched.load(tests, now)
 	}
+	now := time.Now()
+	reload(now)
+	reloadAt := now.Add(interval)
 
 	for {
+		wake := sched.wake()
+		if wake.IsZero() || reloadAt.Before(wake) {
+			wake = reloadAt
+		}
+		timer := time.NewTimer(time.Until(wake))
+
 		select {
 		case <-a.Channels[timerChannel]:
 			a.Logger.Info().Msg("shutting down timer process")
-			ticker.Stop()
+			timer.Stop()
+			// runs in flight are cancelled along with the app context
+			pool.wait()
 			return
 
-		case t := <-ticker.C:
-			a.Logger.Info().Msgf("running job at: %s", t)
-			// run each test
-			for _, test := range c.Tests {
-				a.Logger.Info().Msgf("running test for: %v", test.Name)
-				results, err := a.RunTest(test.Name, *test.Duration, test.TPS, test.Target)
-				if err != nil {
-					a.Logger.Error().Msgf("error running test: %v", err)
-					continue
-				}
-
-				data, err := json.Marshal(*results)
-				if err != nil {
-					a.Logger.Error().Msgf("error converting test results to json: %v", err)
-					continue
-				}
-				if _, err := a.Storage.Insert(uuid.NewV4().String(), test.Name, data); err != nil {
-					a.Logger.Error().Msgf("error inserting test results: %v", err)
-					continue
-				}
+		case now := <-timer.C:
+			a.timer.heartbeat()
+			if !now.Before(reloadAt) {
+				reload(now)
+				reloadAt = now.Add(interval)
+			}
+			for _, test := range sched.take(now) {
+				a.dispatch(pool, guard, test, now)
 			}
 		}
 	}
 }
 
+// SeedTests stores the tests defined in config. Tests that are already stored
+// are left untouched so changes made through the api survive restarts.
+func (a *App) SeedTests(c *conf.Config) {
+	for _, test := range c.Tests {
+		if err := test.Validate(); err != nil {
+			a.Logger.Error().Msgf("skipping invalid test %q from config: %v", test.Name, err)
+			continue
+		}
+		err := a.Storage.InsertTest(a.ctx, test)
+		switch {
+		case errors.Is(err, model.ErrConflict):
+			a.Logger.Debug().Msgf("test %q already stored", test.Name)
+		case err != nil:
+			a.Logger.Error().Msgf("error seeding test %q: %v", test.Name, err)
+		default:
+			a.Logger.Info().Msgf("seeded test %q from config", test.Name)
+		}
+	}
+}
+
 func InitChans() map[string]chan ChannelMessage {
 	appChans := make(map[string]chan ChannelMessage)
 	appChans[timerChannel] = make(chan ChannelMessage)
@@ -142,12 +300,17 @@ func InitLogger(c *conf.Config) (*zerolog.Logger, error) {
 	return &logger, nil
 }
 
-// InitDatabase bootstraps database and returns app storage.
-func InitDatabase(c *conf.Config) (model.Storage, error) {
-	db, err := model.BootstrapPostgres(c.Database)
+// InitDatabase opens the configured storage backend, prepares its schema and
+// returns app storage.
+func InitDatabase(ctx context.Context, c *conf.Config) (model.Storage, error) {
+	db, err := model.OpenStorage(ctx, c.Database)
 	if err != nil {
 		return nil, err
-	} else {
+	}
+	if err := db.Init(ctx); err != nil {
+		return nil, err
+	}
+	if _, ok := db.(model.PostgresStorage); ok {
 		log.Info().Msgf("database ssl status is %s", c.Database.SslMode)
 	}
 	return db, nil
@@ -1,6 +1,7 @@
 package app
 
 import (
+	"context"
 	"reflect"
 	"testing"
 
@@ -27,7 +28,7 @@ func TestInitDatabase(t *testing.T) {
 	}
 	for name, tt := range tests {
 		t.Run(name, func(t *testing.T) {
-			got, err := InitDatabase(tt.args.c)
+			got, err := InitDatabase(context.Background(), tt.args.c)
 			if (err != nil) != tt.wantErr {
 				t.Errorf("InitDatabase() error = %v, wantErr %v", err, tt.wantErr)
 				return
@@ -0,0 +1,152 @@
+package app
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/javking07/toadlester/artifacts"
+)
+
+// defaultPlotInterval is the plot resolution when no interval is requested.
+const defaultPlotInterval = time.Second
+
+// getResultRaw downloads the gzip compressed raw results of the run stored
+// with the given id, in the vegeta encoding named by the X-Results-Format
+// header.
+func (a *App) getResultRaw(w http.ResponseWriter, r *http.Request) {
+	id, ok := resultID(w, r)
+	if !ok {
+		return
+	}
+	format, rc, ok := a.openArtifact(w, r, id)
+	if !ok {
+		return
+	}
+	defer rc.Close()
+
+	w.Header().Set("Content-Type", "application/gzip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", id+"."+format+".gz"))
+	w.Header().Set("X-Results-Format", format)
+	if _, err := io.Copy(w, rc); err != nil {
+		a.Logger.Error().Msgf("error sending raw results of %s: %v", id, err)
+	}
+}
+
+// getResultAnalysis recomputes the summary of the run stored with the given
+// id from its raw results. Latency `quantiles` (comma separated, between 0
+// and 1), histogram `buckets` (vegeta syntax, e.g. `[0,10ms,100ms]`) and the
+// time series `interval` can be chosen.
+func (a *App) getResultAnalysis(w http.ResponseWriter, r *http.Request) {
+	id, ok := resultID(w, r)
+	if !ok {
+		return
+	}
+	opts, err := analysisOptions(r)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	analysis, ok := a.analyzeArtifact(w, r, id, opts)
+	if !ok {
+		return
+	}
+	respondWithJSON(w, http.StatusOK, analysis)
+}
+
+// getResultPlot renders the mean and max latency of the run stored with the
+// given id over time as an svg chart, one point per `interval` (default 1s).
+func (a *App) getResultPlot(w http.ResponseWriter, r *http.Request) {
+	id, ok := resultID(w, r)
+	if !ok {
+		return
+	}
+	opts, err := analysisOptions(r)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if opts.Interval == 0 {
+		opts.Interval = defaultPlotInterval
+	}
+	analysis, ok := a.analyzeArtifact(w, r, id, opts)
+	if !ok {
+		return
+	}
+
+	w.Header().Set("Content-Type", "image/svg+xml")
+	if err := artifacts.WritePlot(w, "toadlester run "+id, analysis); err != nil {
+		a.Logger.Error().Msgf("error sending plot of %s: %v", id, err)
+	}
+}
+
+// analyzeArtifact analyses the raw results of a run, responding with an
+// error if they cannot be read.
+func (a *App) analyzeArtifact(w http.ResponseWriter, r *http.Request, id string, opts artifacts.AnalysisOptions) (artifacts.Analysis, bool) {
+	format, rc, ok := a.openArtifact(w, r, id)
+	if !ok {
+		return artifacts.Analysis{}, false
+	}
+	defer rc.Close()
+
+	dec, gz, err := artifacts.NewDecoder(format, rc)
+	if err == nil {
+		defer gz.Close()
+		var analysis artifacts.Analysis
+		if analysis, err = artifacts.Analyze(dec, opts); err == nil {
+			return analysis, true
+		}
+	}
+	a.Logger.Error().Msgf("error reading raw results of %s: %v", id, err)
+	respondWithError(w, http.StatusInternalServerError, "error reading raw results")
+	return artifacts.Analysis{}, false
+}
+
+// openArtifact opens the raw results of a run, responding with an error if
+// they cannot be read.
+func (a *App) openArtifact(w http.ResponseWriter, r *http.Request, id string) (string, io.ReadCloser, bool) {
+	if a.Artifacts == nil {
+		respondWithError(w, http.StatusNotFound, "raw results are not kept")
+		return "", nil, false
+	}
+	format, rc, err := a.Artifacts.Open(r.Context(), id)
+	if err != nil {
+		respondWithStorageError(w, err)
+		return "", nil, false
+	}
+	return format, rc, true
+}
+
+// analysisOptions builds analysis options from the request query params.
+func analysisOptions(r *http.Request) (artifacts.AnalysisOptions, error) {
+	params := r.URL.Query()
+	var opts artifacts.AnalysisOptions
+
+	if value := params.Get("quantiles"); value != "" {
+		for _, part := range strings.Split(value, ",") {
+			q, err := strconv.ParseFloat(strings.TrimSpace(part), 64)
+			if err != nil || q < 0 || q > 1 {
+				return opts, fmt.Errorf("invalid quantile %q", part)
+			}
+			opts.Quantiles = append(opts.Quantiles, q)
+		}
+	}
+	if value := params.Get("buckets"); value != "" {
+		if err := opts.Buckets.UnmarshalText([]byte(value)); err != nil {
+			return opts, errors.New("invalid buckets")
+		}
+	}
+	if value := params.Get("interval"); value != "" {
+		interval, err := time.ParseDuration(value)
+		if err != nil || interval <= 0 {
+			return opts, errors.New("invalid interval")
+		}
+		opts.Interval = interval
+	}
+	return opts, nil
+}
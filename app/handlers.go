@@ -0,0 +1,366 @@
+package app
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/javking07/toadlester/conf"
+	"github.com/javking07/toadlester/model"
+	uuid "github.com/satori/go.uuid"
+	vegeta "github.com/tsenart/vegeta/lib"
+)
+
+const (
+	defaultCount = 10
+	maxCount     = 100
+
+	// adHocTestName names ad-hoc runs that do not carry a name.
+	adHocTestName = "adhoc"
+)
+
+// getResults returns a page of stored test results, newest first by default.
+// Results can be filtered by `name`, `since`/`until` (RFC 3339) and `label`
+// (`key:value`, repeatable), sorted with `sort` and `order`, and paged with
+// `count` and either `start` or the `cursor` returned in the X-Next-Cursor
+// header.
+func (a *App) getResults(w http.ResponseWriter, r *http.Request) {
+	query, err := resultQuery(r)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	results, next, err := a.Storage.Query(r.Context(), query)
+	if err != nil {
+		if !errors.Is(err, model.ErrInvalidQuery) {
+			a.Logger.Error().Msgf("error selecting results: %v", err)
+		}
+		respondWithStorageError(w, err)
+		return
+	}
+	if next != "" {
+		w.Header().Set("X-Next-Cursor", next)
+	}
+	respondWithJSON(w, http.StatusOK, results)
+}
+
+// resultQuery builds a result query from the request query params.
+func resultQuery(r *http.Request) (model.ResultQuery, error) {
+	params := r.URL.Query()
+	query := model.ResultQuery{
+		Name:       params.Get("name"),
+		SortBy:     params.Get("sort"),
+		Descending: true,
+		Cursor:     params.Get("cursor"),
+	}
+
+	var err error
+	if query.Limit, err = queryInt(r, "count", defaultCount); err != nil {
+		return query, errors.New("invalid count")
+	}
+	if query.Limit > maxCount || query.Limit < 1 {
+		query.Limit = defaultCount
+	}
+	if query.Offset, err = queryInt(r, "start", 0); err != nil {
+		return query, errors.New("invalid start")
+	}
+	if query.Offset < 0 {
+		query.Offset = 0
+	}
+
+	switch params.Get("order") {
+	case "", "desc":
+	case "asc":
+		query.Descending = false
+	default:
+		return query, errors.New("order must be asc or desc")
+	}
+
+	if query.Since, err = queryTime(r, "since"); err != nil {
+		return query, errors.New("invalid since")
+	}
+	if query.Until, err = queryTime(r, "until"); err != nil {
+		return query, errors.New("invalid until")
+	}
+
+	for _, label := range params["label"] {
+		parts := strings.SplitN(label, ":", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			return query, fmt.Errorf("invalid label %q, expected key:value", label)
+		}
+		if query.Labels == nil {
+			query.Labels = make(map[string]string)
+		}
+		query.Labels[parts[0]] = parts[1]
+	}
+	return query, nil
+}
+
+// getResult returns the stored test result with the given id.
+func (a *App) getResult(w http.ResponseWriter, r *http.Request) {
+	id, ok := resultID(w, r)
+	if !ok {
+		return
+	}
+
+	result, err := a.Storage.Select(r.Context(), id)
+	if err != nil {
+		respondWithStorageError(w, err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, result)
+}
+
+// updateResult replaces the name and data of the stored test result with the
+// given id.
+func (a *App) updateResult(w http.ResponseWriter, r *http.Request) {
+	id, ok := resultID(w, r)
+	if !ok {
+		return
+	}
+
+	var payload model.Payload
+	decoder := json.NewDecoder(r.Body)
+	if err := decoder.Decode(&payload); err != nil {
+		respondWithError(w, http.StatusBadRequest, "invalid request payload")
+		return
+	}
+	defer r.Body.Close()
+
+	if payload.Name == "" {
+		respondWithError(w, http.StatusBadRequest, "name is required")
+		return
+	}
+
+	if err := a.Storage.Update(r.Context(), id, payload); err != nil {
+		respondWithStorageError(w, err)
+		return
+	}
+	payload.ID = id
+	respondWithJSON(w, http.StatusOK, payload)
+}
+
+// deleteResult removes the stored test result with the given id.
+func (a *App) deleteResult(w http.ResponseWriter, r *http.Request) {
+	id, ok := resultID(w, r)
+	if !ok {
+		return
+	}
+
+	if err := a.Storage.Delete(r.Context(), id); err != nil {
+		respondWithStorageError(w, err)
+		return
+	}
+	if a.Artifacts != nil {
+		if err := a.Artifacts.Delete(r.Context(), id); err != nil {
+			a.Logger.Error().Msgf("error deleting raw results of %s: %v", id, err)
+		}
+	}
+	respondWithJSON(w, http.StatusOK, map[string]string{"result": "success"})
+}
+
+// getTests returns every stored test definition.
+func (a *App) getTests(w http.ResponseWriter, r *http.Request) {
+	tests, err := a.Storage.SelectTests(r.Context())
+	if err != nil {
+		respondWithStorageError(w, err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, tests)
+}
+
+// getTest returns the stored test definition with the given name.
+func (a *App) getTest(w http.ResponseWriter, r *http.Request) {
+	test, err := a.Storage.SelectTest(r.Context(), chi.URLParam(r, "name"))
+	if err != nil {
+		respondWithStorageError(w, err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, test)
+}
+
+// createTest stores a new test definition. It is picked up by the timer on
+// its next tick.
+func (a *App) createTest(w http.ResponseWriter, r *http.Request) {
+	test, ok := decodeTest(w, r, "")
+	if !ok {
+		return
+	}
+
+	if err := a.Storage.InsertTest(r.Context(), test); err != nil {
+		respondWithStorageError(w, err)
+		return
+	}
+	respondWithJSON(w, http.StatusCreated, test)
+}
+
+// updateTest replaces the stored test definition with the given name. The
+// test is renamed when the body carries a different name.
+func (a *App) updateTest(w http.ResponseWriter, r *http.Request) {
+	name := chi.URLParam(r, "name")
+	test, ok := decodeTest(w, r, name)
+	if !ok {
+		return
+	}
+
+	if err := a.Storage.UpdateTest(r.Context(), name, test); err != nil {
+		respondWithStorageError(w, err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, test)
+}
+
+// deleteTest removes the stored test definition with the given name.
+func (a *App) deleteTest(w http.ResponseWriter, r *http.Request) {
+	if err := a.Storage.DeleteTest(r.Context(), chi.URLParam(r, "name")); err != nil {
+		respondWithStorageError(w, err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, map[string]string{"result": "success"})
+}
+
+// createTestRun starts an on-demand run of the stored test with the given
+// name. It responds immediately with the queued run; callers poll
+// /runs/{id} for its progress.
+func (a *App) createTestRun(w http.ResponseWriter, r *http.Request) {
+	test, err := a.Storage.SelectTest(r.Context(), chi.URLParam(r, "name"))
+	if err != nil {
+		respondWithStorageError(w, err)
+		return
+	}
+	run, err := a.StartRun(test)
+	respondWithRun(w, run, err)
+}
+
+// createRun starts an on-demand run of the ad-hoc test in the request body.
+func (a *App) createRun(w http.ResponseWriter, r *http.Request) {
+	var test model.LoadTestSimple
+	decoder := json.NewDecoder(r.Body)
+	if err := decoder.Decode(&test); err != nil {
+		respondWithError(w, http.StatusBadRequest, "invalid request payload")
+		return
+	}
+	defer r.Body.Close()
+
+	if err := test.Validate(); err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if test.BodyFile != "" {
+		respondWithError(w, http.StatusBadRequest, "bodyFile is only allowed in the config file")
+		return
+	}
+	if test.Name == "" {
+		test.Name = adHocTestName
+	}
+
+	targets := func() ([]vegeta.Target, error) { return inlineTargets([]conf.TargetConfig{test.TargetConfig}) }
+	run, err := a.startRun(conf.TestConfig{
+		Name:     test.Name,
+		Duration: conf.NewDuration(test.Duration.Duration),
+		TPS:      test.TPS,
+	}, targets)
+	respondWithRun(w, run, err)
+}
+
+// getRuns returns every tracked on-demand run.
+func (a *App) getRuns(w http.ResponseWriter, r *http.Request) {
+	respondWithJSON(w, http.StatusOK, a.Runs.List())
+}
+
+// getRun returns the state of the on-demand run with the given id, including
+// its metrics once it has finished.
+func (a *App) getRun(w http.ResponseWriter, r *http.Request) {
+	run, ok := a.Runs.Get(chi.URLParam(r, "id"))
+	if !ok {
+		respondWithError(w, http.StatusNotFound, "run not found")
+		return
+	}
+	respondWithJSON(w, http.StatusOK, run)
+}
+
+// respondWithRun responds with a newly queued run and points the caller to
+// where its state can be polled, or with the error that kept it from
+// starting.
+func respondWithRun(w http.ResponseWriter, run Run, err error) {
+	switch {
+	case errors.Is(err, ErrTooManyRuns):
+		respondWithError(w, http.StatusTooManyRequests, err.Error())
+		return
+	case err != nil:
+		respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	w.Header().Set("Location", "/runs/"+run.ID)
+	respondWithJSON(w, http.StatusAccepted, run)
+}
+
+// decodeTest reads and validates a test definition from the request body,
+// responding with an error if it is invalid. The given name is used when the
+// body does not carry one. Target and body files are only read for tests from
+// the config file, as they would let clients read any file the server can
+// read, or send it to a url of their choosing.
+func decodeTest(w http.ResponseWriter, r *http.Request, name string) (conf.TestConfig, bool) {
+	var test conf.TestConfig
+	decoder := json.NewDecoder(r.Body)
+	if err := decoder.Decode(&test); err != nil {
+		respondWithError(w, http.StatusBadRequest, "invalid request payload")
+		return test, false
+	}
+	defer r.Body.Close()
+
+	if test.Name == "" {
+		test.Name = name
+	}
+	if err := test.Validate(); err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return test, false
+	}
+	if test.Target != "" {
+		respondWithError(w, http.StatusBadRequest, "target files are only allowed in the config file, declare targets inline")
+		return test, false
+	}
+	for i, target := range test.Targets {
+		if target.BodyFile != "" {
+			respondWithError(w, http.StatusBadRequest, fmt.Sprintf("target %d: bodyFile is only allowed in the config file", i))
+			return test, false
+		}
+	}
+	return test, true
+}
+
+// resultID extracts and validates the result id url param, responding with an
+// error if it is not a valid uuid.
+func resultID(w http.ResponseWriter, r *http.Request) (string, bool) {
+	id := chi.URLParam(r, "id")
+	if _, err := uuid.FromString(id); err != nil {
+		respondWithError(w, http.StatusBadRequest, "invalid result id")
+		return "", false
+	}
+	return id, true
+}
+
+// queryTime reads an RFC 3339 time query param, returning the zero time when
+// it is unset.
+func queryTime(r *http.Request, key string) (time.Time, error) {
+	value := r.URL.Query().Get(key)
+	if value == "" {
+		return time.Time{}, nil
+	}
+	return time.Parse(time.RFC3339, value)
+}
+
+// queryInt reads an integer query param, falling back to def when it is unset.
+func queryInt(r *http.Request, key string, def int) (int, error) {
+	value := r.URL.Query().Get(key)
+	if value == "" {
+		return def, nil
+	}
+	return strconv.Atoi(value)
+}
@@ -0,0 +1,132 @@
+package app
+
+import (
+	"net/http"
+	"sync"
+	"time"
+)
+
+// defaultStaleIntervals is the number of timer intervals without a heartbeat
+// after which the timer is considered stuck.
+const defaultStaleIntervals = 3
+
+// TimerStatus describes the state of the background timer process.
+type TimerStatus struct {
+	Running       bool       `json:"running"`
+	Stale         bool       `json:"stale"`
+	LastHeartbeat *time.Time `json:"lastHeartbeat,omitempty"`
+	LastSuccess   *time.Time `json:"lastSuccess,omitempty"`
+}
+
+// timerState records the liveness of the background timer process and the
+// time of the last successful test run.
+type timerState struct {
+	mu            sync.RWMutex
+	running       bool
+	lastHeartbeat time.Time
+	lastSuccess   time.Time
+	staleAfter    time.Duration
+}
+
+// start marks the timer as running. It is considered stuck if it does not
+// beat again within staleAfter.
+func (s *timerState) start(staleAfter time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.running = true
+	s.staleAfter = staleAfter
+	s.lastHeartbeat = time.Now()
+}
+
+func (s *timerState) stop() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.running = false
+}
+
+// heartbeat records that the timer loop is making progress.
+func (s *timerState) heartbeat() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.lastHeartbeat = time.Now()
+}
+
+// succeeded records a successful test run.
+func (s *timerState) succeeded() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.lastSuccess = time.Now()
+}
+
+func (s *timerState) status() TimerStatus {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	status := TimerStatus{Running: s.running}
+	if !s.lastHeartbeat.IsZero() {
+		heartbeat := s.lastHeartbeat
+		status.LastHeartbeat = &heartbeat
+		status.Stale = s.running && time.Since(heartbeat) > s.staleAfter
+	}
+	if !s.lastSuccess.IsZero() {
+		success := s.lastSuccess
+		status.LastSuccess = &success
+	}
+	return status
+}
+
+// healthy reports whether the timer is running and not stuck.
+func (t TimerStatus) healthy() bool {
+	return t.Running && !t.Stale
+}
+
+// DatabaseStatus describes the state of the storage backend.
+type DatabaseStatus struct {
+	Healthy bool   `json:"healthy"`
+	Error   string `json:"error,omitempty"`
+}
+
+// Health is the body of the health and readiness endpoints.
+type Health struct {
+	Status   string          `json:"status"`
+	Database *DatabaseStatus `json:"database,omitempty"`
+	Timer    TimerStatus     `json:"timer"`
+	// Spooled counts results waiting for storage to recover.
+	Spooled int `json:"spooled,omitempty"`
+}
+
+// healthz is the liveness probe. It fails when the timer process has died or
+// stopped making progress, so that a stuck instance gets restarted. Storage is
+// left out on purpose; a database outage should not restart toadlester.
+func (a *App) healthz(w http.ResponseWriter, r *http.Request) {
+	health := Health{Status: "ok", Timer: a.timer.status()}
+	if !health.Timer.healthy() {
+		health.Status = "unhealthy"
+		respondWithJSON(w, http.StatusServiceUnavailable, health)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, health)
+}
+
+// readyz is the readiness probe. It reports the state of storage and the
+// timer process along with the time of the last successful run.
+func (a *App) readyz(w http.ResponseWriter, r *http.Request) {
+	health := Health{
+		Status:   "ready",
+		Database: &DatabaseStatus{Healthy: true},
+		Timer:    a.timer.status(),
+	}
+	if err := a.Storage.Healthy(r.Context()); err != nil {
+		health.Database = &DatabaseStatus{Error: err.Error()}
+	}
+	if a.Spool != nil {
+		health.Spooled = a.Spool.Len()
+	}
+
+	if !health.Database.Healthy || !health.Timer.healthy() {
+		health.Status = "not ready"
+		respondWithJSON(w, http.StatusServiceUnavailable, health)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, health)
+}
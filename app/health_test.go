@@ -0,0 +1,44 @@
+package app
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTimerState(t *testing.T) {
+	var s timerState
+
+	status := s.status()
+	assert.False(t, status.healthy())
+	assert.Nil(t, status.LastHeartbeat)
+
+	s.start(time.Hour)
+	status = s.status()
+	assert.True(t, status.healthy())
+	assert.NotNil(t, status.LastHeartbeat)
+	assert.Nil(t, status.LastSuccess)
+
+	s.succeeded()
+	assert.NotNil(t, s.status().LastSuccess)
+
+	s.stop()
+	assert.False(t, s.status().healthy())
+}
+
+func TestTimerState_Stale(t *testing.T) {
+	var s timerState
+
+	s.start(time.Millisecond)
+	time.Sleep(5 * time.Millisecond)
+	status := s.status()
+	assert.True(t, status.Stale)
+	assert.False(t, status.healthy())
+
+	s.heartbeat()
+	s.mu.Lock()
+	s.staleAfter = time.Hour
+	s.mu.Unlock()
+	assert.True(t, s.status().healthy())
+}
@@ -0,0 +1,187 @@
+package app
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	vegeta "github.com/tsenart/vegeta/lib"
+)
+
+// MetricsRegistry keeps the latest vegeta metrics and run counters of each
+// test and exposes them in the prometheus text format.
+type MetricsRegistry struct {
+	mu       sync.RWMutex
+	latest   map[string]vegeta.Metrics
+	lastRun  map[string]time.Time
+	runs     map[string]uint64
+	failures map[string]uint64
+	skipped  map[string]uint64
+}
+
+// NewMetricsRegistry returns an empty metrics registry.
+func NewMetricsRegistry() *MetricsRegistry {
+	return &MetricsRegistry{
+		latest:   make(map[string]vegeta.Metrics),
+		lastRun:  make(map[string]time.Time),
+		runs:     make(map[string]uint64),
+		failures: make(map[string]uint64),
+		skipped:  make(map[string]uint64),
+	}
+}
+
+// Record counts a run of the named test. The latest metrics of the test are
+// replaced when metrics is not nil.
+func (m *MetricsRegistry) Record(test string, metrics *vegeta.Metrics, failed bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.runs[test]++
+	if failed {
+		m.failures[test]++
+	}
+	if metrics != nil {
+		m.latest[test] = *metrics
+		m.lastRun[test] = time.Now()
+	}
+}
+
+// RecordSkipped counts a scheduled run of the named test that was skipped.
+func (m *MetricsRegistry) RecordSkipped(test string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.skipped[test]++
+}
+
+// WriteTo writes every metric in the prometheus text exposition format.
+func (m *MetricsRegistry) WriteTo(w io.Writer) (int64, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	e := &expositionWriter{w: w}
+
+	tests := make([]string, 0, len(m.runs))
+	for test := range m.runs {
+		tests = append(tests, test)
+	}
+	for test := range m.skipped {
+		if _, ok := m.runs[test]; !ok {
+			tests = append(tests, test)
+		}
+	}
+	sort.Strings(tests)
+	e.family("toadlester_runs_total", "counter", "Number of test runs.")
+	for _, test := range tests {
+		e.sample("toadlester_runs_total", labels("test", test), float64(m.runs[test]))
+	}
+	e.family("toadlester_run_failures_total", "counter", "Number of test runs that failed to run or store their results.")
+	for _, test := range tests {
+		e.sample("toadlester_run_failures_total", labels("test", test), float64(m.failures[test]))
+	}
+	e.family("toadlester_runs_skipped_total", "counter", "Number of scheduled runs skipped because the previous run was still in progress.")
+	for _, test := range tests {
+		e.sample("toadlester_runs_skipped_total", labels("test", test), float64(m.skipped[test]))
+	}
+
+	tests = tests[:0]
+	for test := range m.latest {
+		tests = append(tests, test)
+	}
+	sort.Strings(tests)
+	gauges := []struct {
+		name, help string
+		value      func(vegeta.Metrics) float64
+	}{
+		{"toadlester_latency_mean_seconds", "Mean request latency of the last run.", func(v vegeta.Metrics) float64 { return v.Latencies.Mean.Seconds() }},
+		{"toadlester_latency_max_seconds", "Maximum request latency of the last run.", func(v vegeta.Metrics) float64 { return v.Latencies.Max.Seconds() }},
+		{"toadlester_success_ratio", "Ratio of successful requests in the last run.", func(v vegeta.Metrics) float64 { return v.Success }},
+		{"toadlester_rate_requests_per_second", "Request rate achieved by the last run.", func(v vegeta.Metrics) float64 { return v.Rate }},
+		{"toadlester_requests", "Number of requests sent by the last run.", func(v vegeta.Metrics) float64 { return float64(v.Requests) }},
+		{"toadlester_bytes_in", "Bytes received by the last run.", func(v vegeta.Metrics) float64 { return float64(v.BytesIn.Total) }},
+		{"toadlester_bytes_out", "Bytes sent by the last run.", func(v vegeta.Metrics) float64 { return float64(v.BytesOut.Total) }},
+	}
+	for _, g := range gauges {
+		e.family(g.name, "gauge", g.help)
+		for _, test := range tests {
+			e.sample(g.name, labels("test", test), g.value(m.latest[test]))
+		}
+	}
+
+	e.family("toadlester_latency_seconds", "gauge", "Request latency quantiles of the last run.")
+	for _, test := range tests {
+		latencies := m.latest[test].Latencies
+		for _, q := range []struct {
+			quantile string
+			value    time.Duration
+		}{{"0.5", latencies.P50}, {"0.95", latencies.P95}, {"0.99", latencies.P99}} {
+			e.sample("toadlester_latency_seconds", labels("test", test, "quantile", q.quantile), q.value.Seconds())
+		}
+	}
+
+	e.family("toadlester_status_codes", "gauge", "Responses per status code in the last run.")
+	for _, test := range tests {
+		codes := m.latest[test].StatusCodes
+		sorted := make([]string, 0, len(codes))
+		for code := range codes {
+			sorted = append(sorted, code)
+		}
+		sort.Strings(sorted)
+		for _, code := range sorted {
+			e.sample("toadlester_status_codes", labels("test", test, "code", code), float64(codes[code]))
+		}
+	}
+
+	e.family("toadlester_last_run_timestamp_seconds", "gauge", "Unix time at which the last run finished.")
+	for _, test := range tests {
+		e.sample("toadlester_last_run_timestamp_seconds", labels("test", test), float64(m.lastRun[test].UnixNano())/1e9)
+	}
+
+	return e.n, e.err
+}
+
+// ServeHTTP serves the registry in the prometheus text format.
+func (m *MetricsRegistry) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	_, _ = m.WriteTo(w)
+}
+
+// expositionWriter writes prometheus text format lines, keeping the first
+// write error.
+type expositionWriter struct {
+	w   io.Writer
+	n   int64
+	err error
+}
+
+func (e *expositionWriter) family(name, kind, help string) {
+	e.printf("# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
+
+func (e *expositionWriter) sample(name, labels string, value float64) {
+	e.printf("%s{%s} %v\n", name, labels, value)
+}
+
+func (e *expositionWriter) printf(format string, args ...interface{}) {
+	if e.err != nil {
+		return
+	}
+	n, err := fmt.Fprintf(e.w, format, args...)
+	e.n += int64(n)
+	e.err = err
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// labels formats alternating label names and values.
+func labels(pairs ...string) string {
+	formatted := make([]string, 0, len(pairs)/2)
+	for i := 0; i+1 < len(pairs); i += 2 {
+		formatted = append(formatted, fmt.Sprintf(`%s="%s"`, pairs[i], labelEscaper.Replace(pairs[i+1])))
+	}
+	return strings.Join(formatted, ",")
+}
@@ -0,0 +1,62 @@
+package app
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	vegeta "github.com/tsenart/vegeta/lib"
+)
+
+func TestMetricsRegistry_WriteTo(t *testing.T) {
+	m := NewMetricsRegistry()
+
+	metrics := vegeta.Metrics{
+		Latencies: vegeta.LatencyMetrics{
+			Mean: 20 * time.Millisecond,
+			P50:  10 * time.Millisecond,
+			P95:  50 * time.Millisecond,
+			P99:  100 * time.Millisecond,
+			Max:  time.Second,
+		},
+		BytesIn:     vegeta.ByteMetrics{Total: 2048},
+		BytesOut:    vegeta.ByteMetrics{Total: 512},
+		Requests:    100,
+		Rate:        10,
+		Success:     0.99,
+		StatusCodes: map[string]int{"200": 99, "500": 1},
+	}
+	m.Record("test1", &metrics, false)
+	m.Record("test1", nil, true)
+	m.Record(`quote"d`, nil, true)
+	m.RecordSkipped("test1")
+	m.RecordSkipped("idle")
+
+	var b bytes.Buffer
+	n, err := m.WriteTo(&b)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(b.Len()), n)
+
+	out := b.String()
+	for _, line := range []string{
+		"# TYPE toadlester_runs_total counter",
+		`toadlester_runs_total{test="test1"} 2`,
+		`toadlester_run_failures_total{test="test1"} 1`,
+		`toadlester_run_failures_total{test="quote\"d"} 1`,
+		`toadlester_runs_skipped_total{test="test1"} 1`,
+		`toadlester_runs_skipped_total{test="idle"} 1`,
+		`toadlester_runs_total{test="idle"} 0`,
+		`toadlester_latency_mean_seconds{test="test1"} 0.02`,
+		`toadlester_latency_max_seconds{test="test1"} 1`,
+		`toadlester_latency_seconds{test="test1",quantile="0.99"} 0.1`,
+		`toadlester_success_ratio{test="test1"} 0.99`,
+		`toadlester_rate_requests_per_second{test="test1"} 10`,
+		`toadlester_bytes_in{test="test1"} 2048`,
+		`toadlester_bytes_out{test="test1"} 512`,
+		`toadlester_status_codes{test="test1",code="500"} 1`,
+	} {
+		assert.Contains(t, out, line+"\n")
+	}
+	assert.NotContains(t, out, `toadlester_success_ratio{test="quote\"d"}`)
+}
@@ -0,0 +1,221 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/javking07/toadlester/conf"
+	"github.com/javking07/toadlester/model"
+	uuid "github.com/satori/go.uuid"
+	vegeta "github.com/tsenart/vegeta/lib"
+)
+
+// overlapGuard tracks the scheduled run in progress of every test, so that a
+// run due while the previous one is still in progress follows the overlap
+// policy of its test. A run is in progress from the moment it is handed to
+// the worker pool until it finished.
+type overlapGuard struct {
+	mu     sync.Mutex
+	active map[string]*guardedRun
+	queued map[string]*guardedRun
+}
+
+// guardedRun is a scheduled run waiting for or holding its test's slot.
+type guardedRun struct {
+	id  string
+	ctx *runContext
+	// start hands the run to the worker pool and reports false when the pool
+	// has no room for it, skip records that it never ran.
+	start func() bool
+	skip  func(reason string)
+}
+
+// reasonQueueFull is why runs the worker pool has no room for are skipped.
+const reasonQueueFull = "the run queue is full"
+
+func newOverlapGuard() *overlapGuard {
+	return &overlapGuard{
+		active: make(map[string]*guardedRun),
+		queued: make(map[string]*guardedRun),
+	}
+}
+
+// admit starts, queues or skips run of test according to policy. Runs that
+// are skipped as a consequence are reported once the guard is unlocked, as
+// recording them goes to storage.
+func (g *overlapGuard) admit(test, policy string, run *guardedRun) {
+	var skipped []func()
+	defer func() {
+		for _, skip := range skipped {
+			skip()
+		}
+	}()
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	active, ok := g.active[test]
+	if !ok {
+		if skip := g.begin(test, run); skip != nil {
+			skipped = append(skipped, skip)
+		}
+		return
+	}
+
+	switch policy {
+	case conf.OverlapQueue:
+		queued, ok := g.queued[test]
+		if !ok {
+			g.queued[test] = run
+			return
+		}
+		reason := fmt.Sprintf("run %s is still in progress and run %s is already queued", active.id, queued.id)
+		skipped = append(skipped, func() { run.skip(reason) })
+
+	case conf.OverlapCancelPrevious:
+		active.ctx.supersede(run.id)
+		if queued, ok := g.queued[test]; ok {
+			// left over from a queue policy the test had before
+			delete(g.queued, test)
+			reason := fmt.Sprintf("superseded by run %s", run.id)
+			skipped = append(skipped, func() { queued.skip(reason) })
+		}
+		if skip := g.begin(test, run); skip != nil {
+			skipped = append(skipped, skip)
+		}
+
+	default:
+		reason := fmt.Sprintf("run %s is still in progress", active.id)
+		skipped = append(skipped, func() { run.skip(reason) })
+	}
+}
+
+// done releases the slot of test held by the run with the given id and
+// starts the run queued behind it, if any. Superseded runs no longer hold the
+// slot and release nothing.
+func (g *overlapGuard) done(test, id string) {
+	var skipped func()
+	defer func() {
+		if skipped != nil {
+			skipped()
+		}
+	}()
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if active, ok := g.active[test]; !ok || active.id != id {
+		return
+	}
+	delete(g.active, test)
+	if queued, ok := g.queued[test]; ok {
+		delete(g.queued, test)
+		skipped = g.begin(test, queued)
+	}
+}
+
+// begin starts run and gives it the slot of test. A run the worker pool has
+// no room for leaves the slot as it was; begin then returns the skip to
+// report once the guard is unlocked.
+func (g *overlapGuard) begin(test string, run *guardedRun) func() {
+	if !run.start() {
+		return func() { run.skip(reasonQueueFull) }
+	}
+	g.active[test] = run
+	return nil
+}
+
+// runContext is the context of a scheduled run. It is cancelled along with
+// its parent, or when the run is superseded by a later one; Err then says by
+// which.
+type runContext struct {
+	context.Context
+	cancel context.CancelFunc
+
+	mu  sync.Mutex
+	err error
+}
+
+func newRunContext(parent context.Context) *runContext {
+	ctx, cancel := context.WithCancel(parent)
+	return &runContext{Context: ctx, cancel: cancel}
+}
+
+// supersede cancels the run in favour of the run with the given id.
+func (c *runContext) supersede(id string) {
+	c.mu.Lock()
+	if c.err == nil && c.Context.Err() == nil {
+		c.err = fmt.Errorf("superseded by run %s: %w", id, context.Canceled)
+	}
+	c.mu.Unlock()
+	c.cancel()
+}
+
+func (c *runContext) Err() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.err != nil {
+		return c.err
+	}
+	return c.Context.Err()
+}
+
+// dispatch hands a scheduled run of test that was due at due to pool, unless
+// the overlap policy of the test says otherwise.
+func (a *App) dispatch(pool *workerPool, guard *overlapGuard, test conf.TestConfig, due time.Time) {
+	run := a.Runs.Add(uuid.NewV4().String(), test.Name, TriggerSchedule)
+	ctx := newRunContext(a.ctx)
+	guarded := &guardedRun{id: run.ID, ctx: ctx}
+	guarded.start = func() bool {
+		ok := pool.submit(func() {
+			defer ctx.cancel()
+			a.track(ctx, run, test, func() ([]vegeta.Target, error) { return testTargets(test) })
+			guard.done(test.Name, run.ID)
+			a.timer.heartbeat()
+		})
+		if ok {
+			a.Logger.Info().Msgf("queued run %s of test %s", run.ID, test.Name)
+		}
+		return ok
+	}
+	guarded.skip = func(reason string) {
+		ctx.cancel()
+		// recording the skip goes to storage, which must not hold up the timer
+		a.goProduce(func() { a.skipRun(a.ctx, run, test, due, reason) })
+	}
+	guard.admit(test.Name, test.Overlap, guarded)
+}
+
+// skipRun records a scheduled run of test that was due at due but skipped
+// for the given reason. Skipped runs are stored without metrics, also once
+// ctx is done, as runs are skipped on shutdown too.
+func (a *App) skipRun(ctx context.Context, run Run, test conf.TestConfig, due time.Time, reason string) {
+	a.Logger.Warn().Str("run", run.ID).Str("test", test.Name).Msgf("skipping run of test %s: %s", test.Name, reason)
+	a.Metrics.RecordSkipped(test.Name)
+
+	payload := model.Payload{
+		ID:        uuid.NewV4().String(),
+		Name:      test.Name,
+		StartedAt: &due,
+		EndedAt:   &due,
+		Rate:      float64(test.TPS),
+		Status:    model.StatusSkipped,
+		Labels:    test.Labels,
+	}
+	var err error
+	payload.Data, err = json.Marshal(map[string]string{"reason": reason})
+	if err == nil {
+		store, cancel := storeContext(ctx)
+		err = a.insertResult(store, payload)
+		cancel()
+	}
+	if err != nil {
+		a.Logger.Error().Msgf("error inserting skipped run of test %s: %v", test.Name, err)
+		payload.ID = ""
+	}
+	a.Runs.Skip(run.ID, payload.ID, reason)
+}
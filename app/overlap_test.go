@@ -0,0 +1,173 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/javking07/toadlester/conf"
+	"github.com/javking07/toadlester/model"
+)
+
+func TestOverlapGuard(t *testing.T) {
+	tests := map[string]struct {
+		policy         string
+		started        []string
+		skipped        []string
+		superseded     bool
+		afterFirstDone []string
+	}{
+		"skip":            {policy: "", started: []string{"1"}, skipped: []string{"2", "3"}, afterFirstDone: []string{"1"}},
+		"queue":           {policy: conf.OverlapQueue, started: []string{"1"}, skipped: []string{"3"}, afterFirstDone: []string{"1", "2"}},
+		"cancel previous": {policy: conf.OverlapCancelPrevious, started: []string{"1", "2", "3"}, superseded: true, afterFirstDone: []string{"1", "2", "3"}},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			guard := newOverlapGuard()
+			var started, skipped []string
+			runs := make(map[string]*guardedRun)
+			for _, id := range []string{"1", "2", "3"} {
+				id := id
+				runs[id] = &guardedRun{
+					id:    id,
+					ctx:   newRunContext(context.Background()),
+					start: func() bool { started = append(started, id); return true },
+					skip:  func(string) { skipped = append(skipped, id) },
+				}
+				guard.admit("test", test.policy, runs[id])
+			}
+			assert.Equal(t, test.started, started)
+			assert.Equal(t, test.skipped, skipped)
+
+			err := runs["1"].ctx.Err()
+			if test.superseded {
+				assert.EqualError(t, err, "superseded by run 2: context canceled")
+				assert.True(t, errors.Is(err, context.Canceled))
+			} else {
+				assert.NoError(t, err)
+			}
+
+			guard.done("test", "1")
+			assert.Equal(t, test.afterFirstDone, started)
+			// other tests never wait for each other
+			guard.admit("other", test.policy, &guardedRun{id: "4", start: func() bool { started = append(started, "4"); return true }})
+			assert.Equal(t, append(test.afterFirstDone, "4"), started)
+		})
+	}
+}
+
+func TestOverlapGuard_QueueFull(t *testing.T) {
+	guard := newOverlapGuard()
+	var reasons []string
+	full := &guardedRun{
+		id:    "1",
+		ctx:   newRunContext(context.Background()),
+		start: func() bool { return false },
+		skip:  func(reason string) { reasons = append(reasons, reason) },
+	}
+	guard.admit("test", conf.OverlapQueue, full)
+	assert.Equal(t, []string{reasonQueueFull}, reasons)
+
+	// the slot stays free for the next run
+	started := false
+	guard.admit("test", conf.OverlapQueue, &guardedRun{id: "2", start: func() bool { started = true; return true }})
+	assert.True(t, started)
+
+	// a queued run the pool has no room for is skipped and frees the slot
+	guard.admit("test", conf.OverlapQueue, full)
+	guard.done("test", "2")
+	assert.Equal(t, []string{reasonQueueFull, reasonQueueFull}, reasons)
+	_, active := guard.active["test"]
+	assert.False(t, active)
+}
+
+// blockingStorage is memory storage whose inserts wait for release.
+type blockingStorage struct {
+	model.Storage
+	release chan struct{}
+}
+
+func (s *blockingStorage) Insert(ctx context.Context, payload model.Payload) (int64, error) {
+	<-s.release
+	return s.Storage.Insert(ctx, payload)
+}
+
+func TestApp_DispatchRecordsSkipsInBackground(t *testing.T) {
+	logger := zerolog.Nop()
+	storage := &blockingStorage{Storage: model.NewMemoryStorage(), release: make(chan struct{})}
+	a := App{Storage: storage, Logger: &logger, Runs: NewRunRegistry(10), Metrics: NewMetricsRegistry(), ctx: context.Background()}
+	test := conf.TestConfig{Name: "test1", Duration: conf.NewDuration(time.Second), TPS: 5}
+	guard := newOverlapGuard()
+	guard.active[test.Name] = &guardedRun{id: "run-1"}
+
+	dispatched := make(chan struct{})
+	go func() {
+		a.dispatch(newWorkerPool(1, 1), guard, test, time.Now())
+		close(dispatched)
+	}()
+	select {
+	case <-dispatched:
+	case <-time.After(time.Second):
+		t.Fatal("dispatch waited for storage to record the skip")
+	}
+
+	close(storage.release)
+	a.producers.Wait()
+	runs := a.Runs.List()
+	require.Len(t, runs, 1)
+	assert.Equal(t, RunSkipped, runs[0].State)
+	assert.Equal(t, "run run-1 is still in progress", runs[0].Reason)
+}
+
+func TestApp_SkipRun(t *testing.T) {
+	logger := zerolog.Nop()
+	a := App{Storage: model.NewMemoryStorage(), Logger: &logger, Runs: NewRunRegistry(10), Metrics: NewMetricsRegistry()}
+	test := conf.TestConfig{Name: "test1", Duration: conf.NewDuration(time.Second), TPS: 5, Labels: map[string]string{"env": "prod"}}
+	due := time.Date(2021, 9, 1, 2, 0, 0, 0, time.UTC)
+
+	run := a.Runs.Add("run-2", test.Name, TriggerSchedule)
+	a.skipRun(context.Background(), run, test, due, "run run-1 is still in progress")
+
+	got, ok := a.Runs.Get(run.ID)
+	require.True(t, ok)
+	assert.Equal(t, RunSkipped, got.State)
+	assert.Equal(t, "run run-1 is still in progress", got.Reason)
+	require.NotEmpty(t, got.ResultID)
+
+	payload, err := a.Storage.Select(context.Background(), got.ResultID)
+	require.NoError(t, err)
+	assert.Equal(t, model.StatusSkipped, payload.Status)
+	assert.Equal(t, due.UnixNano(), payload.StartedAt.UnixNano())
+	assert.Equal(t, test.Labels, payload.Labels)
+	var data map[string]string
+	require.NoError(t, json.Unmarshal(payload.Data, &data))
+	assert.Equal(t, "run run-1 is still in progress", data["reason"])
+	_, err = payload.Results()
+	assert.True(t, errors.Is(err, model.ErrNoResults))
+
+	assert.Equal(t, uint64(1), a.Metrics.skipped[test.Name])
+}
+
+func TestApp_SkipRunDuringShutdown(t *testing.T) {
+	logger := zerolog.Nop()
+	storage := cancelStorage{model.NewMemoryStorage()}
+	a := App{Storage: storage, Logger: &logger, Runs: NewRunRegistry(10), Metrics: NewMetricsRegistry()}
+	test := conf.TestConfig{Name: "test1", Duration: conf.NewDuration(time.Second), TPS: 5}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	run := a.Runs.Add("run-2", test.Name, TriggerSchedule)
+	a.skipRun(ctx, run, test, time.Now(), "superseded by run run-3")
+
+	got, ok := a.Runs.Get(run.ID)
+	require.True(t, ok)
+	require.NotEmpty(t, got.ResultID)
+	_, err := storage.Select(context.Background(), got.ResultID)
+	assert.NoError(t, err)
+}
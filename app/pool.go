@@ -0,0 +1,71 @@
+package app
+
+import "sync"
+
+// defaultQueueSize is how many functions may wait for a worker when the
+// queue size is not configured.
+const defaultQueueSize = 16
+
+// workerPool runs functions on at most size goroutines at a time. Functions
+// submitted while every worker is busy wait their turn in submission order,
+// up to queueSize of them.
+type workerPool struct {
+	mu        sync.Mutex
+	size      int
+	queueSize int
+	running   int
+	queue     []func()
+	wg        sync.WaitGroup
+}
+
+func newWorkerPool(size, queueSize int) *workerPool {
+	if size < 1 {
+		size = 1
+	}
+	if queueSize < 1 {
+		queueSize = defaultQueueSize
+	}
+	return &workerPool{size: size, queueSize: queueSize}
+}
+
+// submit runs fn on a free worker, or queues it until one is free. It never
+// blocks, and reports false without running fn when the queue is full.
+func (p *workerPool) submit(fn func()) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.running < p.size {
+		p.wg.Add(1)
+		p.running++
+		go p.work(fn)
+		return true
+	}
+	if len(p.queue) >= p.queueSize {
+		return false
+	}
+	p.wg.Add(1)
+	p.queue = append(p.queue, fn)
+	return true
+}
+
+// work runs fn and then queued functions until the queue is empty.
+func (p *workerPool) work(fn func()) {
+	for fn != nil {
+		fn()
+		p.wg.Done()
+
+		p.mu.Lock()
+		if len(p.queue) == 0 {
+			p.running--
+			fn = nil
+		} else {
+			fn, p.queue = p.queue[0], p.queue[1:]
+		}
+		p.mu.Unlock()
+	}
+}
+
+// wait blocks until every submitted function has returned.
+func (p *workerPool) wait() {
+	p.wg.Wait()
+}
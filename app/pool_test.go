@@ -0,0 +1,102 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	vegeta "github.com/tsenart/vegeta/lib"
+
+	"github.com/javking07/toadlester/conf"
+	"github.com/javking07/toadlester/model"
+)
+
+func TestWorkerPool(t *testing.T) {
+	pool := newWorkerPool(2, 4)
+
+	var mu sync.Mutex
+	running, peak := 0, 0
+	var order []int
+	for i := 0; i < 6; i++ {
+		i := i
+		ok := pool.submit(func() {
+			mu.Lock()
+			running++
+			if running > peak {
+				peak = running
+			}
+			order = append(order, i)
+			mu.Unlock()
+
+			time.Sleep(10 * time.Millisecond)
+
+			mu.Lock()
+			running--
+			mu.Unlock()
+		})
+		require.True(t, ok)
+	}
+	pool.wait()
+
+	assert.Equal(t, 2, peak)
+	assert.Len(t, order, 6)
+	// the first two start straight away, the rest wait for a free worker
+	assert.ElementsMatch(t, []int{0, 1}, order[:2])
+}
+
+func TestWorkerPool_QueueFull(t *testing.T) {
+	pool := newWorkerPool(1, 1)
+	release := make(chan struct{})
+	ran := make(chan int, 3)
+	for i := 0; i < 2; i++ {
+		i := i
+		require.True(t, pool.submit(func() {
+			<-release
+			ran <- i
+		}))
+	}
+
+	// with the worker busy and the queue full, further runs are turned away
+	assert.False(t, pool.submit(func() { ran <- 2 }))
+	close(release)
+	pool.wait()
+	close(ran)
+
+	var got []int
+	for i := range ran {
+		got = append(got, i)
+	}
+	assert.Equal(t, []int{0, 1}, got)
+	assert.True(t, pool.submit(func() {}))
+	pool.wait()
+}
+
+func TestApp_TrackAttributesLogs(t *testing.T) {
+	var buf bytes.Buffer
+	logger := zerolog.New(&buf)
+	a := App{Storage: model.NewMemoryStorage(), Logger: &logger, Runs: NewRunRegistry(10), Metrics: NewMetricsRegistry()}
+	test := conf.TestConfig{Name: "test1", Duration: conf.NewDuration(time.Second), TPS: 1}
+
+	run := a.Runs.Add("run-1", test.Name, TriggerSchedule)
+	a.track(context.Background(), run, test, func() ([]vegeta.Target, error) { return nil, errors.New("no targets") })
+
+	run, _ = a.Runs.Get("run-1")
+	assert.Equal(t, RunFailed, run.State)
+	assert.Equal(t, "no targets", run.Error)
+
+	lines := bytes.Split(bytes.TrimSpace(buf.Bytes()), []byte("\n"))
+	require.NotEmpty(t, lines)
+	for _, line := range lines {
+		var entry map[string]interface{}
+		require.NoError(t, json.Unmarshal(line, &entry))
+		assert.Equal(t, "run-1", entry["run"], "line: %s", line)
+		assert.Equal(t, "test1", entry["test"], "line: %s", line)
+	}
+}
@@ -0,0 +1,112 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	vegeta "github.com/tsenart/vegeta/lib"
+
+	"github.com/javking07/toadlester/conf"
+	"github.com/javking07/toadlester/model"
+)
+
+func TestApp_ExecuteProfile(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	logger := zerolog.Nop()
+	a := App{Storage: model.NewMemoryStorage(), Logger: &logger, Runs: NewRunRegistry(10), Metrics: NewMetricsRegistry()}
+	test := conf.TestConfig{Name: "ramp", Duration: conf.NewDuration(time.Second), Profile: &conf.ProfileConfig{
+		Ramp: &conf.RampConfig{From: 0, To: 40, Segments: 2},
+	}}
+	targets := func() ([]vegeta.Target, error) {
+		return []vegeta.Target{{Method: http.MethodGet, URL: server.URL}}, nil
+	}
+
+	id, metrics, err := a.execute(context.Background(), test, targets)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(20), metrics.Requests, "the integral of the ramp")
+
+	payload, err := a.Storage.Select(context.Background(), id)
+	require.NoError(t, err)
+	assert.Equal(t, 20.0, payload.Rate, "the mean planned rate")
+	results, err := payload.Results()
+	require.NoError(t, err)
+	require.Len(t, results.Stages, 2)
+	assert.Equal(t, "ramp 1", results.Stages[0].Name)
+	assert.Equal(t, 20.0, results.Stages[0].To)
+	assert.Equal(t, 500*time.Millisecond, results.Stages[1].Start)
+	// a quarter of the requests fall into the first half of the ramp
+	assert.InDelta(t, 5, results.Stages[0].Metrics.Requests, 1)
+	assert.Equal(t, results.Requests, results.Stages[0].Metrics.Requests+results.Stages[1].Metrics.Requests)
+}
+
+func TestApp_ExecuteArrivals(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	logger := zerolog.Nop()
+	a := App{Storage: model.NewMemoryStorage(), Logger: &logger, Runs: NewRunRegistry(10), Metrics: NewMetricsRegistry()}
+	test := conf.TestConfig{Name: "poisson", Duration: conf.NewDuration(500 * time.Millisecond), TPS: 40, Arrivals: &conf.ArrivalsConfig{Process: "poisson"}}
+	targets := func() ([]vegeta.Target, error) {
+		return []vegeta.Target{{Method: http.MethodGet, URL: server.URL}}, nil
+	}
+
+	id, metrics, err := a.execute(context.Background(), test, targets)
+	require.NoError(t, err)
+	assert.NotZero(t, metrics.Requests)
+	payload, err := a.Storage.Select(context.Background(), id)
+	require.NoError(t, err)
+	results, err := payload.Results()
+	require.NoError(t, err)
+	assert.Equal(t, &model.ArrivalResults{Process: "poisson"}, results.Arrivals)
+	assert.Empty(t, results.Stages)
+
+	// failed runs record their arrival process too
+	test.Name = "bursty"
+	test.Arrivals = &conf.ArrivalsConfig{Process: "bursty", On: conf.NewDuration(time.Second), Off: conf.NewDuration(time.Second)}
+	_, _, err = a.execute(context.Background(), test, func() ([]vegeta.Target, error) { return nil, errors.New("no targets") })
+	require.Error(t, err)
+	items, _, err := a.Storage.Query(context.Background(), model.ResultQuery{Name: test.Name, Limit: 1})
+	require.NoError(t, err)
+	require.Len(t, items, 1)
+	assert.Equal(t, model.StatusFailed, items[0].Status)
+	results, err = items[0].Results()
+	require.NoError(t, err)
+	assert.Equal(t, &model.ArrivalResults{Process: "bursty", On: time.Second, Off: time.Second}, results.Arrivals)
+}
+
+func TestApp_ExecuteUsers(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(10 * time.Millisecond)
+	}))
+	defer server.Close()
+
+	logger := zerolog.Nop()
+	a := App{Storage: model.NewMemoryStorage(), Logger: &logger, Runs: NewRunRegistry(10), Metrics: NewMetricsRegistry()}
+	test := conf.TestConfig{Name: "users", Duration: conf.NewDuration(300 * time.Millisecond), Users: &conf.UsersConfig{
+		Count: 2, ThinkTime: conf.NewDuration(10 * time.Millisecond),
+	}}
+	targets := func() ([]vegeta.Target, error) {
+		return []vegeta.Target{{Method: http.MethodGet, URL: server.URL + "/a"}, {Method: http.MethodGet, URL: server.URL + "/b"}}, nil
+	}
+
+	id, metrics, err := a.execute(context.Background(), test, targets)
+	require.NoError(t, err)
+	assert.NotZero(t, metrics.Requests)
+	payload, err := a.Storage.Select(context.Background(), id)
+	require.NoError(t, err)
+	results, err := payload.Results()
+	require.NoError(t, err)
+	assert.Equal(t, &model.UserResults{Count: 2, ThinkTime: 10 * time.Millisecond}, results.Users)
+	assert.Nil(t, results.Arrivals, "users are not paced by rate")
+	assert.NotZero(t, results.Throughput)
+	assert.Equal(t, results.Throughput, payload.Rate, "the achieved throughput stands in for the rate")
+}
@@ -0,0 +1,96 @@
+package app
+
+import (
+	"context"
+	"time"
+
+	"github.com/javking07/toadlester/conf"
+	"github.com/javking07/toadlester/model"
+)
+
+// defaultCompactorInterval is how often retention rules are applied when the
+// compactor interval is not configured.
+const defaultCompactorInterval = time.Hour
+
+// InitCompactor applies retention rules to stored results in the background,
+// once at start and then every interval, until the app shuts down.
+func (a *App) InitCompactor(c *conf.Config) {
+	interval := defaultCompactorInterval
+	var fallback *conf.RetentionConfig
+	if c.Compactor != nil {
+		if c.Compactor.Interval != nil && *c.Compactor.Interval > 0 {
+			interval = *c.Compactor.Interval
+		}
+		fallback = c.Compactor.Retention
+	}
+	if fallback != nil {
+		if err := fallback.Validate(); err != nil {
+			a.Logger.Error().Msgf("ignoring invalid default retention: %v", err)
+			fallback = nil
+		}
+	}
+	a.Logger.Info().Msgf("initializing compactor to run every: %s", interval)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		a.Compact(a.ctx, fallback, time.Now())
+		select {
+		case <-a.ctx.Done():
+			a.Logger.Info().Msg("shutting down compactor")
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Compact applies retention rules to the results stored under every name,
+// including those of ad-hoc runs and deleted tests. Results of stored tests
+// with a rule of their own follow it; all others use fallback. Without either
+// results are kept forever.
+func (a *App) Compact(ctx context.Context, fallback *conf.RetentionConfig, now time.Time) {
+	tests, err := a.Storage.SelectTests(ctx)
+	if err != nil {
+		a.Logger.Error().Msgf("error loading tests to compact: %v", err)
+		return
+	}
+	names, err := a.Storage.ResultNames(ctx)
+	if err != nil {
+		a.Logger.Error().Msgf("error loading result names to compact: %v", err)
+		return
+	}
+	rules := make(map[string]*conf.RetentionConfig, len(tests))
+	for _, test := range tests {
+		rules[test.Name] = test.Retention
+	}
+
+	for _, name := range names {
+		rule := rules[name]
+		if rule == nil {
+			rule = fallback
+		}
+		if rule == nil {
+			continue
+		}
+
+		compaction := model.NewCompaction(name, *rule, now)
+		result, err := a.Storage.Compact(ctx, compaction)
+		if err != nil {
+			a.Logger.Error().Msgf("error compacting results of test %s: %v", name, err)
+			continue
+		}
+		// raw results outlive neither the run they belong to nor its raw
+		// retention
+		if a.Artifacts != nil {
+			if pruned, err := a.Artifacts.Prune(ctx, name, compaction.RawBefore); err != nil {
+				a.Logger.Error().Msgf("error pruning raw results of test %s: %v", name, err)
+			} else if pruned > 0 {
+				a.Logger.Info().Msgf("pruned raw results of %d runs of test %s", pruned, name)
+			}
+		}
+		if result != (model.CompactionResult{}) {
+			a.Logger.Info().Msgf("compacted results of test %s: %d runs rolled up into %d aggregates, %d aggregates expired",
+				name, result.Compacted, result.Aggregated, result.Expired)
+		}
+	}
+}
@@ -0,0 +1,56 @@
+package app
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/javking07/toadlester/conf"
+	"github.com/javking07/toadlester/model"
+)
+
+func TestApp_Compact(t *testing.T) {
+	ctx := context.Background()
+	logger := zerolog.Nop()
+	a := App{Storage: model.NewMemoryStorage(), Logger: &logger}
+	now := time.Now()
+	day := 24 * time.Hour
+
+	keep := conf.RetentionConfig{Raw: conf.NewDuration(2 * day)}
+	drop := conf.RetentionConfig{Raw: conf.NewDuration(day), Aggregate: conf.NewDuration(0)}
+	for _, test := range []conf.TestConfig{
+		{Name: "own", Duration: conf.NewDuration(time.Second), TPS: 1, Target: "t", Retention: &keep},
+		{Name: "default", Duration: conf.NewDuration(time.Second), TPS: 1, Target: "t"},
+	} {
+		require.NoError(t, a.Storage.InsertTest(ctx, test))
+	}
+	for _, name := range []string{"own", "default", "ad-hoc"} {
+		for i, age := range []time.Duration{time.Hour, 36 * time.Hour, 3 * day} {
+			started := now.Add(-age)
+			_, err := a.Storage.Insert(ctx, model.Payload{ID: name + string(rune('a'+i)), Name: name, StartedAt: &started})
+			require.NoError(t, err)
+		}
+	}
+
+	a.Compact(ctx, &drop, now)
+
+	statuses := func(name string) []string {
+		results, _, err := a.Storage.Query(ctx, model.ResultQuery{Name: name, Limit: 10})
+		require.NoError(t, err)
+		var statuses []string
+		for _, result := range results {
+			statuses = append(statuses, result.Status)
+		}
+		return statuses
+	}
+	// the test's own rule rolls up the oldest run, keeping the last two days
+	assert.Equal(t, []string{model.StatusAggregated, model.StatusSucceeded, model.StatusSucceeded}, statuses("own"))
+	// the default rule deletes everything older than a day
+	assert.Equal(t, []string{model.StatusSucceeded}, statuses("default"))
+	// as it does for results without a stored test
+	assert.Equal(t, []string{model.StatusSucceeded}, statuses("ad-hoc"))
+}
@@ -0,0 +1,57 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+
+	"github.com/go-chi/chi"
+	"github.com/javking07/toadlester/conf"
+)
+
+// InitRouter registers the api routes on the app router.
+func (a *App) InitRouter() {
+	a.Router = chi.NewRouter()
+
+	a.Router.Get("/healthz", a.healthz)
+	a.Router.Get("/readyz", a.readyz)
+	a.Router.Method("GET", "/metrics", a.Metrics)
+
+	a.Router.Route("/results", func(r chi.Router) {
+		r.Get("/", a.getResults)
+		r.Get("/{id}", a.getResult)
+		r.Put("/{id}", a.updateResult)
+		r.Delete("/{id}", a.deleteResult)
+		r.Get("/{id}/raw", a.getResultRaw)
+		r.Get("/{id}/analysis", a.getResultAnalysis)
+		r.Get("/{id}/plot", a.getResultPlot)
+	})
+
+	a.Router.Route("/tests", func(r chi.Router) {
+		r.Get("/", a.getTests)
+		r.Post("/", a.createTest)
+		r.Get("/{name}", a.getTest)
+		r.Put("/{name}", a.updateTest)
+		r.Delete("/{name}", a.deleteTest)
+		r.Post("/{name}/runs", a.createTestRun)
+	})
+
+	a.Router.Route("/runs", func(r chi.Router) {
+		r.Get("/", a.getRuns)
+		r.Post("/", a.createRun)
+		r.Get("/{id}", a.getRun)
+	})
+}
+
+// InitServer returns an http server for the app router listening on the
+// configured address.
+func (a *App) InitServer(c *conf.Config) *http.Server {
+	return &http.Server{
+		Addr:    fmt.Sprintf("%s:%d", c.Server.Host, c.Server.Port),
+		Handler: a.Router,
+		// requests still running after a graceful shutdown are cancelled
+		// along with the app
+		BaseContext: func(net.Listener) context.Context { return a.ctx },
+	}
+}
@@ -2,37 +2,424 @@ package app
 
 import (
 	"bytes"
-	"io/ioutil"
-	"os"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
 	"time"
 
+	"github.com/javking07/toadlester/artifacts"
+	"github.com/javking07/toadlester/conf"
+	"github.com/javking07/toadlester/load"
+	"github.com/javking07/toadlester/model"
+	"github.com/rs/zerolog"
+	uuid "github.com/satori/go.uuid"
 	vegeta "github.com/tsenart/vegeta/lib"
 )
 
 // RunTest executes a given test using the vegeta library and returns the
 // related metrics once complete.
-func (a *App) RunTest(name string, duration time.Duration, tps int, target string) (*vegeta.Metrics, error) {
+func (a *App) RunTest(ctx context.Context, name string, duration time.Duration, tps int, target string) (*vegeta.Metrics, error) {
 	// set up test
-	b, err := ioutil.ReadFile(target)
+	targets, err := fileTargets(target, conf.FormatAuto)
 	if err != nil {
 		return nil, err
 	}
-	src := bytes.NewBuffer(b)
-	targeter := vegeta.NewHTTPTargeter(src, nil, nil)
+	return a.Attack(ctx, name, duration, tps, vegeta.NewStaticTargeter(targets...), nil)
+}
 
+// Attack runs a constant rate attack against the targets produced by
+// targeter and returns the resulting metrics. Every result is also passed to
+// enc, when not nil. Cancelling ctx stops the attack early and returns the
+// context's error.
+func (a *App) Attack(ctx context.Context, name string, duration time.Duration, tps int, targeter vegeta.Targeter, enc vegeta.Encoder) (*vegeta.Metrics, error) {
 	rate := vegeta.Rate{Freq: tps, Per: time.Second}
 	attacker := vegeta.NewAttacker()
 	defer attacker.Stop()
 
-	// run test
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			attacker.Stop()
+		case <-done:
+		}
+	}()
+
+	return a.collect(ctx, name, attacker.Attack(targeter, rate, duration, name), enc, nil)
+}
+
+// AttackProfile runs an attack at the rates of profile with requests spaced
+// by arrivals, like Attack, and also returns the metrics of every stage of
+// the profile.
+func (a *App) AttackProfile(ctx context.Context, name string, profile load.Profile, arrivals load.Arrivals, duration time.Duration, targeter vegeta.Targeter, enc vegeta.Encoder) (*vegeta.Metrics, []load.StageMetrics, error) {
+	breakdown := load.NewBreakdown(profile.Stages(), time.Now())
+	metrics, err := a.collect(ctx, name, load.Attack(ctx, targeter, profile, arrivals, duration, name), enc, breakdown.Add)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	stages := breakdown.Close()
+	for _, stage := range stages {
+		a.log(ctx).Info().Msgf("stage %s of test %s (%s-%s, %g-%g tps): %d requests at %.2f/s, p99 %s, success %.2f%%",
+			stage.Name, name, stage.Start, stage.End, stage.From, stage.To,
+			stage.Metrics.Requests, stage.Metrics.Rate, stage.Metrics.Latencies.P99, stage.Metrics.Success*100)
+	}
+	return metrics, stages, nil
+}
+
+// AttackUsers runs users against targets for duration, like Attack, and logs
+// the throughput they achieved.
+func (a *App) AttackUsers(ctx context.Context, name string, users load.Users, duration time.Duration, targets []vegeta.Target, enc vegeta.Encoder) (*vegeta.Metrics, error) {
+	metrics, err := a.collect(ctx, name, load.AttackUsers(ctx, targets, users, duration, name), enc, nil)
+	if err != nil {
+		return nil, err
+	}
+	a.log(ctx).Info().Msgf("%d users of test %s achieved a throughput of %.2f/s", users.Count, name, load.Throughput(metrics))
+	return metrics, nil
+}
+
+// collect adds the results of an attack up until the attack ends, passing
+// each to enc and add when not nil, and reports the metrics.
+func (a *App) collect(ctx context.Context, name string, results <-chan *vegeta.Result, enc vegeta.Encoder, add func(*vegeta.Result)) (*vegeta.Metrics, error) {
 	var metrics vegeta.Metrics
 
-	for res := range attacker.Attack(targeter, rate, duration, name) {
+	for res := range results {
 		metrics.Add(res)
+		if add != nil {
+			add(res)
+		}
+		if enc == nil {
+			continue
+		}
+		if err := enc.Encode(res); err != nil {
+			// losing the raw results should not fail the run itself
+			a.log(ctx).Error().Msgf("error recording raw results of test %s: %v", name, err)
+			enc = nil
+		}
 	}
 	metrics.Close()
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
 
-	r := vegeta.NewTextReporter(&metrics)
-	a.Logger.Info().Msgf("%v", r.Report(os.Stdout))
+	// report through the run's logger so that concurrent runs do not
+	// interleave their reports
+	var report bytes.Buffer
+	if err := vegeta.NewTextReporter(&metrics).Report(&report); err != nil {
+		a.log(ctx).Error().Msgf("error reporting results of test %s: %v", name, err)
+	}
+	a.log(ctx).Info().Msgf("results of test %s:\n%s", name, report.String())
 	return &metrics, nil
 }
+
+// SaveResults stores the outcome of a test run and returns the id of the
+// stored result. Failed runs are stored with their error in place of metrics.
+func (a *App) SaveResults(ctx context.Context, test conf.TestConfig, started time.Time, metrics *vegeta.Metrics, runErr error) (string, error) {
+	return a.saveResults(ctx, test, started, metrics, nil, runErr)
+}
+
+// runData is the data stored with the result of a succeeded run.
+type runData struct {
+	vegeta.Metrics
+	Stages     []load.StageMetrics `json:"stages,omitempty"`
+	Arrivals   *load.Arrivals      `json:"arrivals,omitempty"`
+	Users      *load.Users         `json:"users,omitempty"`
+	Throughput float64             `json:"throughput"`
+}
+
+// failedData is the data stored with the result of a failed run.
+type failedData struct {
+	Error    string         `json:"error"`
+	Arrivals *load.Arrivals `json:"arrivals,omitempty"`
+	Users    *load.Users    `json:"users,omitempty"`
+}
+
+func (a *App) saveResults(ctx context.Context, test conf.TestConfig, started time.Time, metrics *vegeta.Metrics, stages []load.StageMetrics, runErr error) (string, error) {
+	payload := model.Payload{
+		ID:       uuid.NewV4().String(),
+		Name:     test.Name,
+		Rate:     plannedRate(test),
+		Status:   model.StatusSucceeded,
+		Duration: test.Duration.Duration,
+		Labels:   test.Labels,
+	}
+
+	var err error
+	if runErr != nil {
+		ended := time.Now()
+		payload.StartedAt, payload.EndedAt = &started, &ended
+		payload.Status = model.StatusFailed
+		payload.Data, err = json.Marshal(failedData{Error: runErr.Error(), Arrivals: arrivals(test), Users: users(test)})
+	} else {
+		payload.StartedAt, payload.EndedAt = &metrics.Earliest, &metrics.End
+		payload.LatencyMean = metrics.Latencies.Mean
+		payload.LatencyP50 = metrics.Latencies.P50
+		payload.LatencyP95 = metrics.Latencies.P95
+		payload.LatencyP99 = metrics.Latencies.P99
+		payload.LatencyMax = metrics.Latencies.Max
+		payload.Success = metrics.Success
+		payload.Requests = metrics.Requests
+		data := runData{Metrics: *metrics, Stages: stages, Arrivals: arrivals(test), Users: users(test), Throughput: load.Throughput(metrics)}
+		if data.Users != nil {
+			// runs with users have no planned rate
+			payload.Rate = data.Throughput
+		}
+		payload.Data, err = json.Marshal(data)
+	}
+	if err != nil {
+		return "", err
+	}
+
+	if err := a.insertResult(ctx, payload); err != nil {
+		return "", err
+	}
+	return payload.ID, nil
+}
+
+// finishRun records the outcome of a test run that began at started. Results
+// are stored and exported; failures are logged and counted. It returns the id
+// of the stored result.
+func (a *App) finishRun(ctx context.Context, test conf.TestConfig, started time.Time, metrics *vegeta.Metrics, stages []load.StageMetrics, runErr error) (string, error) {
+	if runErr != nil {
+		a.log(ctx).Error().Msgf("error running test: %v", runErr)
+		a.Metrics.Record(test.Name, nil, true)
+		if _, err := a.SaveResults(ctx, test, started, nil, runErr); err != nil {
+			a.log(ctx).Error().Msgf("error inserting failed test run: %v", err)
+		}
+		return "", runErr
+	}
+
+	if a.Influx != nil {
+		a.Influx.WriteMetrics(test.Name, metrics)
+	}
+
+	id, err := a.saveResults(ctx, test, started, metrics, stages, nil)
+	a.Metrics.Record(test.Name, metrics, err != nil)
+	if err != nil {
+		a.log(ctx).Error().Msgf("error inserting test results: %v", err)
+		return "", err
+	}
+	a.log(ctx).Info().Msgf("stored result %s", id)
+	a.timer.succeeded()
+	return id, nil
+}
+
+// ErrTooManyRuns is returned by StartRun when the limit of on-demand runs in
+// progress is reached.
+var ErrTooManyRuns = errors.New("too many on-demand runs in progress")
+
+// defaultMaxRuns is how many on-demand runs may be in progress at once when
+// the limit is not configured.
+const defaultMaxRuns = 4
+
+// maxRuns returns how many on-demand runs c allows to be in progress at once.
+func maxRuns(c *conf.Config) int {
+	if c.Server == nil || c.Server.MaxRuns <= 0 {
+		return defaultMaxRuns
+	}
+	return c.Server.MaxRuns
+}
+
+// runSlots bounds the number of on-demand runs in progress.
+type runSlots struct {
+	mu    sync.Mutex
+	limit int
+	used  int
+}
+
+// acquire takes a slot and reports whether one was free.
+func (s *runSlots) acquire() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	limit := s.limit
+	if limit <= 0 {
+		limit = defaultMaxRuns
+	}
+	if s.used >= limit {
+		return false
+	}
+	s.used++
+	return true
+}
+
+func (s *runSlots) release() {
+	s.mu.Lock()
+	s.used--
+	s.mu.Unlock()
+}
+
+// StartRun queues an on-demand run of the given test and returns it straight
+// away. The test runs in the background; its progress is tracked in a.Runs.
+// It fails with ErrTooManyRuns while the limit of on-demand runs is reached.
+func (a *App) StartRun(test conf.TestConfig) (Run, error) {
+	targets := func() ([]vegeta.Target, error) { return testTargets(test) }
+	return a.startRun(test, targets)
+}
+
+func (a *App) startRun(test conf.TestConfig, targets func() ([]vegeta.Target, error)) (Run, error) {
+	if !a.slots.acquire() {
+		return Run{}, ErrTooManyRuns
+	}
+	run := a.Runs.Add(uuid.NewV4().String(), test.Name, TriggerAPI)
+	a.goProduce(func() {
+		defer a.slots.release()
+		a.track(a.ctx, run, test, targets)
+	})
+	return run, nil
+}
+
+// track executes a run registered in a.Runs and records its progress there.
+// Everything the run logs carries its run id and test name.
+func (a *App) track(ctx context.Context, run Run, test conf.TestConfig, targets func() ([]vegeta.Target, error)) {
+	logger := a.Logger.With().Str("run", run.ID).Str("test", test.Name).Logger()
+	ctx = context.WithValue(ctx, runLoggerKey{}, &logger)
+
+	a.Runs.Start(run.ID)
+	logger.Info().Msgf("starting %s run of test %s", run.Trigger, test.Name)
+	resultID, metrics, err := a.execute(ctx, test, targets)
+	if err != nil {
+		a.Runs.Fail(run.ID, err)
+		return
+	}
+	a.Runs.Succeed(run.ID, resultID, metrics)
+}
+
+// runLoggerKey keys the logger of a run in its context.
+type runLoggerKey struct{}
+
+// log returns the logger of the run ctx belongs to, or the app logger outside
+// of runs.
+func (a *App) log(ctx context.Context) *zerolog.Logger {
+	if logger, ok := ctx.Value(runLoggerKey{}).(*zerolog.Logger); ok {
+		return logger
+	}
+	return a.Logger
+}
+
+// execute runs test against the targets returned by targets and records the run as
+// finishRun does, keeping its raw results when an artifacts store is
+// configured. It returns the id of the stored result and the run metrics.
+func (a *App) execute(ctx context.Context, test conf.TestConfig, targets func() ([]vegeta.Target, error)) (string, *vegeta.Metrics, error) {
+	var metrics *vegeta.Metrics
+	var stages []load.StageMetrics
+	var rec *artifacts.Recorder
+	started := time.Now()
+	tgts, err := targets()
+	if err == nil {
+		rec = a.newRecorder(ctx, test.Name)
+		var enc vegeta.Encoder
+		if rec != nil {
+			defer rec.Discard()
+			enc = rec.Encode
+		}
+		metrics, stages, err = a.attack(ctx, test, tgts, enc)
+	}
+
+	// runs cancelled on shutdown still record their outcome
+	store, cancel := storeContext(ctx)
+	defer cancel()
+	resultID, err := a.finishRun(store, test, started, metrics, stages, err)
+	if err == nil && rec != nil {
+		if err := rec.Save(store, a.Artifacts, test.Name, resultID); err != nil {
+			a.log(ctx).Error().Msgf("error saving raw results of test %s: %v", test.Name, err)
+		}
+	}
+	return resultID, metrics, err
+}
+
+// storeTimeout bounds how long storing the outcome of a run may take.
+const storeTimeout = 10 * time.Second
+
+// storeContext returns the context to store the outcome of the run ctx
+// belongs to with. It carries the logger of the run but is not cancelled
+// along with it, only once storeTimeout passed.
+func storeContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	store := context.Background()
+	if logger, ok := ctx.Value(runLoggerKey{}).(*zerolog.Logger); ok {
+		store = context.WithValue(store, runLoggerKey{}, logger)
+	}
+	return context.WithTimeout(store, storeTimeout)
+}
+
+// attack runs test against targets with its virtual users, or at a constant
+// rate or the rates of its profile with requests spaced by its arrival
+// process. Rate based runs cycle through the targets.
+func (a *App) attack(ctx context.Context, test conf.TestConfig, targets []vegeta.Target, enc vegeta.Encoder) (*vegeta.Metrics, []load.StageMetrics, error) {
+	if users := users(test); users != nil {
+		metrics, err := a.AttackUsers(ctx, test.Name, *users, test.Duration.Duration, targets, enc)
+		return metrics, nil, err
+	}
+
+	tr := vegeta.NewStaticTargeter(targets...)
+
+	process := arrivals(test)
+	if test.Profile == nil && process.Uniform() {
+		metrics, err := a.Attack(ctx, test.Name, test.Duration.Duration, test.TPS, tr, enc)
+		return metrics, nil, err
+	}
+
+	var profile load.Profile = load.Constant{TPS: float64(test.TPS)}
+	if test.Profile != nil {
+		var err error
+		profile, err = load.Parse(test.Profile.Spec(test.TPS), test.Duration.Duration)
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid profile: %v", err)
+		}
+	}
+	return a.AttackProfile(ctx, test.Name, profile, *process, test.Duration.Duration, tr, enc)
+}
+
+// arrivals returns the arrival process of test, nil for tests run by
+// virtual users.
+func arrivals(test conf.TestConfig) *load.Arrivals {
+	if test.Users != nil {
+		return nil
+	}
+	if test.Arrivals == nil {
+		return &load.Arrivals{Process: load.ProcessUniform}
+	}
+	spec := test.Arrivals.Spec()
+	if spec.Process == "" {
+		spec.Process = load.ProcessUniform
+	}
+	return &spec
+}
+
+// users returns the virtual users of test, nil for tests run at a rate.
+func users(test conf.TestConfig) *load.Users {
+	if test.Users == nil {
+		return nil
+	}
+	spec := test.Users.Spec()
+	return &spec
+}
+
+// plannedRate returns the mean rate test is run at.
+func plannedRate(test conf.TestConfig) float64 {
+	if test.Profile == nil || test.Duration == nil {
+		return float64(test.TPS)
+	}
+	profile, err := load.Parse(test.Profile.Spec(test.TPS), test.Duration.Duration)
+	if err != nil {
+		return float64(test.TPS)
+	}
+	return float64(load.Planned(profile, test.Duration.Duration)) / test.Duration.Seconds()
+}
+
+// newRecorder returns a recorder for the raw results of a run of test, or nil
+// when they are not kept.
+func (a *App) newRecorder(ctx context.Context, test string) *artifacts.Recorder {
+	if a.Artifacts == nil {
+		return nil
+	}
+	rec, err := artifacts.NewRecorder(a.artifactsFormat)
+	if err != nil {
+		a.log(ctx).Error().Msgf("error preparing to record raw results of test %s: %v", test, err)
+		return nil
+	}
+	return rec
+}
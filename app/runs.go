@@ -0,0 +1,169 @@
+package app
+
+import (
+	"sync"
+	"time"
+
+	vegeta "github.com/tsenart/vegeta/lib"
+)
+
+// RunState is the lifecycle state of a test run.
+type RunState string
+
+const (
+	RunQueued    RunState = "queued"
+	RunRunning   RunState = "running"
+	RunSucceeded RunState = "succeeded"
+	RunFailed    RunState = "failed"
+	// RunSkipped is the state of scheduled runs that never ran because the
+	// previous run of their test was still in progress.
+	RunSkipped RunState = "skipped"
+)
+
+// What started a run.
+const (
+	TriggerAPI      = "api"
+	TriggerSchedule = "schedule"
+)
+
+// maxTrackedRuns bounds the number of runs kept in memory for polling.
+const maxTrackedRuns = 1000
+
+// Run tracks a single execution of a load test, started on demand or by its
+// schedule.
+type Run struct {
+	ID       string          `json:"id"`
+	Test     string          `json:"test"`
+	Trigger  string          `json:"trigger"`
+	State    RunState        `json:"state"`
+	Error    string          `json:"error,omitempty"`
+	Reason   string          `json:"reason,omitempty"`
+	ResultID string          `json:"resultId,omitempty"`
+	Queued   time.Time       `json:"queued"`
+	Started  *time.Time      `json:"started,omitempty"`
+	Finished *time.Time      `json:"finished,omitempty"`
+	Metrics  *vegeta.Metrics `json:"metrics,omitempty"`
+}
+
+// done reports whether the run has reached a final state.
+func (r *Run) done() bool {
+	return r.State == RunSucceeded || r.State == RunFailed || r.State == RunSkipped
+}
+
+// RunRegistry keeps track of runs so callers can poll their state.
+// Once the registry is full the oldest finished runs are forgotten.
+type RunRegistry struct {
+	mu    sync.RWMutex
+	runs  map[string]*Run
+	order []string
+	limit int
+}
+
+// NewRunRegistry returns a registry tracking at most limit runs.
+func NewRunRegistry(limit int) *RunRegistry {
+	return &RunRegistry{
+		runs:  make(map[string]*Run),
+		limit: limit,
+	}
+}
+
+// Add registers a new queued run for the given test.
+func (rr *RunRegistry) Add(id, test, trigger string) Run {
+	rr.mu.Lock()
+	defer rr.mu.Unlock()
+
+	run := &Run{ID: id, Test: test, Trigger: trigger, State: RunQueued, Queued: time.Now()}
+	rr.runs[id] = run
+	rr.order = append(rr.order, id)
+	rr.evict()
+	return *run
+}
+
+// Get returns a snapshot of the run with the given id.
+func (rr *RunRegistry) Get(id string) (Run, bool) {
+	rr.mu.RLock()
+	defer rr.mu.RUnlock()
+
+	run, ok := rr.runs[id]
+	if !ok {
+		return Run{}, false
+	}
+	return *run, true
+}
+
+// List returns snapshots of every tracked run, oldest first.
+func (rr *RunRegistry) List() []Run {
+	rr.mu.RLock()
+	defer rr.mu.RUnlock()
+
+	runs := make([]Run, 0, len(rr.order))
+	for _, id := range rr.order {
+		runs = append(runs, *rr.runs[id])
+	}
+	return runs
+}
+
+// Start marks the run as running.
+func (rr *RunRegistry) Start(id string) {
+	rr.update(id, func(run *Run) {
+		now := time.Now()
+		run.State = RunRunning
+		run.Started = &now
+	})
+}
+
+// Succeed marks the run as succeeded with the given metrics.
+func (rr *RunRegistry) Succeed(id, resultID string, metrics *vegeta.Metrics) {
+	rr.update(id, func(run *Run) {
+		now := time.Now()
+		run.State = RunSucceeded
+		run.Finished = &now
+		run.ResultID = resultID
+		run.Metrics = metrics
+	})
+}
+
+// Fail marks the run as failed with the given error.
+func (rr *RunRegistry) Fail(id string, err error) {
+	rr.update(id, func(run *Run) {
+		now := time.Now()
+		run.State = RunFailed
+		run.Finished = &now
+		run.Error = err.Error()
+	})
+}
+
+// Skip marks the run as skipped for the given reason. resultID is the id of
+// the stored record of the skipped run, if any.
+func (rr *RunRegistry) Skip(id, resultID, reason string) {
+	rr.update(id, func(run *Run) {
+		now := time.Now()
+		run.State = RunSkipped
+		run.Finished = &now
+		run.ResultID = resultID
+		run.Reason = reason
+	})
+}
+
+func (rr *RunRegistry) update(id string, fn func(*Run)) {
+	rr.mu.Lock()
+	defer rr.mu.Unlock()
+
+	if run, ok := rr.runs[id]; ok {
+		fn(run)
+	}
+}
+
+// evict forgets the oldest finished runs while the registry is over its
+// limit. Runs that are still queued or running are always kept.
+func (rr *RunRegistry) evict() {
+	for i := 0; len(rr.order) > rr.limit && i < len(rr.order); {
+		id := rr.order[i]
+		if !rr.runs[id].done() {
+			i++
+			continue
+		}
+		delete(rr.runs, id)
+		rr.order = append(rr.order[:i], rr.order[i+1:]...)
+	}
+}
@@ -0,0 +1,137 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	vegeta "github.com/tsenart/vegeta/lib"
+
+	"github.com/javking07/toadlester/conf"
+	"github.com/javking07/toadlester/model"
+)
+
+func TestRunRegistry(t *testing.T) {
+	rr := NewRunRegistry(2)
+
+	run := rr.Add("1", "test1", TriggerAPI)
+	assert.Equal(t, RunQueued, run.State)
+
+	rr.Start("1")
+	run, ok := rr.Get("1")
+	assert.True(t, ok)
+	assert.Equal(t, RunRunning, run.State)
+	assert.NotNil(t, run.Started)
+
+	rr.Succeed("1", "result", &vegeta.Metrics{Requests: 10})
+	run, _ = rr.Get("1")
+	assert.Equal(t, RunSucceeded, run.State)
+	assert.Equal(t, "result", run.ResultID)
+	assert.Equal(t, uint64(10), run.Metrics.Requests)
+
+	rr.Add("2", "test2", TriggerAPI)
+	rr.Fail("2", errors.New("boom"))
+	run, _ = rr.Get("2")
+	assert.Equal(t, RunFailed, run.State)
+	assert.Equal(t, "boom", run.Error)
+
+	// the oldest finished run is evicted once the registry is full
+	rr.Add("3", "test3", TriggerAPI)
+	_, ok = rr.Get("1")
+	assert.False(t, ok)
+	assert.Len(t, rr.List(), 2)
+}
+
+func TestRunRegistry_KeepsActiveRuns(t *testing.T) {
+	rr := NewRunRegistry(1)
+
+	rr.Add("1", "test1", TriggerAPI)
+	rr.Add("2", "test2", TriggerAPI)
+
+	// neither run has finished so both are kept
+	assert.Len(t, rr.List(), 2)
+
+	rr.Fail("1", errors.New("boom"))
+	rr.Add("3", "test3", TriggerAPI)
+	_, ok := rr.Get("1")
+	assert.False(t, ok)
+	assert.Len(t, rr.List(), 2)
+}
+
+func TestApp_StartRunLimit(t *testing.T) {
+	logger := zerolog.Nop()
+	a := App{Storage: model.NewMemoryStorage(), Logger: &logger, Runs: NewRunRegistry(10), Metrics: NewMetricsRegistry(), ctx: context.Background()}
+	a.slots.limit = 1
+	test := conf.TestConfig{Name: "test1", Duration: conf.NewDuration(time.Second), TPS: 1}
+
+	release := make(chan struct{})
+	blocked := func() ([]vegeta.Target, error) {
+		<-release
+		return nil, errors.New("no targets")
+	}
+	first, err := a.startRun(test, blocked)
+	require.NoError(t, err)
+	_, err = a.startRun(test, blocked)
+	assert.ErrorIs(t, err, ErrTooManyRuns)
+
+	close(release)
+	require.Eventually(t, func() bool {
+		run, _ := a.Runs.Get(first.ID)
+		return run.State == RunFailed
+	}, time.Second, 10*time.Millisecond)
+	require.Eventually(t, func() bool {
+		run, err := a.startRun(test, blocked)
+		return err == nil && run.ID != ""
+	}, time.Second, 10*time.Millisecond, "the slot is released once the run finished")
+}
+
+func TestMaxRuns(t *testing.T) {
+	tests := map[string]struct {
+		server *conf.ServerConfig
+		want   int
+	}{
+		"no server block": {server: nil, want: defaultMaxRuns},
+		"not configured":  {server: &conf.ServerConfig{Port: 8080}, want: defaultMaxRuns},
+		"configured":      {server: &conf.ServerConfig{MaxRuns: 10}, want: 10},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			assert.Equal(t, test.want, maxRuns(&conf.Config{Server: test.server}))
+		})
+	}
+}
+
+// cancelStorage is memory storage that fails inserts with a done context, as
+// postgres does.
+type cancelStorage struct {
+	model.Storage
+}
+
+func (s cancelStorage) Insert(ctx context.Context, payload model.Payload) (int64, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	return s.Storage.Insert(ctx, payload)
+}
+
+func TestApp_ExecuteStoresCancelledRuns(t *testing.T) {
+	logger := zerolog.Nop()
+	storage := cancelStorage{model.NewMemoryStorage()}
+	a := App{Storage: storage, Logger: &logger, Runs: NewRunRegistry(10), Metrics: NewMetricsRegistry()}
+	test := conf.TestConfig{Name: "test1", Duration: conf.NewDuration(time.Second), TPS: 1}
+
+	// a run cancelled on shutdown still records that it failed
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, _, err := a.execute(ctx, test, func() ([]vegeta.Target, error) { return nil, ctx.Err() })
+	require.ErrorIs(t, err, context.Canceled)
+
+	items, _, err := storage.Query(context.Background(), model.ResultQuery{Name: test.Name, Limit: 1})
+	require.NoError(t, err)
+	require.Len(t, items, 1)
+	assert.Equal(t, model.StatusFailed, items[0].Status)
+}
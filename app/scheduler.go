@@ -0,0 +1,121 @@
+package app
+
+import (
+	"math/rand"
+	"reflect"
+	"sort"
+	"time"
+
+	"github.com/javking07/toadlester/conf"
+	"github.com/javking07/toadlester/schedule"
+	"github.com/rs/zerolog"
+)
+
+// scheduler keeps track of when each stored test runs next. It is not safe
+// for concurrent use.
+type scheduler struct {
+	// fallback is the interval of tests without a schedule of their own.
+	fallback time.Duration
+	tests    map[string]*scheduledTest
+	rnd      *rand.Rand
+	logger   *zerolog.Logger
+}
+
+type scheduledTest struct {
+	test     conf.TestConfig
+	schedule schedule.Schedule
+	// next is when the test is scheduled to run next, and due when it runs
+	// once jitter is added. Both are zero when it has no more runs.
+	next, due time.Time
+}
+
+func newScheduler(fallback time.Duration, logger *zerolog.Logger) *scheduler {
+	return &scheduler{
+		fallback: fallback,
+		tests:    make(map[string]*scheduledTest),
+		rnd:      rand.New(rand.NewSource(time.Now().UnixNano())),
+		logger:   logger,
+	}
+}
+
+// load replaces the scheduled tests. Tests whose schedule is unchanged keep
+// their next run; new and rescheduled tests are planned from now.
+func (s *scheduler) load(tests []conf.TestConfig, now time.Time) {
+	seen := make(map[string]bool, len(tests))
+	for _, test := range tests {
+		seen[test.Name] = true
+		if st, ok := s.tests[test.Name]; ok && reflect.DeepEqual(st.test.Schedule, test.Schedule) {
+			st.test = test
+			continue
+		}
+
+		spec := schedule.Spec{Every: s.fallback}
+		if test.Schedule != nil {
+			spec = test.Schedule.Spec()
+		}
+		sched, err := schedule.Parse(spec, now)
+		if err != nil {
+			s.logger.Error().Msgf("not scheduling test %s: invalid schedule: %v", test.Name, err)
+			delete(s.tests, test.Name)
+			continue
+		}
+		st := &scheduledTest{test: test, schedule: sched}
+		s.plan(st, now)
+		s.tests[test.Name] = st
+		if st.next.IsZero() {
+			s.logger.Info().Msgf("test %s has no runs left on its schedule", test.Name)
+		} else {
+			s.logger.Info().Msgf("scheduled test %s to run next at %s", test.Name, st.next)
+		}
+	}
+	for name := range s.tests {
+		if !seen[name] {
+			delete(s.tests, name)
+		}
+	}
+}
+
+// wake returns the time the next test is due, or the zero time when no test
+// is scheduled.
+func (s *scheduler) wake() time.Time {
+	var wake time.Time
+	for _, st := range s.tests {
+		if !st.due.IsZero() && (wake.IsZero() || st.due.Before(wake)) {
+			wake = st.due
+		}
+	}
+	return wake
+}
+
+// take returns the tests due at now, in the order they fell due, and plans
+// their next runs. Runs missed while the timer was busy are not made up.
+func (s *scheduler) take(now time.Time) []conf.TestConfig {
+	var due []*scheduledTest
+	for _, st := range s.tests {
+		if !st.due.IsZero() && !st.due.After(now) {
+			due = append(due, st)
+		}
+	}
+	sort.Slice(due, func(i, j int) bool {
+		if !due[i].due.Equal(due[j].due) {
+			return due[i].due.Before(due[j].due)
+		}
+		return due[i].test.Name < due[j].test.Name
+	})
+
+	tests := make([]conf.TestConfig, 0, len(due))
+	for _, st := range due {
+		tests = append(tests, st.test)
+		s.plan(st, now)
+	}
+	return tests
+}
+
+// plan sets the next run of a test after now.
+func (s *scheduler) plan(st *scheduledTest, now time.Time) {
+	st.next = st.schedule.Next(now)
+	st.due = st.next
+	if !st.next.IsZero() && st.test.Schedule != nil && st.test.Schedule.Jitter != nil {
+		st.due = st.next.Add(schedule.Jitter(s.rnd, st.test.Schedule.Jitter.Duration))
+	}
+}
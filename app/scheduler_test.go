@@ -0,0 +1,65 @@
+package app
+
+import (
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/javking07/toadlester/conf"
+)
+
+func TestScheduler(t *testing.T) {
+	logger := zerolog.Nop()
+	s := newScheduler(time.Minute, &logger)
+	now := time.Date(2021, 9, 1, 12, 0, 30, 0, time.UTC)
+	test := func(name string, schedule *conf.ScheduleConfig) conf.TestConfig {
+		return conf.TestConfig{Name: name, Duration: conf.NewDuration(time.Second), TPS: 1, Target: "t", Schedule: schedule}
+	}
+	names := func(tests []conf.TestConfig) []string {
+		var names []string
+		for _, test := range tests {
+			names = append(names, test.Name)
+		}
+		return names
+	}
+
+	s.load([]conf.TestConfig{
+		test("default", nil),
+		test("hourly", &conf.ScheduleConfig{Cron: "@hourly", TimeZone: "UTC"}),
+		test("once", &conf.ScheduleConfig{At: "2021-09-01T12:30:00Z"}),
+		test("jittered", &conf.ScheduleConfig{Every: conf.NewDuration(10 * time.Minute), Jitter: conf.NewDuration(time.Minute)}),
+		test("invalid", &conf.ScheduleConfig{Cron: "nope"}),
+		test("past", &conf.ScheduleConfig{At: "2021-09-01T00:00:00Z"}),
+	}, now)
+	assert.Len(t, s.tests, 5)
+	assert.Equal(t, now.Add(time.Minute), s.wake())
+
+	jittered := s.tests["jittered"]
+	assert.Equal(t, now.Add(10*time.Minute), jittered.next)
+	assert.True(t, !jittered.due.Before(jittered.next) && jittered.due.Before(jittered.next.Add(time.Minute)))
+
+	assert.Empty(t, s.take(now.Add(59*time.Second)))
+	assert.Equal(t, []string{"default"}, names(s.take(now.Add(time.Minute))))
+	assert.Equal(t, now.Add(2*time.Minute), s.tests["default"].next)
+
+	// runs missed while busy are not made up
+	assert.Equal(t, []string{"default", "jittered", "once"}, names(s.take(now.Add(30*time.Minute))))
+	assert.Equal(t, now.Add(31*time.Minute), s.tests["default"].next)
+	assert.True(t, s.tests["once"].next.IsZero())
+	assert.Equal(t, []string{"default", "jittered", "hourly"}, names(s.take(now.Add(time.Hour))))
+
+	// reloading keeps the plan of unchanged tests, replans rescheduled ones
+	// and drops deleted ones
+	later := now.Add(time.Hour)
+	s.load([]conf.TestConfig{
+		test("default", nil),
+		test("hourly", &conf.ScheduleConfig{Every: conf.NewDuration(5 * time.Minute)}),
+		test("once", &conf.ScheduleConfig{At: "2021-09-01T12:30:00Z"}),
+	}, later)
+	assert.Len(t, s.tests, 3)
+	assert.Equal(t, now.Add(61*time.Minute), s.tests["default"].next)
+	assert.Equal(t, later.Add(5*time.Minute), s.tests["hourly"].next)
+	assert.True(t, s.tests["once"].next.IsZero())
+}
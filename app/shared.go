@@ -2,7 +2,10 @@ package app
 
 import (
 	"encoding/json"
+	"errors"
 	"net/http"
+
+	"github.com/javking07/toadlester/model"
 )
 
 func respondWithJSON(w http.ResponseWriter, code int, payload interface{}) {
@@ -21,3 +24,17 @@ func respondWithJSON(w http.ResponseWriter, code int, payload interface{}) {
 func respondWithError(w http.ResponseWriter, code int, message string) {
 	respondWithJSON(w, code, map[string]string{"error": message})
 }
+
+// respondWithStorageError maps storage errors onto http responses.
+func respondWithStorageError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, model.ErrNotFound):
+		respondWithError(w, http.StatusNotFound, err.Error())
+	case errors.Is(err, model.ErrConflict):
+		respondWithError(w, http.StatusConflict, err.Error())
+	case errors.Is(err, model.ErrInvalidQuery), errors.Is(err, model.ErrInvalidResult):
+		respondWithError(w, http.StatusBadRequest, err.Error())
+	default:
+		respondWithError(w, http.StatusInternalServerError, err.Error())
+	}
+}
@@ -0,0 +1,107 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"time"
+
+	"github.com/javking07/toadlester/artifacts"
+	"github.com/javking07/toadlester/conf"
+	"github.com/javking07/toadlester/model"
+	"github.com/javking07/toadlester/spool"
+)
+
+// defaultSpoolInterval is how often spooled results are retried when the
+// spool interval is not configured.
+const defaultSpoolInterval = 10 * time.Second
+
+// InitSpool replays spooled results in the background whenever storage is
+// healthy, once at start and then every interval, until the app shuts down.
+func (a *App) InitSpool(c *conf.Config) {
+	interval := defaultSpoolInterval
+	if c.Spool != nil && c.Spool.Interval != nil && *c.Spool.Interval > 0 {
+		interval = *c.Spool.Interval
+	}
+	a.Logger.Info().Msgf("initializing spool replay to run every: %s", interval)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		a.ReplaySpool(a.ctx)
+		select {
+		case <-a.ctx.Done():
+			a.Logger.Info().Msg("shutting down spool replay")
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// ReplaySpool stores spooled results if storage is healthy.
+func (a *App) ReplaySpool(ctx context.Context) {
+	if a.Spool.Len() == 0 {
+		return
+	}
+	if err := a.Storage.Healthy(ctx); err != nil {
+		a.Logger.Debug().Msgf("storage still unavailable, %d results spooled: %v", a.Spool.Len(), err)
+		return
+	}
+	replayed, err := a.Spool.Replay(ctx, a.Storage)
+	if replayed > 0 {
+		a.Logger.Info().Msgf("stored %d spooled results", replayed)
+	}
+	if err != nil {
+		a.Logger.Error().Msgf("error replaying spooled results, %d left: %v", a.Spool.Len(), err)
+	}
+}
+
+// insertResult stores a result. With a spool configured, results that cannot
+// be stored because storage is unavailable are spooled instead, as are all
+// results while earlier ones are still spooled so that they are stored in
+// order.
+func (a *App) insertResult(ctx context.Context, payload model.Payload) error {
+	if a.Spool != nil && a.Spool.Len() > 0 {
+		return a.spoolResult(ctx, payload, errors.New("earlier results are still spooled"))
+	}
+	_, err := a.Storage.Insert(ctx, payload)
+	if err == nil || a.Spool == nil || errors.Is(err, model.ErrConflict) {
+		return err
+	}
+	if herr := a.Storage.Healthy(ctx); herr == nil {
+		// storage is up and rejected the result itself
+		return err
+	}
+	return a.spoolResult(ctx, payload, err)
+}
+
+func (a *App) spoolResult(ctx context.Context, payload model.Payload, reason error) error {
+	if err := a.Spool.Push(payload); err != nil {
+		return err
+	}
+	a.log(ctx).Warn().Msgf("spooled result %s of test %s: %v", payload.ID, payload.Name, reason)
+	return nil
+}
+
+// spooledArtifacts is an artifact store keeping artifacts in storage that
+// attaches the artifacts of spooled results to them in the spool, since they
+// cannot be stored before their result. They are stored with the result when
+// it is replayed.
+type spooledArtifacts struct {
+	artifacts.Store
+	spool *spool.Spool
+}
+
+func (s spooledArtifacts) Save(ctx context.Context, test, resultID, format string, r io.Reader) error {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	err = s.spool.Attach(model.Artifact{ResultID: resultID, Format: format, Data: data})
+	if !errors.Is(err, spool.ErrNotSpooled) {
+		return err
+	}
+	return s.Store.Save(ctx, test, resultID, format, bytes.NewReader(data))
+}
@@ -0,0 +1,119 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/javking07/toadlester/artifacts"
+	"github.com/javking07/toadlester/conf"
+	"github.com/javking07/toadlester/model"
+	"github.com/javking07/toadlester/spool"
+)
+
+// outageStorage is memory storage that can be taken down.
+type outageStorage struct {
+	model.Storage
+	down bool
+}
+
+var errOutage = errors.New("connection refused")
+
+func (s *outageStorage) Insert(ctx context.Context, payload model.Payload) (int64, error) {
+	if s.down {
+		return 0, errOutage
+	}
+	return s.Storage.Insert(ctx, payload)
+}
+
+func (s *outageStorage) Healthy(context.Context) error {
+	if s.down {
+		return errOutage
+	}
+	return nil
+}
+
+func TestApp_SpoolDuringOutage(t *testing.T) {
+	ctx := context.Background()
+	logger := zerolog.Nop()
+	storage := &outageStorage{Storage: model.NewMemoryStorage()}
+	s, err := spool.Open(t.TempDir())
+	require.NoError(t, err)
+	a := App{Storage: storage, Logger: &logger, Spool: s}
+	test := conf.TestConfig{Name: "test", Duration: conf.NewDuration(time.Second), TPS: 1}
+
+	// runs finishing during the outage are spooled rather than lost
+	storage.down = true
+	var ids []string
+	for i := 0; i < 2; i++ {
+		id, err := a.SaveResults(ctx, test, time.Now(), nil, errors.New("run failed"))
+		require.NoError(t, err)
+		ids = append(ids, id)
+	}
+	assert.Equal(t, 2, s.Len())
+	a.ReplaySpool(ctx)
+	assert.Equal(t, 2, s.Len())
+
+	// later results queue up behind spooled ones until they are replayed
+	storage.down = false
+	id, err := a.SaveResults(ctx, test, time.Now(), nil, errors.New("run failed"))
+	require.NoError(t, err)
+	ids = append(ids, id)
+	assert.Equal(t, 3, s.Len())
+
+	a.ReplaySpool(ctx)
+	assert.Equal(t, 0, s.Len())
+	for _, id := range ids {
+		_, err := storage.Select(ctx, id)
+		assert.NoError(t, err)
+	}
+
+	// with storage back, results are stored directly again
+	_, err = a.SaveResults(ctx, test, time.Now(), nil, errors.New("run failed"))
+	require.NoError(t, err)
+	assert.Equal(t, 0, s.Len())
+}
+
+func TestApp_SpoolArtifacts(t *testing.T) {
+	ctx := context.Background()
+	logger := zerolog.Nop()
+	storage := &outageStorage{Storage: model.NewMemoryStorage()}
+	s, err := spool.Open(t.TempDir())
+	require.NoError(t, err)
+	a := App{Storage: storage, Logger: &logger, Spool: s}
+	a.Artifacts = spooledArtifacts{artifacts.DatabaseStore{Storage: storage}, s}
+
+	// the raw results of a spooled result wait for it in the spool
+	storage.down = true
+	spooled := model.Payload{ID: "spooled", Name: "test"}
+	require.NoError(t, a.insertResult(ctx, spooled))
+	require.NoError(t, a.Artifacts.Save(ctx, "test", "spooled", artifacts.FormatGob, strings.NewReader("raw")))
+
+	storage.down = false
+	a.ReplaySpool(ctx)
+	format, r, err := a.Artifacts.Open(ctx, "spooled")
+	require.NoError(t, err)
+	data, err := ioutil.ReadAll(r)
+	require.NoError(t, err)
+	assert.Equal(t, artifacts.FormatGob, format)
+	assert.Equal(t, "raw", string(data))
+
+	// those of stored results are stored directly
+	require.NoError(t, a.insertResult(ctx, model.Payload{ID: "stored", Name: "test"}))
+	require.NoError(t, a.Artifacts.Save(ctx, "test", "stored", artifacts.FormatGob, strings.NewReader("raw")))
+	_, err = storage.SelectArtifact(ctx, "stored")
+	assert.NoError(t, err)
+}
+
+func TestApp_InsertResultWithoutSpool(t *testing.T) {
+	logger := zerolog.Nop()
+	a := App{Storage: &outageStorage{Storage: model.NewMemoryStorage(), down: true}, Logger: &logger}
+	assert.ErrorIs(t, a.insertResult(context.Background(), model.Payload{ID: "a", Name: "test"}), errOutage)
+}
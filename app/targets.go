@@ -0,0 +1,115 @@
+package app
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/javking07/toadlester/conf"
+	vegeta "github.com/tsenart/vegeta/lib"
+)
+
+// testTargets returns the targets of test, declared inline or read from its
+// target file.
+func testTargets(test conf.TestConfig) ([]vegeta.Target, error) {
+	if len(test.Targets) > 0 {
+		return inlineTargets(test.Targets)
+	}
+	return fileTargets(test.Target, test.Format)
+}
+
+// fileTargets reads the targets in the given file, in the given format.
+// Errors point at the line of the file they occurred on.
+func fileTargets(path, format string) ([]vegeta.Target, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if format == "" || format == conf.FormatAuto {
+		format = detectFormat(b)
+	}
+	if !bytes.HasSuffix(b, []byte("\n")) {
+		// the json targeter drops a last line without a line break
+		b = append(b, '\n')
+	}
+
+	src := &lineReader{lines: bytes.SplitAfter(b, []byte("\n"))}
+	tr := vegeta.NewHTTPTargeter(src, nil, nil)
+	if format == conf.FormatJSON {
+		tr = vegeta.NewJSONTargeter(src, nil, nil)
+	}
+	var targets []vegeta.Target
+	for {
+		var target vegeta.Target
+		if err := tr(&target); err == vegeta.ErrNoTargets {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("%s:%d: %v", path, src.line, err)
+		}
+		targets = append(targets, target)
+	}
+	if len(targets) == 0 {
+		return nil, fmt.Errorf("%s: %w", path, vegeta.ErrNoTargets)
+	}
+	return targets, nil
+}
+
+// detectFormat returns the format of the targets in b: json when they start
+// with a json object, http otherwise.
+func detectFormat(b []byte) string {
+	if bytes.HasPrefix(bytes.TrimSpace(b), []byte("{")) {
+		return conf.FormatJSON
+	}
+	return conf.FormatHTTP
+}
+
+// lineReader hands out a line per read and counts the lines handed out. The
+// targeters buffer no more than a read, so the count is the line they stopped
+// at.
+type lineReader struct {
+	lines [][]byte
+	line  int
+	rest  []byte
+}
+
+func (r *lineReader) Read(p []byte) (int, error) {
+	for len(r.rest) == 0 {
+		if r.line == len(r.lines) || len(r.lines[r.line]) == 0 {
+			return 0, io.EOF
+		}
+		r.rest = r.lines[r.line]
+		r.line++
+	}
+	n := copy(p, r.rest)
+	r.rest = r.rest[n:]
+	return n, nil
+}
+
+// inlineTargets builds the targets declared by configs, reading the body
+// files they refer to.
+func inlineTargets(configs []conf.TargetConfig) ([]vegeta.Target, error) {
+	targets := make([]vegeta.Target, 0, len(configs))
+	for _, c := range configs {
+		target := vegeta.Target{Method: c.Method, URL: c.URL, Header: http.Header{}}
+		if target.Method == "" {
+			target.Method = http.MethodGet
+		}
+		for key, value := range c.Headers {
+			target.Header.Set(key, value)
+		}
+		if c.Body != "" {
+			target.Body = []byte(c.Body)
+		}
+		if c.BodyFile != "" {
+			body, err := ioutil.ReadFile(c.BodyFile)
+			if err != nil {
+				return nil, fmt.Errorf("error reading body of target %s %s: %v", target.Method, target.URL, err)
+			}
+			target.Body = body
+		}
+		targets = append(targets, target)
+	}
+	return targets, nil
+}
@@ -0,0 +1,145 @@
+package app
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	vegeta "github.com/tsenart/vegeta/lib"
+
+	"github.com/javking07/toadlester/conf"
+	"github.com/javking07/toadlester/model"
+)
+
+func TestTestTargets(t *testing.T) {
+	dir := t.TempDir()
+	body := filepath.Join(dir, "order.json")
+	require.NoError(t, ioutil.WriteFile(body, []byte(`{"sku": 1}`), 0644))
+	file := filepath.Join(dir, "targets.txt")
+	require.NoError(t, ioutil.WriteFile(file, []byte("GET http://localhost/a\n\nDELETE http://localhost/b\n"), 0644))
+
+	tests := map[string]struct {
+		test    conf.TestConfig
+		want    []vegeta.Target
+		wantErr bool
+	}{
+		"file": {
+			test: conf.TestConfig{Target: file},
+			want: []vegeta.Target{
+				{Method: http.MethodGet, URL: "http://localhost/a", Header: http.Header{}},
+				{Method: http.MethodDelete, URL: "http://localhost/b", Header: http.Header{}},
+			},
+		},
+		"missing file": {test: conf.TestConfig{Target: filepath.Join(dir, "missing.txt")}, wantErr: true},
+		"inline": {
+			test: conf.TestConfig{Targets: []conf.TargetConfig{
+				{URL: "http://localhost/health"},
+				{Method: http.MethodPost, URL: "http://localhost/orders", Headers: map[string]string{"content-type": "application/json"}, BodyFile: body},
+				{Method: http.MethodPut, URL: "http://localhost/orders/1", Body: "{}"},
+			}},
+			want: []vegeta.Target{
+				{Method: http.MethodGet, URL: "http://localhost/health", Header: http.Header{}},
+				{Method: http.MethodPost, URL: "http://localhost/orders", Header: http.Header{"Content-Type": {"application/json"}}, Body: []byte(`{"sku": 1}`)},
+				{Method: http.MethodPut, URL: "http://localhost/orders/1", Header: http.Header{}, Body: []byte("{}")},
+			},
+		},
+		"missing body file": {
+			test:    conf.TestConfig{Targets: []conf.TargetConfig{{URL: "http://localhost/", BodyFile: filepath.Join(dir, "missing.json")}}},
+			wantErr: true,
+		},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			got, err := testTargets(test.test)
+			if test.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, test.want, got)
+		})
+	}
+}
+
+func TestFileTargets(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, content string) string {
+		path := filepath.Join(dir, name)
+		require.NoError(t, ioutil.WriteFile(path, []byte(content), 0644))
+		return path
+	}
+	httpFile := write("targets.txt", "GET http://localhost/a\nX-Trace: 1\nX-Trace: 2\n\nPOST http://localhost/b\n")
+	jsonFile := write("targets.json", `{"method": "GET", "url": "http://localhost/a", "header": {"X-Trace": ["1", "2"]}}
+
+{"method": "POST", "url": "http://localhost/b", "body": "AAEC"}`)
+	want := []vegeta.Target{
+		{Method: http.MethodGet, URL: "http://localhost/a", Header: http.Header{"X-Trace": {"1", "2"}}},
+		{Method: http.MethodPost, URL: "http://localhost/b", Header: http.Header{}},
+	}
+	wantJSON := []vegeta.Target{want[0], want[1]}
+	wantJSON[1].Body = []byte{0, 1, 2}
+
+	tests := map[string]struct {
+		path    string
+		format  string
+		want    []vegeta.Target
+		wantErr string
+	}{
+		"http":                {path: httpFile, format: conf.FormatHTTP, want: want},
+		"json":                {path: jsonFile, format: conf.FormatJSON, want: wantJSON},
+		"detect http":         {path: httpFile, want: want},
+		"detect json":         {path: jsonFile, format: conf.FormatAuto, want: wantJSON},
+		"json as http":        {path: jsonFile, format: conf.FormatHTTP, wantErr: jsonFile + ":1: bad"},
+		"bad header":          {path: write("header.txt", "GET http://localhost/a\n\nGET http://localhost/b\nX-Trace 1\n"), wantErr: ":4: bad header: X-Trace 1"},
+		"bad url":             {path: write("url.txt", "GET http://localhost/a\n\n\nGET ://\n"), wantErr: ":4: bad URL"},
+		"bad json":            {path: write("bad.json", "{\"method\": \"GET\", \"url\": \"http://localhost/a\"}\n{\"method\": \"GET\"\n"), wantErr: "bad.json:2: "},
+		"json without method": {path: write("method.json", "\n{\"url\": \"http://localhost/a\"}\n"), wantErr: "method.json:2: " + vegeta.ErrNoMethod.Error()},
+		"empty":               {path: write("empty.txt", "\n"), wantErr: "empty.txt: " + vegeta.ErrNoTargets.Error()},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			got, err := fileTargets(test.path, test.format)
+			if test.wantErr != "" {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), test.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, test.want, got)
+		})
+	}
+}
+
+func TestApp_CreateTest(t *testing.T) {
+	tests := map[string]struct {
+		body    string
+		wantErr string
+	}{
+		"inline targets": {body: `{"name": "orders", "duration": "1m", "tps": 1, "targets": [{"url": "http://localhost/a", "body": "{}"}]}`},
+		"target file":    {body: `{"name": "orders", "duration": "1m", "tps": 1, "target": "/etc/passwd"}`, wantErr: "target files are only allowed in the config file"},
+		"body file":      {body: `{"name": "orders", "duration": "1m", "tps": 1, "targets": [{"url": "http://localhost/a", "bodyFile": "/etc/passwd"}]}`, wantErr: "bodyFile is only allowed in the config file"},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			a := App{Storage: model.NewMemoryStorage()}
+			w := httptest.NewRecorder()
+			a.createTest(w, httptest.NewRequest(http.MethodPost, "/tests", strings.NewReader(test.body)))
+
+			_, err := a.Storage.SelectTest(context.Background(), "orders")
+			if test.wantErr != "" {
+				assert.Equal(t, http.StatusBadRequest, w.Code)
+				assert.Contains(t, w.Body.String(), test.wantErr)
+				assert.ErrorIs(t, err, model.ErrNotFound)
+				return
+			}
+			assert.Equal(t, http.StatusCreated, w.Code)
+			assert.NoError(t, err)
+		})
+	}
+}
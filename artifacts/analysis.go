@@ -0,0 +1,200 @@
+package artifacts
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"time"
+
+	vegeta "github.com/tsenart/vegeta/lib"
+)
+
+// AnalysisOptions selects what an analysis computes besides the totals.
+type AnalysisOptions struct {
+	// Quantiles are the latency quantiles to compute, between 0 and 1.
+	Quantiles []float64
+	// Buckets are the latency histogram bucket boundaries, if any.
+	Buckets vegeta.Buckets
+	// Interval is the width of the time series buckets. Zero leaves the time
+	// series out.
+	Interval time.Duration
+}
+
+// Analysis is a summary of a result stream recomputed from every result.
+type Analysis struct {
+	Requests    uint64         `json:"requests"`
+	Success     float64        `json:"success"`
+	Earliest    time.Time      `json:"earliest"`
+	Latest      time.Time      `json:"latest"`
+	StatusCodes map[string]int `json:"statusCodes"`
+	Quantiles   []Quantile     `json:"quantiles"`
+	Histogram   []Bucket       `json:"histogram,omitempty"`
+	Series      []Point        `json:"series,omitempty"`
+}
+
+// Quantile is the exact latency at a quantile.
+type Quantile struct {
+	Quantile float64       `json:"quantile"`
+	Latency  time.Duration `json:"latency"`
+}
+
+// Bucket counts the results with a latency in [From, To), or from From up
+// when To is zero.
+type Bucket struct {
+	From  time.Duration `json:"from"`
+	To    time.Duration `json:"to,omitempty"`
+	Count uint64        `json:"count"`
+}
+
+// Point summarises the results sent during one interval of a time series.
+type Point struct {
+	Start       time.Time     `json:"start"`
+	Requests    uint64        `json:"requests"`
+	Success     float64       `json:"success"`
+	LatencyMean time.Duration `json:"latencyMean"`
+	LatencyMax  time.Duration `json:"latencyMax"`
+}
+
+// DefaultQuantiles are computed when no quantiles are requested.
+var DefaultQuantiles = []float64{0.5, 0.9, 0.95, 0.99, 0.999}
+
+// NewDecoder returns a decoder for a gzip compressed result stream in the
+// given format. The returned closer releases the gzip reader, not r.
+func NewDecoder(format string, r io.Reader) (vegeta.Decoder, io.Closer, error) {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, nil, err
+	}
+	switch format {
+	case FormatGob:
+		return vegeta.NewDecoder(gz), gz, nil
+	case FormatCSV:
+		return vegeta.NewCSVDecoder(gz), gz, nil
+	default:
+		gz.Close()
+		return nil, nil, fmt.Errorf("unknown artifacts format %q", format)
+	}
+}
+
+// Analyze reads every result from dec and summarises them. Quantiles are
+// exact rather than estimated, so every latency is held in memory.
+func Analyze(dec vegeta.Decoder, opts AnalysisOptions) (Analysis, error) {
+	if len(opts.Quantiles) == 0 {
+		opts.Quantiles = DefaultQuantiles
+	}
+	for _, q := range opts.Quantiles {
+		if q < 0 || q > 1 || math.IsNaN(q) {
+			return Analysis{}, fmt.Errorf("invalid quantile %v, expected a value between 0 and 1", q)
+		}
+	}
+
+	analysis := Analysis{StatusCodes: map[string]int{}}
+	hist := vegeta.Histogram{Buckets: opts.Buckets}
+	if len(hist.Buckets) > 0 {
+		hist.Counts = make([]uint64, len(hist.Buckets))
+	}
+	var latencies []time.Duration
+	var successes uint64
+	series := map[int64]*seriesPoint{}
+
+	for {
+		var res vegeta.Result
+		if err := dec.Decode(&res); err == io.EOF {
+			break
+		} else if err != nil {
+			return Analysis{}, err
+		}
+
+		analysis.Requests++
+		latencies = append(latencies, res.Latency)
+		analysis.StatusCodes[strconv.Itoa(int(res.Code))]++
+		if analysis.Earliest.IsZero() || res.Timestamp.Before(analysis.Earliest) {
+			analysis.Earliest = res.Timestamp
+		}
+		if res.Timestamp.After(analysis.Latest) {
+			analysis.Latest = res.Timestamp
+		}
+		ok := res.Code >= 200 && res.Code < 400
+		if ok {
+			successes++
+		}
+		if len(hist.Buckets) > 0 {
+			hist.Add(&res)
+		}
+		if opts.Interval > 0 {
+			key := res.Timestamp.UnixNano() / int64(opts.Interval)
+			p, found := series[key]
+			if !found {
+				p = &seriesPoint{}
+				series[key] = p
+			}
+			p.add(res.Latency, ok)
+		}
+	}
+	if analysis.Requests == 0 {
+		return analysis, nil
+	}
+	analysis.Success = float64(successes) / float64(analysis.Requests)
+
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+	for _, q := range opts.Quantiles {
+		analysis.Quantiles = append(analysis.Quantiles, Quantile{Quantile: q, Latency: nearestRank(latencies, q)})
+	}
+
+	for i, from := range hist.Buckets {
+		bucket := Bucket{From: from, Count: hist.Counts[i]}
+		if i+1 < len(hist.Buckets) {
+			bucket.To = hist.Buckets[i+1]
+		}
+		analysis.Histogram = append(analysis.Histogram, bucket)
+	}
+
+	keys := make([]int64, 0, len(series))
+	for key := range series {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i] < keys[j] })
+	for _, key := range keys {
+		analysis.Series = append(analysis.Series, series[key].point(time.Unix(0, key*int64(opts.Interval)).UTC()))
+	}
+	return analysis, nil
+}
+
+// nearestRank returns the q quantile of sorted latencies.
+func nearestRank(sorted []time.Duration, q float64) time.Duration {
+	rank := int(math.Ceil(q*float64(len(sorted)))) - 1
+	if rank < 0 {
+		rank = 0
+	}
+	return sorted[rank]
+}
+
+// seriesPoint accumulates the results of one time series interval.
+type seriesPoint struct {
+	requests, successes uint64
+	total, max          time.Duration
+}
+
+func (p *seriesPoint) add(latency time.Duration, ok bool) {
+	p.requests++
+	if ok {
+		p.successes++
+	}
+	p.total += latency
+	if latency > p.max {
+		p.max = latency
+	}
+}
+
+func (p *seriesPoint) point(start time.Time) Point {
+	return Point{
+		Start:       start,
+		Requests:    p.requests,
+		Success:     float64(p.successes) / float64(p.requests),
+		LatencyMean: p.total / time.Duration(p.requests),
+		LatencyMax:  p.max,
+	}
+}
@@ -0,0 +1,91 @@
+package artifacts
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	vegeta "github.com/tsenart/vegeta/lib"
+)
+
+func TestAnalyze(t *testing.T) {
+	start := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+	results := testResults(start)
+
+	var buckets vegeta.Buckets
+	require.NoError(t, buckets.UnmarshalText([]byte("[0,50ms,90ms]")))
+	analysis, err := Analyze(sliceDecoder(results), AnalysisOptions{
+		Quantiles: []float64{0, 0.5, 0.95, 1},
+		Buckets:   buckets,
+		Interval:  500 * time.Millisecond,
+	})
+	require.NoError(t, err)
+
+	assert.Equal(t, uint64(10), analysis.Requests)
+	assert.Equal(t, 0.8, analysis.Success)
+	assert.Equal(t, start, analysis.Earliest)
+	assert.Equal(t, start.Add(900*time.Millisecond), analysis.Latest)
+	assert.Equal(t, map[string]int{"200": 8, "500": 2}, analysis.StatusCodes)
+	assert.Equal(t, []Quantile{
+		{Quantile: 0, Latency: 10 * time.Millisecond},
+		{Quantile: 0.5, Latency: 50 * time.Millisecond},
+		{Quantile: 0.95, Latency: 100 * time.Millisecond},
+		{Quantile: 1, Latency: 100 * time.Millisecond},
+	}, analysis.Quantiles)
+	assert.Equal(t, []Bucket{
+		{From: 0, To: 50 * time.Millisecond, Count: 4},
+		{From: 50 * time.Millisecond, To: 90 * time.Millisecond, Count: 4},
+		{From: 90 * time.Millisecond, Count: 2},
+	}, analysis.Histogram)
+	assert.Equal(t, []Point{
+		{Start: start, Requests: 5, Success: 1, LatencyMean: 30 * time.Millisecond, LatencyMax: 50 * time.Millisecond},
+		{Start: start.Add(500 * time.Millisecond), Requests: 5, Success: 0.6, LatencyMean: 80 * time.Millisecond, LatencyMax: 100 * time.Millisecond},
+	}, analysis.Series)
+}
+
+func TestAnalyze_Defaults(t *testing.T) {
+	analysis, err := Analyze(sliceDecoder(testResults(time.Now())), AnalysisOptions{})
+	require.NoError(t, err)
+	assert.Len(t, analysis.Quantiles, len(DefaultQuantiles))
+	assert.Empty(t, analysis.Histogram)
+	assert.Empty(t, analysis.Series)
+
+	analysis, err = Analyze(sliceDecoder(nil), AnalysisOptions{})
+	require.NoError(t, err)
+	assert.Zero(t, analysis.Requests)
+
+	_, err = Analyze(sliceDecoder(nil), AnalysisOptions{Quantiles: []float64{1.5}})
+	assert.Error(t, err)
+}
+
+// sliceDecoder decodes results from a slice.
+func sliceDecoder(results []vegeta.Result) vegeta.Decoder {
+	return func(r *vegeta.Result) error {
+		if len(results) == 0 {
+			return io.EOF
+		}
+		*r, results = results[0], results[1:]
+		return nil
+	}
+}
+
+func TestWritePlot(t *testing.T) {
+	start := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+	analysis, err := Analyze(sliceDecoder(testResults(start)), AnalysisOptions{Interval: 100 * time.Millisecond})
+	require.NoError(t, err)
+
+	var buf bytes.Buffer
+	require.NoError(t, WritePlot(&buf, "run <1>", analysis))
+	out := buf.String()
+	assert.True(t, strings.HasPrefix(out, "<svg"))
+	assert.Contains(t, out, "run &lt;1&gt;")
+	assert.Equal(t, 2, strings.Count(out, "<polyline"))
+
+	buf.Reset()
+	require.NoError(t, WritePlot(&buf, "empty", Analysis{}))
+	assert.Contains(t, buf.String(), "no results")
+}
@@ -0,0 +1,89 @@
+package artifacts
+
+import (
+	"bufio"
+	"fmt"
+	"html"
+	"io"
+	"time"
+)
+
+// plot dimensions in svg user units
+const (
+	plotWidth   = 960
+	plotHeight  = 400
+	plotMargin  = 60
+	plotXTicks  = 6
+	plotYTicks  = 5
+	plotMeanRGB = "#1f77b4"
+	plotMaxRGB  = "#d62728"
+)
+
+// WritePlot renders the time series of an analysis as an svg line chart of
+// the mean and max latency of every interval.
+func WritePlot(w io.Writer, title string, analysis Analysis) error {
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" viewBox="0 0 %d %d" font-family="sans-serif" font-size="12">`+"\n",
+		plotWidth, plotHeight, plotWidth, plotHeight)
+	fmt.Fprintf(bw, `<rect width="100%%" height="100%%" fill="white"/>`+"\n")
+	fmt.Fprintf(bw, `<text x="%d" y="20" font-size="14">%s</text>`+"\n", plotMargin, html.EscapeString(title))
+
+	series := analysis.Series
+	if len(series) == 0 {
+		fmt.Fprintf(bw, `<text x="%d" y="%d">no results</text>`+"\n", plotMargin, plotHeight/2)
+		fmt.Fprintln(bw, `</svg>`)
+		return bw.Flush()
+	}
+
+	start, end := series[0].Start, series[len(series)-1].Start
+	var top time.Duration
+	for _, p := range series {
+		if p.LatencyMax > top {
+			top = p.LatencyMax
+		}
+	}
+	if top == 0 {
+		top = time.Millisecond
+	}
+	span := end.Sub(start)
+	x := func(t time.Time) float64 {
+		if span == 0 {
+			return plotMargin
+		}
+		return plotMargin + float64(t.Sub(start))/float64(span)*(plotWidth-2*plotMargin)
+	}
+	y := func(d time.Duration) float64 {
+		return plotHeight - plotMargin - float64(d)/float64(top)*(plotHeight-2*plotMargin)
+	}
+
+	// axes and ticks
+	fmt.Fprintf(bw, `<g stroke="#999"><line x1="%d" y1="%d" x2="%d" y2="%d"/><line x1="%d" y1="%d" x2="%d" y2="%d"/></g>`+"\n",
+		plotMargin, plotHeight-plotMargin, plotWidth-plotMargin, plotHeight-plotMargin,
+		plotMargin, plotMargin, plotMargin, plotHeight-plotMargin)
+	for i := 0; i <= plotYTicks; i++ {
+		d := top * time.Duration(i) / plotYTicks
+		fmt.Fprintf(bw, `<text x="%d" y="%.1f" text-anchor="end">%s</text>`+"\n", plotMargin-5, y(d)+4, d.Round(time.Microsecond))
+	}
+	for i := 0; i <= plotXTicks; i++ {
+		offset := span * time.Duration(i) / plotXTicks
+		fmt.Fprintf(bw, `<text x="%.1f" y="%d" text-anchor="middle">+%s</text>`+"\n", x(start.Add(offset)), plotHeight-plotMargin+18, offset.Round(time.Millisecond))
+	}
+
+	for _, line := range []struct {
+		color   string
+		latency func(Point) time.Duration
+	}{
+		{plotMeanRGB, func(p Point) time.Duration { return p.LatencyMean }},
+		{plotMaxRGB, func(p Point) time.Duration { return p.LatencyMax }},
+	} {
+		fmt.Fprintf(bw, `<polyline fill="none" stroke="%s" stroke-width="1.5" points="`, line.color)
+		for _, p := range series {
+			fmt.Fprintf(bw, "%.1f,%.1f ", x(p.Start), y(line.latency(p)))
+		}
+		fmt.Fprintln(bw, `"/>`)
+	}
+	fmt.Fprintf(bw, `<text x="%d" y="40" fill="%s">mean latency</text><text x="%d" y="40" fill="%s">max latency</text>`+"\n",
+		plotWidth-plotMargin-200, plotMeanRGB, plotWidth-plotMargin-100, plotMaxRGB)
+	fmt.Fprintln(bw, `</svg>`)
+	return bw.Flush()
+}
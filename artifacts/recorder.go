@@ -0,0 +1,73 @@
+package artifacts
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	vegeta "github.com/tsenart/vegeta/lib"
+)
+
+// Recorder writes the results of a run, gzip compressed, to a temporary
+// file until they are saved to a Store. It is not safe for concurrent use.
+type Recorder struct {
+	format string
+	file   *os.File
+	buf    *bufio.Writer
+	gz     *gzip.Writer
+	enc    vegeta.Encoder
+}
+
+// NewRecorder returns a recorder encoding results in the given format.
+func NewRecorder(format string) (*Recorder, error) {
+	if format == "" {
+		format = FormatGob
+	}
+	if !ValidFormat(format) {
+		return nil, fmt.Errorf("unknown artifacts format %q, expected gob or csv", format)
+	}
+	file, err := ioutil.TempFile("", "toadlester-results-")
+	if err != nil {
+		return nil, err
+	}
+
+	r := &Recorder{format: format, file: file, buf: bufio.NewWriter(file)}
+	r.gz = gzip.NewWriter(r.buf)
+	if format == FormatCSV {
+		r.enc = vegeta.NewCSVEncoder(r.gz)
+	} else {
+		r.enc = vegeta.NewEncoder(r.gz)
+	}
+	return r, nil
+}
+
+// Encode records a result.
+func (r *Recorder) Encode(res *vegeta.Result) error {
+	return r.enc.Encode(res)
+}
+
+// Save stores the recorded results as the artifact of a result and removes
+// the temporary file.
+func (r *Recorder) Save(ctx context.Context, store Store, test, resultID string) error {
+	defer r.Discard()
+
+	if err := r.gz.Close(); err != nil {
+		return err
+	}
+	if err := r.buf.Flush(); err != nil {
+		return err
+	}
+	if _, err := r.file.Seek(0, 0); err != nil {
+		return err
+	}
+	return store.Save(ctx, test, resultID, r.format, r.file)
+}
+
+// Discard removes the temporary file without saving it.
+func (r *Recorder) Discard() {
+	r.file.Close()
+	os.Remove(r.file.Name())
+}
@@ -0,0 +1,178 @@
+// Package artifacts keeps the raw result streams of runs and analyses them
+// after the fact.
+package artifacts
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/javking07/toadlester/conf"
+	"github.com/javking07/toadlester/model"
+)
+
+// Encodings of the stored result streams.
+const (
+	FormatGob = "gob"
+	FormatCSV = "csv"
+)
+
+// Store keeps the gzip compressed result streams of runs. Missing artifacts
+// are reported as model.ErrNotFound.
+type Store interface {
+	// Save stores the result stream of the result of test read from r.
+	Save(ctx context.Context, test, resultID, format string, r io.Reader) error
+	// Open returns the format and compressed result stream of a result.
+	Open(ctx context.Context, resultID string) (string, io.ReadCloser, error)
+	// Delete removes the result stream of a result, if any.
+	Delete(ctx context.Context, resultID string) error
+	// Prune removes the result streams of test saved before a point in time
+	// and returns how many were removed.
+	Prune(ctx context.Context, test string, before time.Time) (int, error)
+}
+
+// NewStore returns the artifact store selected by config.
+func NewStore(config conf.ArtifactsConfig, storage model.Storage) (Store, error) {
+	switch config.Store {
+	case "", "database":
+		return DatabaseStore{storage}, nil
+	case "disk":
+		if config.Path == "" {
+			return nil, fmt.Errorf("artifacts path is required for the disk store")
+		}
+		return DiskStore{config.Path}, os.MkdirAll(config.Path, 0755)
+	default:
+		return nil, fmt.Errorf("unknown artifacts store %q, expected database or disk", config.Store)
+	}
+}
+
+// ValidFormat reports whether format is a known encoding.
+func ValidFormat(format string) bool {
+	return format == FormatGob || format == FormatCSV
+}
+
+// DatabaseStore keeps artifacts in storage next to their results, which
+// deletes them along with the result.
+type DatabaseStore struct {
+	Storage model.Storage
+}
+
+func (s DatabaseStore) Save(ctx context.Context, _, resultID, format string, r io.Reader) error {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	return s.Storage.InsertArtifact(ctx, model.Artifact{ResultID: resultID, Format: format, Data: data})
+}
+
+func (s DatabaseStore) Open(ctx context.Context, resultID string) (string, io.ReadCloser, error) {
+	artifact, err := s.Storage.SelectArtifact(ctx, resultID)
+	if err != nil {
+		return "", nil, err
+	}
+	return artifact.Format, ioutil.NopCloser(bytes.NewReader(artifact.Data)), nil
+}
+
+// Delete does nothing; artifacts are deleted along with their result.
+func (s DatabaseStore) Delete(context.Context, string) error {
+	return nil
+}
+
+// Prune does nothing; artifacts are deleted along with their result.
+func (s DatabaseStore) Prune(context.Context, string, time.Time) (int, error) {
+	return 0, nil
+}
+
+// DiskStore keeps artifacts as files named <test>/<result id>.<format>.gz
+// under a directory.
+type DiskStore struct {
+	Dir string
+}
+
+func (s DiskStore) Save(_ context.Context, test, resultID, format string, r io.Reader) error {
+	dir := filepath.Join(s.Dir, url.PathEscape(test))
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	// write to a temporary name first so that readers never see a partial
+	// file
+	tmp, err := ioutil.TempFile(dir, ".tmp-"+resultID)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := io.Copy(tmp, r); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), filepath.Join(dir, resultID+"."+format+".gz"))
+}
+
+func (s DiskStore) Open(_ context.Context, resultID string) (string, io.ReadCloser, error) {
+	path, format, err := s.find(resultID)
+	if err != nil {
+		return "", nil, err
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return "", nil, err
+	}
+	return format, f, nil
+}
+
+func (s DiskStore) Delete(_ context.Context, resultID string) error {
+	path, _, err := s.find(resultID)
+	if err == model.ErrNotFound {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	return os.Remove(path)
+}
+
+func (s DiskStore) Prune(_ context.Context, test string, before time.Time) (int, error) {
+	files, err := ioutil.ReadDir(filepath.Join(s.Dir, url.PathEscape(test)))
+	if os.IsNotExist(err) {
+		return 0, nil
+	} else if err != nil {
+		return 0, err
+	}
+	pruned := 0
+	for _, f := range files {
+		if f.IsDir() || !strings.HasSuffix(f.Name(), ".gz") || !f.ModTime().Before(before) {
+			continue
+		}
+		if err := os.Remove(filepath.Join(s.Dir, url.PathEscape(test), f.Name())); err != nil {
+			return pruned, err
+		}
+		pruned++
+	}
+	return pruned, nil
+}
+
+// find returns the path and format of the artifact of a result.
+func (s DiskStore) find(resultID string) (string, string, error) {
+	if strings.ContainsAny(resultID, `/\*?[`) {
+		return "", "", model.ErrNotFound
+	}
+	for _, format := range []string{FormatGob, FormatCSV} {
+		matches, err := filepath.Glob(filepath.Join(s.Dir, "*", resultID+"."+format+".gz"))
+		if err != nil {
+			return "", "", err
+		}
+		if len(matches) > 0 {
+			return matches[0], format, nil
+		}
+	}
+	return "", "", model.ErrNotFound
+}
@@ -0,0 +1,133 @@
+package artifacts
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	uuid "github.com/satori/go.uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	vegeta "github.com/tsenart/vegeta/lib"
+
+	"github.com/javking07/toadlester/conf"
+	"github.com/javking07/toadlester/model"
+)
+
+func TestDiskStore(t *testing.T) {
+	dir := t.TempDir()
+	store, err := NewStore(conf.ArtifactsConfig{Store: "disk", Path: dir}, nil)
+	require.NoError(t, err)
+	testStore(t, store, "load test/1")
+
+	// pruning removes only the artifacts of the test saved before the cutoff
+	ctx := context.Background()
+	old, recent := uuid.NewV4().String(), uuid.NewV4().String()
+	for _, id := range []string{old, recent} {
+		rec, err := NewRecorder(FormatCSV)
+		require.NoError(t, err)
+		require.NoError(t, rec.Save(ctx, store, "pruned", id))
+	}
+	past := time.Now().Add(-48 * time.Hour)
+	require.NoError(t, os.Chtimes(filepath.Join(dir, "pruned", old+".csv.gz"), past, past))
+
+	pruned, err := store.Prune(ctx, "pruned", time.Now().Add(-time.Hour))
+	require.NoError(t, err)
+	assert.Equal(t, 1, pruned)
+	_, _, err = store.Open(ctx, old)
+	assert.ErrorIs(t, err, model.ErrNotFound)
+	_, r, err := store.Open(ctx, recent)
+	require.NoError(t, err)
+	r.Close()
+
+	pruned, err = store.Prune(ctx, "missing", time.Now())
+	assert.NoError(t, err)
+	assert.Zero(t, pruned)
+}
+
+func TestDatabaseStore(t *testing.T) {
+	storage := model.NewMemoryStorage()
+	store, err := NewStore(conf.ArtifactsConfig{}, storage)
+	require.NoError(t, err)
+	testStore(t, store, "test")
+}
+
+func TestNewStore_Invalid(t *testing.T) {
+	_, err := NewStore(conf.ArtifactsConfig{Store: "disk"}, nil)
+	assert.Error(t, err)
+	_, err = NewStore(conf.ArtifactsConfig{Store: "s3"}, nil)
+	assert.Error(t, err)
+	_, err = NewRecorder("json")
+	assert.Error(t, err)
+}
+
+// testStore records results in every format, saves them to store and reads
+// them back.
+func testStore(t *testing.T, store Store, test string) {
+	ctx := context.Background()
+	storage, _ := store.(DatabaseStore)
+	for _, format := range []string{FormatGob, FormatCSV} {
+		t.Run(format, func(t *testing.T) {
+			id := uuid.NewV4().String()
+			if storage.Storage != nil {
+				// database artifacts belong to a stored result
+				_, err := storage.Storage.Insert(ctx, model.Payload{ID: id, Name: test})
+				require.NoError(t, err)
+			}
+
+			results := testResults(time.Now().UTC().Truncate(time.Millisecond))
+			rec, err := NewRecorder(format)
+			require.NoError(t, err)
+			for i := range results {
+				require.NoError(t, rec.Encode(&results[i]))
+			}
+			require.NoError(t, rec.Save(ctx, store, test, id))
+
+			got, r, err := store.Open(ctx, id)
+			require.NoError(t, err)
+			assert.Equal(t, format, got)
+			dec, gz, err := NewDecoder(got, r)
+			require.NoError(t, err)
+			for i := range results {
+				var res vegeta.Result
+				require.NoError(t, dec.Decode(&res))
+				assert.Equal(t, results[i].Latency, res.Latency)
+				assert.Equal(t, results[i].Code, res.Code)
+				assert.True(t, results[i].Timestamp.Equal(res.Timestamp))
+			}
+			gz.Close()
+			r.Close()
+
+			assert.NoError(t, store.Delete(ctx, id))
+			if storage.Storage == nil {
+				_, _, err = store.Open(ctx, id)
+				assert.ErrorIs(t, err, model.ErrNotFound)
+			}
+		})
+	}
+
+	_, _, err := store.Open(ctx, uuid.NewV4().String())
+	assert.ErrorIs(t, err, model.ErrNotFound)
+}
+
+// testResults returns ten results sent a hundred milliseconds apart from
+// start, with latencies of 10 to 100ms. The last two failed.
+func testResults(start time.Time) []vegeta.Result {
+	var results []vegeta.Result
+	for i := 0; i < 10; i++ {
+		res := vegeta.Result{
+			Attack:    "test",
+			Seq:       uint64(i),
+			Code:      200,
+			Timestamp: start.Add(time.Duration(i) * 100 * time.Millisecond),
+			Latency:   time.Duration(i+1) * 10 * time.Millisecond,
+		}
+		if i >= 8 {
+			res.Code, res.Error = 500, "500 Internal Server Error"
+		}
+		results = append(results, res)
+	}
+	return results
+}
@@ -0,0 +1,94 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+	"text/tabwriter"
+
+	"github.com/javking07/toadlester/model"
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/cobra"
+)
+
+// migrateCmd manages the database schema
+var migrateCmd = &cobra.Command{
+	Use:   "migrate",
+	Short: "Manage database schema migrations",
+}
+
+var migrateUpCmd = &cobra.Command{
+	Use:   "up",
+	Short: "Apply all pending migrations",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		applied, err := migrator().MigrateUp(context.Background())
+		if err != nil {
+			log.Fatal().Msgf("error applying migrations: %v", err)
+		}
+		fmt.Printf("applied %d migrations\n", applied)
+	},
+}
+
+var migrateDownCmd = &cobra.Command{
+	Use:   "down [steps]",
+	Short: "Revert the most recent migrations (default 1)",
+	Args:  cobra.MaximumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		steps := 1
+		if len(args) == 1 {
+			var err error
+			if steps, err = strconv.Atoi(args[0]); err != nil || steps < 1 {
+				log.Fatal().Msgf("invalid number of steps: %s", args[0])
+			}
+		}
+		reverted, err := migrator().MigrateDown(context.Background(), steps)
+		if err != nil {
+			log.Fatal().Msgf("error reverting migrations: %v", err)
+		}
+		fmt.Printf("reverted %d migrations\n", reverted)
+	},
+}
+
+var migrateStatusCmd = &cobra.Command{
+	Use:   "status",
+	Short: "List migrations and whether they are applied",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		status, err := migrator().MigrationStatus(context.Background())
+		if err != nil {
+			log.Fatal().Msgf("error reading migration status: %v", err)
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "VERSION\tNAME\tSTATUS\tAPPLIED AT")
+		for _, m := range status {
+			state, appliedAt := "pending", ""
+			if m.Applied {
+				state, appliedAt = "applied", m.AppliedAt.String()
+			}
+			fmt.Fprintf(w, "%d\t%s\t%s\t%s\n", m.Version, m.Name, state, appliedAt)
+		}
+		_ = w.Flush()
+	},
+}
+
+// migrator connects to the configured database without migrating it.
+func migrator() model.Migrator {
+	loadConfig()
+	db, err := model.OpenStorage(context.Background(), config.Database)
+	if err != nil {
+		log.Fatal().Msgf("error connecting to database: %v", err)
+	}
+	m, ok := db.(model.Migrator)
+	if !ok {
+		log.Fatal().Msgf("database type %q does not support migrations", config.Database.Type)
+	}
+	return m
+}
+
+func init() {
+	migrateCmd.AddCommand(migrateUpCmd, migrateDownCmd, migrateStatusCmd)
+	rootCmd.AddCommand(migrateCmd)
+}
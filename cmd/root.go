@@ -17,6 +17,7 @@ package cmd
 import (
 	"fmt"
 	"os"
+	"path/filepath"
 
 	"github.com/javking07/toadlester/conf"
 	"github.com/rs/zerolog/log"
@@ -38,23 +39,31 @@ var rootCmd = &cobra.Command{
 	Long: `toadlester provides a consistent load testing tool,
 		   that can be deployed anywhere a user expects to receive requests from`,
 	Run: func(cmd *cobra.Command, args []string) {
-		viperInstance := viper.GetViper()
-		err := viperInstance.Unmarshal(&config)
-		if err != nil {
-			log.Panic().Msgf("error parsing config: %s", err.Error())
-		}
-
-		// fallback to default config, if config is blank
-		if config == nil {
-			log.Info().Msg("no viable config available. falling back to sane defaults.\n")
-			config = conf.SaneDefaults()
-		}
+		loadConfig()
 
 		App.Bootstrap(config)
 		App.RunApp(config)
 	},
 }
 
+// loadConfig parses the config read by viper, falling back to sane defaults.
+func loadConfig() {
+	viperInstance := viper.GetViper()
+	err := viperInstance.Unmarshal(&config, viper.DecodeHook(conf.DecodeHook()))
+	if err != nil {
+		log.Panic().Msgf("error parsing config: %s", err.Error())
+	}
+
+	// fallback to default config, if config is blank
+	if config == nil {
+		log.Info().Msg("no viable config available. falling back to sane defaults.\n")
+		config = conf.SaneDefaults()
+	}
+	if file := viperInstance.ConfigFileUsed(); file != "" {
+		config.ResolvePaths(filepath.Dir(file))
+	}
+}
+
 // Execute adds all child commands to the root command and sets flags appropriately.
 // This is called by main.main(). It only needs to happen once to the rootCmd.
 func Execute() {
@@ -1,32 +1,397 @@
 package conf
 
 import (
+	"errors"
+	"fmt"
+	"net/url"
+	"path/filepath"
 	"time"
+
+	"github.com/javking07/toadlester/load"
+	"github.com/javking07/toadlester/schedule"
 )
 
 // Config is application config
 type Config struct {
-	Database *DatabaseConfig `json:"database" yaml:"database"`
-	Logging  *LoggingConfig  `json:"logging" yaml:"logging"`
-	Sleep    *time.Duration  `json:"sleep" yaml:"sleep"`
-	Timer    *TimerConfig    `json:"timer" yaml:"timer"`
-	Tests    []struct {
-		Name     string         `json:"name"`
-		Duration *time.Duration `json:"duration"` // in seconds
-		TPS      int            `json:"tps"`
-		Target   string         `json:"target"`
-	} `json:"tests" yaml:"tests"`
+	Database  *DatabaseConfig  `json:"database" yaml:"database"`
+	Logging   *LoggingConfig   `json:"logging" yaml:"logging"`
+	Influx    *InfluxConfig    `json:"influx" yaml:"influx"`
+	Server    *ServerConfig    `json:"server" yaml:"server"`
+	Sleep     *time.Duration   `json:"sleep" yaml:"sleep"`
+	Timer     *TimerConfig     `json:"timer" yaml:"timer"`
+	Compactor *CompactorConfig `json:"compactor" yaml:"compactor"`
+	Artifacts *ArtifactsConfig `json:"artifacts" yaml:"artifacts"`
+	Spool     *SpoolConfig     `json:"spool" yaml:"spool"`
+	Tests     []TestConfig     `json:"tests" yaml:"tests"`
+}
+
+// TestConfig defines a load test. Tests in the config file seed the test
+// definitions held in storage.
+type TestConfig struct {
+	Name     string    `json:"name" yaml:"name"`
+	Duration *Duration `json:"duration" yaml:"duration"`
+	TPS      int       `json:"tps" yaml:"tps"`
+	// Target is a file of vegeta http format targets.
+	Target string `json:"target,omitempty" yaml:"target"`
+	// Format is the format of the target file, FormatAuto by default.
+	Format string `json:"format,omitempty" yaml:"format"`
+	// Targets declares the requests of the test inline, in place of a
+	// target file.
+	Targets []TargetConfig `json:"targets,omitempty" yaml:"targets"`
+	// Labels are stored with every result of the test and can be used to
+	// filter results.
+	Labels map[string]string `json:"labels,omitempty" yaml:"labels"`
+	// Retention limits how long results of the test are kept. Without it the
+	// compactor's default applies.
+	Retention *RetentionConfig `json:"retention,omitempty" yaml:"retention"`
+	// Schedule sets when the test runs. Without it the test runs every timer
+	// interval.
+	Schedule *ScheduleConfig `json:"schedule,omitempty" yaml:"schedule"`
+	// Overlap decides what happens when a scheduled run is due while the
+	// previous run of the test is still in progress, OverlapSkip by default.
+	Overlap string `json:"overlap,omitempty" yaml:"overlap"`
+	// Profile varies the request rate over the run. Without it the test runs
+	// at a constant TPS.
+	Profile *ProfileConfig `json:"profile,omitempty" yaml:"profile"`
+	// Arrivals sets how requests are spaced, evenly by default.
+	Arrivals *ArrivalsConfig `json:"arrivals,omitempty" yaml:"arrivals"`
+	// Users runs the test with a fixed number of virtual users in place of a
+	// request rate.
+	Users *UsersConfig `json:"users,omitempty" yaml:"users"`
+}
+
+// Overlap policies of a test.
+const (
+	OverlapSkip           = "skip"            // the new run is skipped and recorded as such
+	OverlapQueue          = "queue"           // the new run starts once the previous one finished
+	OverlapCancelPrevious = "cancel-previous" // the previous run is cancelled and the new one starts
+)
+
+// Formats of target files.
+const (
+	FormatAuto = "auto" // json when the file starts with a json object, http otherwise
+	FormatHTTP = "http" // vegeta's http format
+	FormatJSON = "json" // vegeta's json format, a target per line
+)
+
+// Validate reports whether the test definition can be run.
+func (t TestConfig) Validate() error {
+	switch {
+	case t.Name == "":
+		return errors.New("name is required")
+	case t.Duration == nil || t.Duration.Duration <= 0:
+		return errors.New("duration must be greater than zero")
+	case t.TPS <= 0 && t.Profile == nil && t.Users == nil:
+		return errors.New("tps must be greater than zero")
+	case t.Target == "" && len(t.Targets) == 0:
+		return errors.New("target or targets are required")
+	case t.Target != "" && len(t.Targets) > 0:
+		return errors.New("target and targets are exclusive")
+	}
+	switch t.Format {
+	case "", FormatAuto, FormatHTTP, FormatJSON:
+	default:
+		return fmt.Errorf("unknown target format %q, expected auto, http or json", t.Format)
+	}
+	if t.Format != "" && t.Target == "" {
+		return errors.New("format only applies to target files")
+	}
+	for i, target := range t.Targets {
+		if err := target.Validate(); err != nil {
+			return fmt.Errorf("target %d: %w", i+1, err)
+		}
+	}
+	if t.Retention != nil {
+		if err := t.Retention.Validate(); err != nil {
+			return fmt.Errorf("retention: %w", err)
+		}
+	}
+	switch t.Overlap {
+	case "", OverlapSkip, OverlapQueue, OverlapCancelPrevious:
+	default:
+		return fmt.Errorf("unknown overlap policy %q, expected skip, queue or cancel-previous", t.Overlap)
+	}
+	if t.Profile != nil {
+		if _, err := load.Parse(t.Profile.Spec(t.TPS), t.Duration.Duration); err != nil {
+			return fmt.Errorf("profile: %w", err)
+		}
+	}
+	if t.Users != nil {
+		if t.Profile != nil || t.Arrivals != nil {
+			return errors.New("users do not combine with a profile or arrivals, which pace requests by rate")
+		}
+		if err := t.Users.Spec().Validate(); err != nil {
+			return fmt.Errorf("users: %w", err)
+		}
+	}
+	if t.Arrivals != nil {
+		if err := t.Arrivals.Spec().Validate(); err != nil {
+			return fmt.Errorf("arrivals: %w", err)
+		}
+	}
+	if t.Schedule != nil {
+		if _, err := schedule.Parse(t.Schedule.Spec(), time.Now()); err != nil {
+			return fmt.Errorf("schedule: %w", err)
+		}
+	}
+	return nil
+}
+
+// TargetConfig is a request declared inline, in a test or an ad-hoc run. The
+// body is either given as Body or read from BodyFile for every run.
+type TargetConfig struct {
+	Method   string            `json:"method,omitempty" yaml:"method"`
+	URL      string            `json:"url" yaml:"url"`
+	Headers  map[string]string `json:"headers,omitempty" yaml:"headers"`
+	Body     string            `json:"body,omitempty" yaml:"body"`
+	BodyFile string            `json:"bodyFile,omitempty" yaml:"bodyFile"`
+}
+
+// Validate reports whether the target can be requested.
+func (t TargetConfig) Validate() error {
+	if t.URL == "" {
+		return errors.New("url is required")
+	}
+	if _, err := url.ParseRequestURI(t.URL); err != nil {
+		return fmt.Errorf("invalid url: %w", err)
+	}
+	if t.Body != "" && t.BodyFile != "" {
+		return errors.New("body and bodyFile are exclusive")
+	}
+	return nil
+}
+
+// ResolvePaths makes the body files of inline targets that are relative
+// paths relative to dir, the directory of the config file.
+func (c *Config) ResolvePaths(dir string) {
+	for i := range c.Tests {
+		for j, target := range c.Tests[i].Targets {
+			if target.BodyFile != "" && !filepath.IsAbs(target.BodyFile) {
+				c.Tests[i].Targets[j].BodyFile = filepath.Join(dir, target.BodyFile)
+			}
+		}
+	}
+}
+
+// ProfileConfig is the load profile of a test: a linear ramp, a staircase of
+// steps, a spike or a sine wave. Spikes rise from the test's TPS and sine
+// waves swing around it.
+type ProfileConfig struct {
+	Ramp  *RampConfig  `json:"ramp,omitempty" yaml:"ramp"`
+	Steps []StepConfig `json:"steps,omitempty" yaml:"steps"`
+	Spike *SpikeConfig `json:"spike,omitempty" yaml:"spike"`
+	Sine  *SineConfig  `json:"sine,omitempty" yaml:"sine"`
+}
+
+// RampConfig ramps the rate linearly from From to To TPS over the run.
+// Results are broken down into Segments stages of equal length.
+type RampConfig struct {
+	From     int `json:"from" yaml:"from"`
+	To       int `json:"to" yaml:"to"`
+	Segments int `json:"segments,omitempty" yaml:"segments"`
+}
+
+// StepConfig holds a rate for a while. The last step holds until the end of
+// the run.
+type StepConfig struct {
+	TPS  int       `json:"tps" yaml:"tps"`
+	Hold *Duration `json:"hold" yaml:"hold"`
+}
+
+// SpikeConfig raises the rate to Peak TPS for Length, At into the run.
+type SpikeConfig struct {
+	Peak   int       `json:"peak" yaml:"peak"`
+	At     *Duration `json:"at" yaml:"at"`
+	Length *Duration `json:"length" yaml:"length"`
+}
+
+// SineConfig swings the rate by Amplitude TPS every Period.
+type SineConfig struct {
+	Amplitude int       `json:"amplitude" yaml:"amplitude"`
+	Period    *Duration `json:"period" yaml:"period"`
+}
+
+// Spec returns the profile spec of the config for a test running at tps.
+func (p ProfileConfig) Spec(tps int) load.Spec {
+	spec := load.Spec{TPS: float64(tps)}
+	if p.Ramp != nil {
+		spec.Ramp = &load.RampSpec{From: float64(p.Ramp.From), To: float64(p.Ramp.To), Segments: p.Ramp.Segments}
+	}
+	for _, step := range p.Steps {
+		spec.Steps = append(spec.Steps, load.Step{TPS: float64(step.TPS), Hold: durationOf(step.Hold)})
+	}
+	if p.Spike != nil {
+		spec.Spike = &load.SpikeSpec{Peak: float64(p.Spike.Peak), At: durationOf(p.Spike.At), Length: durationOf(p.Spike.Length)}
+	}
+	if p.Sine != nil {
+		spec.Sine = &load.SineSpec{Amplitude: float64(p.Sine.Amplitude), Period: durationOf(p.Sine.Period)}
+	}
+	return spec
+}
+
+// ArrivalsConfig is the arrival process of a test's requests: uniform,
+// poisson or bursty. Bursty arrivals send the requests of every On plus Off
+// within On and pause for Off. Every process keeps the mean rate.
+type ArrivalsConfig struct {
+	Process string    `json:"process" yaml:"process"`
+	On      *Duration `json:"on,omitempty" yaml:"on"`
+	Off     *Duration `json:"off,omitempty" yaml:"off"`
+}
+
+// Spec returns the arrivals of the config.
+func (a ArrivalsConfig) Spec() load.Arrivals {
+	return load.Arrivals{Process: a.Process, On: durationOf(a.On), Off: durationOf(a.Off)}
+}
+
+// UsersConfig runs Count virtual users, each looping through the targets of
+// the test. Users wait ThinkTime after every response, and start iterations
+// through the targets at least Pacing apart.
+type UsersConfig struct {
+	Count     int       `json:"count" yaml:"count"`
+	ThinkTime *Duration `json:"thinkTime,omitempty" yaml:"thinkTime"`
+	Pacing    *Duration `json:"pacing,omitempty" yaml:"pacing"`
+}
+
+// Spec returns the users of the config.
+func (u UsersConfig) Spec() load.Users {
+	return load.Users{Count: u.Count, ThinkTime: durationOf(u.ThinkTime), Pacing: durationOf(u.Pacing)}
+}
+
+// durationOf returns the duration d holds, zero when unset.
+func durationOf(d *Duration) time.Duration {
+	if d == nil {
+		return 0
+	}
+	return d.Duration
+}
+
+// ScheduleConfig is the schedule of a test: a fixed interval (Every), a cron
+// expression or a one-shot time (At). Cron and At are read in TimeZone, the
+// local time zone by default.
+type ScheduleConfig struct {
+	Every    *Duration `json:"every,omitempty" yaml:"every"`
+	Cron     string    `json:"cron,omitempty" yaml:"cron"`
+	At       string    `json:"at,omitempty" yaml:"at"`
+	TimeZone string    `json:"timeZone,omitempty" yaml:"timeZone"`
+	// Jitter delays every run by a random duration of up to Jitter, so that
+	// tests sharing a schedule do not all start at once.
+	Jitter *Duration `json:"jitter,omitempty" yaml:"jitter"`
+}
+
+// Spec returns the schedule spec of the config.
+func (s ScheduleConfig) Spec() schedule.Spec {
+	spec := schedule.Spec{Cron: s.Cron, At: s.At, TimeZone: s.TimeZone}
+	if s.Every != nil {
+		spec.Every = s.Every.Duration
+		if spec.Every == 0 {
+			// an explicit zero interval is invalid rather than unset
+			spec.Every = -1
+		}
+	}
+	if s.Jitter != nil {
+		spec.Jitter = s.Jitter.Duration
+	}
+	return spec
+}
+
+// RetentionConfig is a retention rule. Runs older than Raw are rolled up into
+// one aggregate result per Resolution, and aggregates older than Aggregate are
+// deleted. Without Aggregate aggregates are kept forever; an Aggregate of zero
+// deletes old runs without rolling them up.
+type RetentionConfig struct {
+	Raw        *Duration `json:"raw" yaml:"raw"`
+	Aggregate  *Duration `json:"aggregate,omitempty" yaml:"aggregate"`
+	Resolution *Duration `json:"resolution,omitempty" yaml:"resolution"` // defaults to a day
+}
+
+// DefaultResolution is the width of an aggregate when a retention rule does
+// not set one.
+const DefaultResolution = 24 * time.Hour
+
+// Validate reports whether the retention rule can be applied.
+func (r RetentionConfig) Validate() error {
+	switch {
+	case r.Raw == nil || r.Raw.Duration <= 0:
+		return errors.New("raw must be greater than zero")
+	case r.Resolution != nil && r.Resolution.Duration <= 0:
+		return errors.New("resolution must be greater than zero")
+	case r.Aggregate != nil && r.Aggregate.Duration < 0:
+		return errors.New("aggregate must not be negative")
+	case r.Aggregate != nil && r.Aggregate.Duration > 0 && r.Aggregate.Duration <= r.Raw.Duration:
+		return errors.New("aggregate must be longer than raw")
+	}
+	return nil
+}
+
+// CompactorConfig configures the background compactor, which applies
+// retention rules to stored results.
+type CompactorConfig struct {
+	Interval *time.Duration `json:"interval" yaml:"interval"` // defaults to an hour
+	// Retention applies to tests without a retention rule of their own.
+	Retention *RetentionConfig `json:"retention" yaml:"retention"`
 }
 
 type DatabaseConfig struct {
 	Type         string `json:"type" yaml:"type"`
+	Path         string `json:"path" yaml:"path"`
 	Host         string `json:"host" yaml:"host"`
 	Port         int    `json:"port" yaml:"port"`
 	User         string `json:"user" yaml:"user"`
 	Password     string `json:"password" yaml:"password"`
 	DatabaseName string `json:"databaseName" yaml:"databaseName"`
-	SslMode      string `json:"sslMode" yaml:"sslMode"`
-	SslFactory   string `json:"sslFactory" yaml:"sslFactory"`
+	// SslMode is the libpq sslmode: disable, allow, prefer, require,
+	// verify-ca or verify-full. SslRootCert is the CA bundle server
+	// certificates are verified against, and SslCert and SslKey the client
+	// certificate presented to the server.
+	SslMode     string `json:"sslMode" yaml:"sslMode"`
+	SslRootCert string `json:"sslRootCert" yaml:"sslRootCert"`
+	SslCert     string `json:"sslCert" yaml:"sslCert"`
+	SslKey      string `json:"sslKey" yaml:"sslKey"`
+	// SslFactory is the jdbc ssl factory, kept for configs shared with jdbc
+	// clients. It is not used.
+	SslFactory string `json:"sslFactory" yaml:"sslFactory"`
+
+	// Pool settings of the postgres backend. Zero values leave the pool
+	// defaults in place.
+	MaxConns        int32          `json:"maxConns" yaml:"maxConns"`
+	MinConns        int32          `json:"minConns" yaml:"minConns"`
+	MaxConnLifetime *time.Duration `json:"maxConnLifetime" yaml:"maxConnLifetime"`
+	MaxConnIdleTime *time.Duration `json:"maxConnIdleTime" yaml:"maxConnIdleTime"`
+	// ConnectTimeout bounds establishing a connection, and QueryTimeout each
+	// statement.
+	ConnectTimeout *time.Duration `json:"connectTimeout" yaml:"connectTimeout"`
+	QueryTimeout   *time.Duration `json:"queryTimeout" yaml:"queryTimeout"`
+}
+
+// ArtifactsConfig enables keeping the raw results of every run, gzip
+// compressed in a vegeta encoding, so that past runs can be re-analysed.
+type ArtifactsConfig struct {
+	Store  string `json:"store" yaml:"store"`   // database or disk, defaults to database
+	Path   string `json:"path" yaml:"path"`     // directory of the disk store
+	Format string `json:"format" yaml:"format"` // gob or csv, defaults to gob
+}
+
+// SpoolConfig enables queueing results on local disk while storage is
+// unavailable. Queued results are replayed once storage is healthy again.
+type SpoolConfig struct {
+	Path     string         `json:"path" yaml:"path"`         // directory of the queue
+	Interval *time.Duration `json:"interval" yaml:"interval"` // how often storage health is checked, defaults to 10s
+}
+
+// InfluxConfig enables exporting run results to an InfluxDB v2 write
+// endpoint. Results are exported alongside storage, not instead of it.
+type InfluxConfig struct {
+	Url           string            `json:"url" yaml:"url"`
+	Org           string            `json:"org" yaml:"org"`
+	Bucket        string            `json:"bucket" yaml:"bucket"`
+	Token         string            `json:"token" yaml:"token"`
+	Measurement   string            `json:"measurement" yaml:"measurement"`
+	Tags          map[string]string `json:"tags" yaml:"tags"`
+	BatchSize     int               `json:"batchSize" yaml:"batchSize"`
+	FlushInterval *time.Duration    `json:"flushInterval" yaml:"flushInterval"`
+	MaxRetries    int               `json:"maxRetries" yaml:"maxRetries"` // negative disables retries
+	RetryInterval *time.Duration    `json:"retryInterval" yaml:"retryInterval"`
+	Timeout       *time.Duration    `json:"timeout" yaml:"timeout"`
 }
 
 type CacheConfig struct {
@@ -35,12 +400,44 @@ type CacheConfig struct {
 
 type TimerConfig struct {
 	Interval *time.Duration `json:"interval" yaml:"interval"`
+	// StaleAfter is how long the timer may go without progress before it is
+	// reported as stuck. Defaults to three intervals.
+	StaleAfter *time.Duration `json:"staleAfter" yaml:"staleAfter"`
+	// Workers is how many scheduled runs may execute at once. Runs due while
+	// every worker is busy wait for one to free up. Defaults to one, so that
+	// tests do not skew each other's measurements.
+	Workers int `json:"workers" yaml:"workers"`
+	// Queue is how many scheduled runs may wait for a worker. Runs due while
+	// the queue is full are skipped. Defaults to 16.
+	Queue int `json:"queue" yaml:"queue"`
+}
+
+// DefaultPort is the port the api server listens on when none is configured.
+const DefaultPort = 8080
+
+type ServerConfig struct {
+	Host string `json:"host" yaml:"host"`
+	Port int    `json:"port" yaml:"port"`
+	// MaxRuns is how many on-demand runs may be in progress at once. Further
+	// runs are rejected until one finishes. Defaults to four.
+	MaxRuns int `json:"maxRuns" yaml:"maxRuns"`
 }
 
 type LoggingConfig struct {
 	Level string `json:"level" yaml:"level"`
 }
 
+// ServerDefaults fills in the api server settings of configs that leave them
+// out, as configs written before the server was configurable do.
+func (c *Config) ServerDefaults() {
+	if c.Server == nil {
+		c.Server = &ServerConfig{}
+	}
+	if c.Server.Port == 0 {
+		c.Server.Port = DefaultPort
+	}
+}
+
 // SaneDefaults provides base config for testing
 func SaneDefaults() *Config {
 	startupSleep := time.Second * 1
@@ -58,6 +455,9 @@ func SaneDefaults() *Config {
 		Logging: &LoggingConfig{
 			Level: "debug",
 		},
+		Server: &ServerConfig{
+			Port: DefaultPort,
+		},
 		Timer: &TimerConfig{
 			Interval: &backgroundInterval,
 		},
@@ -25,6 +25,9 @@ func TestSaneDefaults(t *testing.T) {
 				Logging: &LoggingConfig{
 					Level: "debug",
 				},
+				Server: &ServerConfig{
+					Port: 8080,
+				},
 				Timer: &TimerConfig{
 					Interval: func() *time.Duration {
 						t := time.Duration(time.Second * 60)
@@ -44,3 +47,213 @@ func TestSaneDefaults(t *testing.T) {
 		})
 	}
 }
+
+func TestConfig_ServerDefaults(t *testing.T) {
+	tests := map[string]struct {
+		server *ServerConfig
+		want   *ServerConfig
+	}{
+		"no server block": {server: nil, want: &ServerConfig{Port: DefaultPort}},
+		"no port":         {server: &ServerConfig{Host: "0.0.0.0", MaxRuns: 2}, want: &ServerConfig{Host: "0.0.0.0", Port: DefaultPort, MaxRuns: 2}},
+		"port":            {server: &ServerConfig{Port: 9090}, want: &ServerConfig{Port: 9090}},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			c := Config{Server: test.server}
+			c.ServerDefaults()
+			assert.Equal(t, test.want, c.Server)
+		})
+	}
+}
+
+func TestRetentionConfig_Validate(t *testing.T) {
+	day := 24 * time.Hour
+	tests := map[string]struct {
+		rule    RetentionConfig
+		wantErr bool
+	}{
+		"raw only":         {rule: RetentionConfig{Raw: NewDuration(14 * day)}},
+		"with aggregates":  {rule: RetentionConfig{Raw: NewDuration(14 * day), Aggregate: NewDuration(365 * day), Resolution: NewDuration(day)}},
+		"no aggregates":    {rule: RetentionConfig{Raw: NewDuration(14 * day), Aggregate: NewDuration(0)}},
+		"no raw":           {rule: RetentionConfig{Aggregate: NewDuration(day)}, wantErr: true},
+		"zero resolution":  {rule: RetentionConfig{Raw: NewDuration(day), Resolution: NewDuration(0)}, wantErr: true},
+		"short aggregates": {rule: RetentionConfig{Raw: NewDuration(14 * day), Aggregate: NewDuration(day)}, wantErr: true},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			err := test.rule.Validate()
+			if test.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+		})
+	}
+}
+
+func TestTestConfig_ValidateSchedule(t *testing.T) {
+	tests := map[string]struct {
+		schedule ScheduleConfig
+		wantErr  bool
+	}{
+		"every":         {schedule: ScheduleConfig{Every: NewDuration(time.Minute), Jitter: NewDuration(time.Second)}},
+		"cron":          {schedule: ScheduleConfig{Cron: "0 2 * * *", TimeZone: "Europe/Berlin"}},
+		"at":            {schedule: ScheduleConfig{At: "2021-09-01T02:00:00Z"}},
+		"zero every":    {schedule: ScheduleConfig{Every: NewDuration(0)}, wantErr: true},
+		"empty":         {schedule: ScheduleConfig{}, wantErr: true},
+		"bad time zone": {schedule: ScheduleConfig{Cron: "@daily", TimeZone: "Nowhere"}, wantErr: true},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			schedule := test.schedule
+			err := TestConfig{Name: "test", Duration: NewDuration(time.Second), TPS: 1, Target: "t", Schedule: &schedule}.Validate()
+			if test.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+		})
+	}
+}
+
+func TestTestConfig_ValidateOverlap(t *testing.T) {
+	tests := map[string]struct {
+		overlap string
+		wantErr bool
+	}{
+		"default":         {},
+		"skip":            {overlap: OverlapSkip},
+		"queue":           {overlap: OverlapQueue},
+		"cancel previous": {overlap: OverlapCancelPrevious},
+		"unknown":         {overlap: "wait", wantErr: true},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			err := TestConfig{Name: "test", Duration: NewDuration(time.Second), TPS: 1, Target: "t", Overlap: test.overlap}.Validate()
+			if test.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+		})
+	}
+}
+
+func TestTestConfig_ValidateProfile(t *testing.T) {
+	tests := map[string]struct {
+		tps     int
+		profile ProfileConfig
+		wantErr bool
+	}{
+		"ramp without tps":  {profile: ProfileConfig{Ramp: &RampConfig{From: 10, To: 100}}},
+		"steps":             {profile: ProfileConfig{Steps: []StepConfig{{TPS: 10, Hold: NewDuration(time.Second)}, {TPS: 20, Hold: NewDuration(time.Second)}}}},
+		"spike":             {tps: 10, profile: ProfileConfig{Spike: &SpikeConfig{Peak: 100, At: NewDuration(0), Length: NewDuration(time.Second)}}},
+		"sine":              {tps: 10, profile: ProfileConfig{Sine: &SineConfig{Amplitude: 5, Period: NewDuration(time.Second)}}},
+		"step without hold": {profile: ProfileConfig{Steps: []StepConfig{{TPS: 10}}}, wantErr: true},
+		"sine without tps":  {profile: ProfileConfig{Sine: &SineConfig{Amplitude: 5, Period: NewDuration(time.Second)}}, wantErr: true},
+		"empty":             {tps: 10, wantErr: true},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			profile := test.profile
+			err := TestConfig{Name: "test", Duration: NewDuration(time.Minute), TPS: test.tps, Target: "t", Profile: &profile}.Validate()
+			if test.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+		})
+	}
+}
+
+func TestTestConfig_ValidateArrivals(t *testing.T) {
+	tests := map[string]struct {
+		arrivals ArrivalsConfig
+		wantErr  bool
+	}{
+		"poisson":         {arrivals: ArrivalsConfig{Process: "poisson"}},
+		"bursty":          {arrivals: ArrivalsConfig{Process: "bursty", On: NewDuration(time.Second), Off: NewDuration(4 * time.Second)}},
+		"bursty without":  {arrivals: ArrivalsConfig{Process: "bursty"}, wantErr: true},
+		"unknown process": {arrivals: ArrivalsConfig{Process: "gamma"}, wantErr: true},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			arrivals := test.arrivals
+			err := TestConfig{Name: "test", Duration: NewDuration(time.Minute), TPS: 1, Target: "t", Arrivals: &arrivals}.Validate()
+			if test.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+		})
+	}
+}
+
+func TestTestConfig_ValidateUsers(t *testing.T) {
+	tests := map[string]struct {
+		test    TestConfig
+		wantErr bool
+	}{
+		"users without tps":  {test: TestConfig{Users: &UsersConfig{Count: 10, ThinkTime: NewDuration(time.Second)}}},
+		"no users":           {test: TestConfig{Users: &UsersConfig{}}, wantErr: true},
+		"users and profile":  {test: TestConfig{Users: &UsersConfig{Count: 10}, Profile: &ProfileConfig{Ramp: &RampConfig{To: 10}}}, wantErr: true},
+		"users and arrivals": {test: TestConfig{Users: &UsersConfig{Count: 10}, Arrivals: &ArrivalsConfig{Process: "poisson"}}, wantErr: true},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			config := test.test
+			config.Name, config.Duration, config.Target = "test", NewDuration(time.Minute), "t"
+			err := config.Validate()
+			if test.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+		})
+	}
+}
+
+func TestTestConfig_ValidateTargets(t *testing.T) {
+	tests := map[string]struct {
+		test    TestConfig
+		wantErr bool
+	}{
+		"target file":        {test: TestConfig{Target: "t"}},
+		"inline":             {test: TestConfig{Targets: []TargetConfig{{Method: "POST", URL: "http://localhost/", Headers: map[string]string{"Content-Type": "application/json"}, BodyFile: "body.json"}}}},
+		"no targets":         {test: TestConfig{}, wantErr: true},
+		"both":               {test: TestConfig{Target: "t", Targets: []TargetConfig{{URL: "http://localhost/"}}}, wantErr: true},
+		"no url":             {test: TestConfig{Targets: []TargetConfig{{Method: "GET"}}}, wantErr: true},
+		"invalid url":        {test: TestConfig{Targets: []TargetConfig{{URL: "localhost"}}}, wantErr: true},
+		"two bodies":         {test: TestConfig{Targets: []TargetConfig{{URL: "http://localhost/", Body: "{}", BodyFile: "body.json"}}}, wantErr: true},
+		"json format":        {test: TestConfig{Target: "t", Format: FormatJSON}},
+		"auto format":        {test: TestConfig{Target: "t", Format: FormatAuto}},
+		"bad format":         {test: TestConfig{Target: "t", Format: "yaml"}, wantErr: true},
+		"inline with format": {test: TestConfig{Targets: []TargetConfig{{URL: "http://localhost/"}}, Format: FormatJSON}, wantErr: true},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			config := test.test
+			config.Name, config.Duration, config.TPS = "test", NewDuration(time.Minute), 1
+			err := config.Validate()
+			if test.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+		})
+	}
+}
+
+func TestConfig_ResolvePaths(t *testing.T) {
+	config := Config{Tests: []TestConfig{{Targets: []TargetConfig{
+		{URL: "http://localhost/"},
+		{URL: "http://localhost/", BodyFile: "bodies/order.json"},
+		{URL: "http://localhost/", BodyFile: "/srv/order.json"},
+	}}}}
+	config.ResolvePaths("/etc/toadlester")
+
+	targets := config.Tests[0].Targets
+	assert.Empty(t, targets[0].BodyFile)
+	assert.Equal(t, "/etc/toadlester/bodies/order.json", targets[1].BodyFile)
+	assert.Equal(t, "/srv/order.json", targets[2].BodyFile)
+}
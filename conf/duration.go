@@ -0,0 +1,88 @@
+package conf
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mitchellh/mapstructure"
+)
+
+// Duration is a time.Duration that is read from and written to json in its
+// string form, e.g. "10s".
+type Duration struct {
+	time.Duration
+}
+
+// NewDuration returns a pointer to a Duration of d.
+func NewDuration(d time.Duration) *Duration {
+	return &Duration{d}
+}
+
+// ParseDuration parses a duration like time.ParseDuration, but also accepts a
+// leading number of days, e.g. "14d" or "1d12h".
+func ParseDuration(s string) (time.Duration, error) {
+	if i := strings.IndexByte(s, 'd'); i > 0 {
+		if days, err := strconv.Atoi(s[:i]); err == nil && days >= 0 {
+			var rest time.Duration
+			if s[i+1:] != "" {
+				if rest, err = time.ParseDuration(s[i+1:]); err != nil {
+					return 0, err
+				}
+			}
+			return time.Duration(days)*24*time.Hour + rest, nil
+		}
+	}
+	return time.ParseDuration(s)
+}
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(d.String())
+}
+
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var value interface{}
+	if err := json.Unmarshal(b, &value); err != nil {
+		return err
+	}
+	switch v := value.(type) {
+	case string:
+		parsed, err := ParseDuration(v)
+		if err != nil {
+			return err
+		}
+		d.Duration = parsed
+	case float64:
+		d.Duration = time.Duration(v)
+	default:
+		return fmt.Errorf("invalid duration: %s", b)
+	}
+	return nil
+}
+
+// DecodeHook returns the mapstructure hook used to decode config files. It
+// extends viper's default hooks with support for Duration fields.
+func DecodeHook() mapstructure.DecodeHookFunc {
+	return mapstructure.ComposeDecodeHookFunc(
+		stringToDurationHookFunc(),
+		mapstructure.StringToTimeDurationHookFunc(),
+		mapstructure.StringToSliceHookFunc(","),
+	)
+}
+
+// stringToDurationHookFunc converts strings to Duration.
+func stringToDurationHookFunc() mapstructure.DecodeHookFuncType {
+	return func(f reflect.Type, t reflect.Type, data interface{}) (interface{}, error) {
+		if f.Kind() != reflect.String || t != reflect.TypeOf(Duration{}) {
+			return data, nil
+		}
+		d, err := ParseDuration(data.(string))
+		if err != nil {
+			return nil, err
+		}
+		return Duration{d}, nil
+	}
+}
@@ -0,0 +1,66 @@
+package conf
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/mitchellh/mapstructure"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDuration_UnmarshalJSON(t *testing.T) {
+	tests := map[string]struct {
+		input   string
+		want    time.Duration
+		wantErr bool
+	}{
+		"string":      {input: `"10s"`, want: 10 * time.Second},
+		"days":        {input: `"14d"`, want: 14 * 24 * time.Hour},
+		"days hours":  {input: `"1d12h"`, want: 36 * time.Hour},
+		"nanoseconds": {input: `1000`, want: time.Microsecond},
+		"invalid":     {input: `"ten"`, wantErr: true},
+		"bad days":    {input: `"1dx"`, wantErr: true},
+		"wrong type":  {input: `true`, wantErr: true},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			var d Duration
+			err := json.Unmarshal([]byte(test.input), &d)
+			if test.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, test.want, d.Duration)
+
+			out, err := json.Marshal(d)
+			assert.NoError(t, err)
+			assert.Equal(t, `"`+test.want.String()+`"`, string(out))
+		})
+	}
+}
+
+func TestDecodeHook(t *testing.T) {
+	var test TestConfig
+	decoder, err := mapstructure.NewDecoder(&mapstructure.DecoderConfig{
+		DecodeHook: DecodeHook(),
+		Result:     &test,
+	})
+	assert.NoError(t, err)
+
+	err = decoder.Decode(map[string]interface{}{
+		"name":     "test1",
+		"duration": "10s",
+		"tps":      100,
+		"target":   "./testing/target.txt",
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, TestConfig{
+		Name:     "test1",
+		Duration: NewDuration(10 * time.Second),
+		TPS:      100,
+		Target:   "./testing/target.txt",
+	}, test)
+	assert.NoError(t, test.Validate())
+}
@@ -0,0 +1,127 @@
+package influx
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	vegeta "github.com/tsenart/vegeta/lib"
+)
+
+// Point is a single InfluxDB data point.
+type Point struct {
+	Measurement string
+	Tags        map[string]string
+	Fields      map[string]interface{}
+	Time        time.Time
+}
+
+var (
+	measurementEscaper = strings.NewReplacer(",", `\,`, " ", `\ `)
+	keyEscaper         = strings.NewReplacer(",", `\,`, "=", `\=`, " ", `\ `)
+	stringEscaper      = strings.NewReplacer(`\`, `\\`, `"`, `\"`)
+)
+
+// LineProtocol encodes the point in the InfluxDB line protocol with
+// nanosecond precision. Tags and fields are sorted by key. Fields that cannot
+// be represented, such as NaN floats, are left out.
+func (p Point) LineProtocol() string {
+	var b strings.Builder
+	b.WriteString(measurementEscaper.Replace(p.Measurement))
+
+	for _, k := range sortedKeys(p.Tags) {
+		if p.Tags[k] == "" {
+			continue
+		}
+		fmt.Fprintf(&b, ",%s=%s", keyEscaper.Replace(k), keyEscaper.Replace(p.Tags[k]))
+	}
+
+	fields := make([]string, 0, len(p.Fields))
+	for k, v := range p.Fields {
+		if value, ok := fieldValue(v); ok {
+			fields = append(fields, keyEscaper.Replace(k)+"="+value)
+		}
+	}
+	sort.Strings(fields)
+	b.WriteString(" ")
+	b.WriteString(strings.Join(fields, ","))
+
+	fmt.Fprintf(&b, " %d", p.Time.UnixNano())
+	return b.String()
+}
+
+func fieldValue(v interface{}) (string, bool) {
+	switch value := v.(type) {
+	case float64:
+		if math.IsNaN(value) || math.IsInf(value, 0) {
+			return "", false
+		}
+		return strconv.FormatFloat(value, 'f', -1, 64), true
+	case int:
+		return strconv.Itoa(value) + "i", true
+	case int64:
+		return strconv.FormatInt(value, 10) + "i", true
+	case uint64:
+		return strconv.FormatUint(value, 10) + "i", true
+	case time.Duration:
+		return strconv.FormatInt(int64(value), 10) + "i", true
+	case bool:
+		return strconv.FormatBool(value), true
+	case string:
+		return `"` + stringEscaper.Replace(value) + `"`, true
+	}
+	return "", false
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// MetricsPoint summarizes the metrics of a test run as a point tagged with
+// the test name and the given tags, which cannot override the test tag.
+// Latencies are written in nanoseconds and every status code gets its own
+// status_<code> field.
+func MetricsPoint(measurement, test string, tags map[string]string, m *vegeta.Metrics) Point {
+	pointTags := make(map[string]string, len(tags)+1)
+	for k, v := range tags {
+		pointTags[k] = v
+	}
+	pointTags["test"] = test
+
+	var failures uint64
+	if m.Requests > 0 {
+		failures = m.Requests - uint64(math.Round(m.Success*float64(m.Requests)))
+	}
+	fields := map[string]interface{}{
+		"latency_mean": m.Latencies.Mean,
+		"latency_p50":  m.Latencies.P50,
+		"latency_p95":  m.Latencies.P95,
+		"latency_p99":  m.Latencies.P99,
+		"latency_max":  m.Latencies.Max,
+		"requests":     m.Requests,
+		"rate":         m.Rate,
+		"success":      m.Success,
+		"bytes_in":     m.BytesIn.Total,
+		"bytes_out":    m.BytesOut.Total,
+		"duration":     m.Duration,
+		"failures":     failures,
+		"error_types":  len(m.Errors),
+	}
+	for code, count := range m.StatusCodes {
+		fields["status_"+code] = count
+	}
+
+	end := m.End
+	if end.IsZero() {
+		end = time.Now()
+	}
+	return Point{Measurement: measurement, Tags: pointTags, Fields: fields, Time: end}
+}
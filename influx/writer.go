@@ -0,0 +1,216 @@
+package influx
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/javking07/toadlester/conf"
+	"github.com/rs/zerolog"
+	vegeta "github.com/tsenart/vegeta/lib"
+)
+
+const (
+	defaultMeasurement   = "toadlester"
+	defaultBatchSize     = 100
+	defaultFlushInterval = 10 * time.Second
+	defaultMaxRetries    = 3
+	defaultRetryInterval = time.Second
+	defaultTimeout       = 10 * time.Second
+)
+
+// Writer batches points and writes them to the InfluxDB v2 write api. Points
+// are flushed once a batch is full or the flush interval passes; failed
+// writes are retried with exponential backoff before the batch is dropped.
+type Writer struct {
+	config        conf.InfluxConfig
+	writeURL      string
+	client        *http.Client
+	logger        *zerolog.Logger
+	flushInterval time.Duration
+	retryInterval time.Duration
+
+	// mu guards sends on points against Close closing it.
+	mu     sync.RWMutex
+	closed bool
+	points chan Point
+	done   chan struct{}
+}
+
+// NewWriter returns a writer for the given config and starts its background
+// flush loop. Close must be called to flush pending points.
+func NewWriter(c conf.InfluxConfig, logger *zerolog.Logger) (*Writer, error) {
+	if c.Url == "" || c.Bucket == "" {
+		return nil, fmt.Errorf("influx url and bucket are required")
+	}
+	base, err := url.Parse(c.Url)
+	if err != nil {
+		return nil, fmt.Errorf("invalid influx url: %v", err)
+	}
+	base.Path = strings.TrimSuffix(base.Path, "/") + "/api/v2/write"
+	query := url.Values{}
+	query.Set("bucket", c.Bucket)
+	query.Set("precision", "ns")
+	if c.Org != "" {
+		query.Set("org", c.Org)
+	}
+	base.RawQuery = query.Encode()
+
+	if c.Measurement == "" {
+		c.Measurement = defaultMeasurement
+	}
+	if c.BatchSize <= 0 {
+		c.BatchSize = defaultBatchSize
+	}
+	if c.MaxRetries < 0 {
+		c.MaxRetries = 0
+	} else if c.MaxRetries == 0 {
+		c.MaxRetries = defaultMaxRetries
+	}
+
+	w := &Writer{
+		config:        c,
+		writeURL:      base.String(),
+		client:        &http.Client{Timeout: durationOr(c.Timeout, defaultTimeout)},
+		logger:        logger,
+		flushInterval: durationOr(c.FlushInterval, defaultFlushInterval),
+		retryInterval: durationOr(c.RetryInterval, defaultRetryInterval),
+		points:        make(chan Point, c.BatchSize*10),
+		done:          make(chan struct{}),
+	}
+	go w.run()
+	return w, nil
+}
+
+// WriteMetrics queues the summary of a test run, tagged with the test name
+// and the configured tags.
+func (w *Writer) WriteMetrics(test string, m *vegeta.Metrics) {
+	w.Write(MetricsPoint(w.config.Measurement, test, w.config.Tags, m))
+}
+
+// Write queues a point for the next batch. The point is dropped when the
+// queue is full so that slow writes never hold up test runs, and once the
+// writer is closed.
+func (w *Writer) Write(p Point) {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+
+	if w.closed {
+		w.logger.Warn().Msgf("influx writer is closed, dropping point for %s", p.Tags["test"])
+		return
+	}
+	select {
+	case w.points <- p:
+	default:
+		w.logger.Warn().Msgf("influx write queue is full, dropping point for %s", p.Tags["test"])
+	}
+}
+
+// Close flushes pending points and stops the writer.
+func (w *Writer) Close() {
+	w.mu.Lock()
+	if !w.closed {
+		w.closed = true
+		close(w.points)
+	}
+	w.mu.Unlock()
+	<-w.done
+}
+
+func (w *Writer) run() {
+	defer close(w.done)
+
+	ticker := time.NewTicker(w.flushInterval)
+	defer ticker.Stop()
+
+	batch := make([]Point, 0, w.config.BatchSize)
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+		if err := w.send(batch); err != nil {
+			w.logger.Error().Msgf("error writing %d points to influx: %v", len(batch), err)
+		}
+		batch = batch[:0]
+	}
+
+	for {
+		select {
+		case p, ok := <-w.points:
+			if !ok {
+				flush()
+				return
+			}
+			if batch = append(batch, p); len(batch) >= w.config.BatchSize {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+		}
+	}
+}
+
+// send writes a batch, retrying server errors and throttled requests.
+func (w *Writer) send(batch []Point) error {
+	lines := make([]string, len(batch))
+	for i, p := range batch {
+		lines[i] = p.LineProtocol()
+	}
+	body := []byte(strings.Join(lines, "\n"))
+
+	var err error
+	backoff := w.retryInterval
+	for attempt := 0; attempt <= w.config.MaxRetries; attempt++ {
+		if attempt > 0 {
+			w.logger.Warn().Msgf("retrying influx write in %s: %v", backoff, err)
+			time.Sleep(backoff)
+			backoff *= 2
+		}
+
+		var retry bool
+		if retry, err = w.post(body); err == nil || !retry {
+			return err
+		}
+	}
+	return err
+}
+
+// post sends a single write request and reports whether a failure should be
+// retried.
+func (w *Writer) post(body []byte) (bool, error) {
+	req, err := http.NewRequest(http.MethodPost, w.writeURL, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+	if w.config.Token != "" {
+		req.Header.Set("Authorization", "Token "+w.config.Token)
+	}
+
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		_, _ = io.Copy(ioutil.Discard, resp.Body)
+		return false, nil
+	}
+	msg, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+	err = fmt.Errorf("influx responded with %s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	return resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500, err
+}
+
+func durationOr(d *time.Duration, def time.Duration) time.Duration {
+	if d == nil || *d <= 0 {
+		return def
+	}
+	return *d
+}
@@ -0,0 +1,136 @@
+package influx
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/javking07/toadlester/conf"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	vegeta "github.com/tsenart/vegeta/lib"
+)
+
+func TestPoint_LineProtocol(t *testing.T) {
+	tests := map[string]struct {
+		point Point
+		want  string
+	}{
+		"fields": {
+			point: Point{
+				Measurement: "toadlester",
+				Tags:        map[string]string{"test": "test1", "env": "dev"},
+				Fields: map[string]interface{}{
+					"requests": uint64(10),
+					"success":  0.5,
+					"latency":  time.Millisecond,
+					"ok":       true,
+					"message":  `say "hi"`,
+				},
+				Time: time.Unix(1, 0),
+			},
+			want: `toadlester,env=dev,test=test1 latency=1000000i,message="say \"hi\"",ok=true,requests=10i,success=0.5 1000000000`,
+		},
+		"escaping": {
+			point: Point{
+				Measurement: "load test",
+				Tags:        map[string]string{"test": "a,b=c d", "empty": ""},
+				Fields:      map[string]interface{}{"nan": nan(), "count": 1},
+				Time:        time.Unix(0, 5),
+			},
+			want: `load\ test,test=a\,b\=c\ d count=1i 5`,
+		},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			assert.Equal(t, test.want, test.point.LineProtocol())
+		})
+	}
+}
+
+func TestMetricsPoint(t *testing.T) {
+	m := &vegeta.Metrics{
+		Requests:    10,
+		Success:     0.8,
+		StatusCodes: map[string]int{"200": 8, "500": 2},
+		Errors:      []string{"500 Internal Server Error"},
+		End:         time.Unix(10, 0),
+	}
+	// a configured test tag does not hide the test name
+	p := MetricsPoint("toadlester", "test1", map[string]string{"env": "dev", "test": "other"}, m)
+
+	assert.Equal(t, map[string]string{"test": "test1", "env": "dev"}, p.Tags)
+	assert.Equal(t, uint64(2), p.Fields["failures"])
+	assert.Equal(t, 1, p.Fields["error_types"])
+	assert.Equal(t, 8, p.Fields["status_200"])
+	assert.Equal(t, time.Unix(10, 0), p.Time)
+}
+
+func TestWriter(t *testing.T) {
+	var mu sync.Mutex
+	var bodies []string
+	attempts := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		assert.Equal(t, "/api/v2/write", r.URL.Path)
+		assert.Equal(t, "bucket", r.URL.Query().Get("bucket"))
+		assert.Equal(t, "org", r.URL.Query().Get("org"))
+		assert.Equal(t, "Token secret", r.Header.Get("Authorization"))
+
+		// fail the first attempt to exercise retries
+		if attempts++; attempts == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		body, _ := ioutil.ReadAll(r.Body)
+		bodies = append(bodies, string(body))
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	retry := time.Millisecond
+	logger := zerolog.Nop()
+	w, err := NewWriter(conf.InfluxConfig{
+		Url:           server.URL,
+		Org:           "org",
+		Bucket:        "bucket",
+		Token:         "secret",
+		BatchSize:     2,
+		RetryInterval: &retry,
+		Tags:          map[string]string{"env": "dev"},
+	}, &logger)
+	assert.NoError(t, err)
+
+	for i := 0; i < 3; i++ {
+		w.WriteMetrics("test1", &vegeta.Metrics{Requests: 1, Success: 1})
+	}
+	w.Close()
+	// runs finishing during shutdown may still write
+	assert.NotPanics(t, func() { w.WriteMetrics("test1", &vegeta.Metrics{Requests: 1, Success: 1}) })
+	w.Close()
+
+	mu.Lock()
+	defer mu.Unlock()
+	assert.Equal(t, 3, attempts)
+	assert.Len(t, bodies, 2)
+	assert.Len(t, strings.Split(bodies[0], "\n"), 2)
+	assert.Len(t, strings.Split(bodies[1], "\n"), 1)
+	assert.True(t, strings.HasPrefix(bodies[0], "toadlester,env=dev,test=test1 "))
+}
+
+func TestNewWriter_Invalid(t *testing.T) {
+	logger := zerolog.Nop()
+	_, err := NewWriter(conf.InfluxConfig{Url: "http://localhost:8086"}, &logger)
+	assert.Error(t, err)
+}
+
+func nan() float64 {
+	var zero float64
+	return zero / zero
+}
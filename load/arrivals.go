@@ -0,0 +1,91 @@
+package load
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"time"
+)
+
+// Arrival processes.
+const (
+	ProcessUniform = "uniform" // evenly spaced requests
+	ProcessPoisson = "poisson" // exponentially distributed gaps between requests
+	ProcessBursty  = "bursty"  // evenly spaced bursts of requests, with pauses in between
+)
+
+// Arrivals is the process requests arrive by. Every process keeps the mean
+// rate of the profile it paces.
+type Arrivals struct {
+	Process string `json:"process"`
+	// On and Off are the lengths of the bursts of bursty arrivals and of the
+	// pauses between them.
+	On  time.Duration `json:"on,omitempty"`
+	Off time.Duration `json:"off,omitempty"`
+}
+
+// Validate reports whether the arrivals can be paced. An empty process is
+// uniform.
+func (a Arrivals) Validate() error {
+	switch a.Process {
+	case "", ProcessUniform, ProcessPoisson:
+		if a.On != 0 || a.Off != 0 {
+			return fmt.Errorf("on and off only apply to %s arrivals", ProcessBursty)
+		}
+	case ProcessBursty:
+		if a.On <= 0 || a.Off <= 0 {
+			return errors.New("on and off must be greater than zero")
+		}
+	default:
+		return fmt.Errorf("unknown arrival process %q, expected uniform, poisson or bursty", a.Process)
+	}
+	return nil
+}
+
+// Uniform reports whether requests arrive evenly spaced.
+func (a Arrivals) Uniform() bool {
+	return a.Process == "" || a.Process == ProcessUniform
+}
+
+// shape returns profile as the arrivals see it: bursty arrivals send the
+// requests of a whole cycle during its burst.
+func (a Arrivals) shape(profile Profile) Profile {
+	if a.Process != ProcessBursty {
+		return profile
+	}
+	return bursts{Profile: profile, on: a.On, off: a.Off}
+}
+
+// gaps returns the gaps between requests, in requests at the planned rate.
+func (a Arrivals) gaps(rnd *rand.Rand) func() float64 {
+	if a.Process == ProcessPoisson {
+		return rnd.ExpFloat64
+	}
+	return func() float64 { return 1 }
+}
+
+// bursts concentrates the rate of a profile into bursts.
+type bursts struct {
+	Profile
+	on, off time.Duration
+}
+
+func (b bursts) Rate(elapsed time.Duration) float64 {
+	if elapsed%(b.on+b.off) >= b.on {
+		return 0
+	}
+	return b.Profile.Rate(elapsed) * float64(b.on+b.off) / float64(b.on)
+}
+
+// Constant runs at TPS throughout. It breaks results down into no stages.
+type Constant struct {
+	TPS float64
+}
+
+func (c Constant) Rate(time.Duration) float64 {
+	return c.TPS
+}
+
+func (c Constant) Stages() []Stage {
+	return nil
+}
@@ -0,0 +1,74 @@
+package load
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestArrivals_Validate(t *testing.T) {
+	tests := map[string]struct {
+		arrivals Arrivals
+		wantErr  bool
+	}{
+		"default":          {},
+		"uniform":          {arrivals: Arrivals{Process: ProcessUniform}},
+		"poisson":          {arrivals: Arrivals{Process: ProcessPoisson}},
+		"bursty":           {arrivals: Arrivals{Process: ProcessBursty, On: time.Second, Off: time.Second}},
+		"bursty without":   {arrivals: Arrivals{Process: ProcessBursty, On: time.Second}, wantErr: true},
+		"on without burst": {arrivals: Arrivals{Process: ProcessPoisson, On: time.Second}, wantErr: true},
+		"unknown":          {arrivals: Arrivals{Process: "gamma"}, wantErr: true},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			err := test.arrivals.Validate()
+			if test.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+		})
+	}
+}
+
+// offsets returns when the hits of profile paced by arrivals are due.
+func offsets(profile Profile, arrivals Arrivals, duration time.Duration) []time.Duration {
+	p := newPacer(arrivals.shape(profile), duration)
+	gap := arrivals.gaps(rand.New(rand.NewSource(1)))
+	var offsets []time.Duration
+	for at, ok := p.next(gap()); ok; at, ok = p.next(gap()) {
+		offsets = append(offsets, at)
+	}
+	return offsets
+}
+
+func TestArrivals_Poisson(t *testing.T) {
+	got := offsets(Constant{TPS: 100}, Arrivals{Process: ProcessPoisson}, 100*time.Second)
+	assert.InDelta(t, 10000, len(got), 300, "keeps the mean rate")
+
+	// exponential gaps have a standard deviation as large as their mean
+	var sum, squares float64
+	for i := 1; i < len(got); i++ {
+		gap := (got[i] - got[i-1]).Seconds()
+		sum += gap
+		squares += gap * gap
+	}
+	n := float64(len(got) - 1)
+	mean := sum / n
+	assert.InDelta(t, 0.01, mean, 0.0005)
+	assert.InDelta(t, 1, math.Sqrt(squares/n-mean*mean)/mean, 0.05)
+}
+
+func TestArrivals_Bursty(t *testing.T) {
+	arrivals := Arrivals{Process: ProcessBursty, On: time.Second, Off: 3 * time.Second}
+	got := offsets(Constant{TPS: 10}, arrivals, 20*time.Second)
+	assert.InDelta(t, 200, len(got), 1, "keeps the mean rate")
+	for _, at := range got {
+		assert.True(t, at%(4*time.Second) <= time.Second, "%s is within a burst", at)
+	}
+	// a burst sends the requests of a whole cycle at four times the rate
+	assert.InDelta(t, float64(25*time.Millisecond), float64(got[1]-got[0]), float64(time.Millisecond))
+}
@@ -0,0 +1,132 @@
+package load
+
+import (
+	"context"
+	"math"
+	"math/rand"
+	"sync"
+	"time"
+
+	vegeta "github.com/tsenart/vegeta/lib"
+)
+
+// step is the resolution at which the planned rate is integrated.
+const step = time.Millisecond
+
+// pacer computes when the hits of a run are due: the n-th hit is due once
+// the integral of the planned rate reaches n, so that the number of hits
+// follows the profile however fast its rate changes.
+type pacer struct {
+	profile  Profile
+	duration time.Duration
+
+	at   time.Duration // how far into the run the integral is computed
+	hits float64       // the integral of the rate up to at
+	due  float64       // the integral at which the next hit is due
+
+	// the step the integral last advanced over, from start where the
+	// integral was startHits, to at
+	start     time.Duration
+	startHits float64
+}
+
+func newPacer(profile Profile, duration time.Duration) *pacer {
+	return &pacer{profile: profile, duration: duration}
+}
+
+// next returns when the hit gap hits after the previous one is due, or false
+// when it falls after the end of the run.
+func (p *pacer) next(gap float64) (time.Duration, bool) {
+	p.due += gap
+	for p.hits < p.due {
+		if p.at >= p.duration {
+			return 0, false
+		}
+		// steps end on multiples of step, where profiles change abruptly
+		dt := step - p.at%step
+		if p.at+dt > p.duration {
+			dt = p.duration - p.at
+		}
+		p.start, p.startHits = p.at, p.hits
+		// the midpoint rate integrates linear ramps exactly
+		p.hits += p.profile.Rate(p.at+dt/2) * dt.Seconds()
+		p.at += dt
+	}
+	// hits are placed within their step without moving the integral off the
+	// step boundaries, so that rounding errors do not add up from hit to hit
+	// and a hit due on a boundary lands on it
+	add := p.hits - p.startHits
+	if add <= 0 || p.due <= p.startHits {
+		return p.start, true
+	}
+	return p.start + time.Duration(math.Round(float64(p.at-p.start)*(p.due-p.startHits)/add)), true
+}
+
+// Planned returns the number of requests profile plans for a run of the
+// given duration.
+func Planned(profile Profile, duration time.Duration) uint64 {
+	p := newPacer(profile, duration)
+	var n uint64
+	for _, ok := p.next(1); ok; _, ok = p.next(1) {
+		n++
+	}
+	return n
+}
+
+// Attack hits the targets of tr at the rates of profile for duration, spaced
+// by arrivals, and sends the results on the returned channel as they arrive,
+// which is closed once every hit returned. The attack stops early when ctx is
+// done or tr fails, like a vegeta attack does, cancelling the hits in flight.
+//
+// Hits go through a hitter rather than a vegeta attack each: vegeta only
+// attacks at constant rates, and starting an attack for every hit delays the
+// hit on busy hosts, past the stage it was due in.
+func Attack(ctx context.Context, tr vegeta.Targeter, profile Profile, arrivals Arrivals, duration time.Duration, name string) <-chan *vegeta.Result {
+	h := newHitter(name)
+	failed := make(chan struct{})
+	var once sync.Once
+
+	results := make(chan *vegeta.Result)
+	go func() {
+		var hits sync.WaitGroup
+		defer close(results)
+		defer hits.Wait()
+
+		p := newPacer(arrivals.shape(profile), duration)
+		gap := arrivals.gaps(rand.New(rand.NewSource(time.Now().UnixNano())))
+		began := time.Now()
+		timer := time.NewTimer(0)
+		defer timer.Stop()
+		for {
+			at, ok := p.next(gap())
+			if !ok {
+				return
+			}
+			if !timer.Stop() {
+				select {
+				case <-timer.C:
+				default:
+				}
+			}
+			timer.Reset(time.Until(began.Add(at)))
+			select {
+			case <-timer.C:
+			case <-ctx.Done():
+				return
+			case <-failed:
+				return
+			}
+
+			hits.Add(1)
+			go func() {
+				defer hits.Done()
+				res, err := h.hit(ctx, tr)
+				if err != nil {
+					once.Do(func() { close(failed) })
+				}
+				results <- res
+			}()
+		}
+	}()
+	return results
+}
@@ -0,0 +1,114 @@
+package load
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	vegeta "github.com/tsenart/vegeta/lib"
+)
+
+func TestPacer(t *testing.T) {
+	steps := Steps{Steps: []Step{{TPS: 10, Hold: time.Second}, {TPS: 50, Hold: time.Second}}, Duration: 2 * time.Second}
+	var offsets []time.Duration
+	p := newPacer(steps, steps.Duration)
+	for at, ok := p.next(1); ok; at, ok = p.next(1) {
+		offsets = append(offsets, at)
+	}
+	require.InDelta(t, 60, len(offsets), 1)
+	assert.InDelta(t, float64(100*time.Millisecond), float64(offsets[0]), float64(time.Millisecond))
+	assert.InDelta(t, float64(1020*time.Millisecond), float64(offsets[10]), float64(time.Millisecond), "the second step runs at 50/s")
+	for i := 1; i < len(offsets); i++ {
+		assert.True(t, offsets[i] > offsets[i-1], "hits are in order")
+	}
+
+	ramp := Ramp{From: 0, To: 100, Duration: 10 * time.Second}
+	assert.InDelta(t, 500, Planned(ramp, ramp.Duration), 1, "the integral of the ramp")
+
+	// the integral of a ramp from 0 to 40/s reaches 5 half way through
+	p = newPacer(Ramp{From: 0, To: 40, Duration: time.Second}, time.Second)
+	for i := 0; i < 4; i++ {
+		p.next(1)
+	}
+	at, ok := p.next(1)
+	require.True(t, ok)
+	assert.Equal(t, 500*time.Millisecond, at, "rounding errors do not add up from hit to hit")
+}
+
+func TestAttack(t *testing.T) {
+	var hits int64
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(&hits, 1)
+	}))
+	defer server.Close()
+	tr := vegeta.NewStaticTargeter(vegeta.Target{Method: http.MethodGet, URL: server.URL})
+
+	profile := Steps{Steps: []Step{{TPS: 20, Hold: 250 * time.Millisecond}, {TPS: 80, Hold: 250 * time.Millisecond}}, Duration: 500 * time.Millisecond}
+	began := time.Now()
+	breakdown := NewBreakdown(profile.Stages(), began)
+	var count int
+	for res := range Attack(context.Background(), tr, profile, Arrivals{}, profile.Duration, "test") {
+		assert.Empty(t, res.Error)
+		breakdown.Add(res)
+		count++
+	}
+	assert.Equal(t, int(Planned(profile, profile.Duration)), count)
+	assert.Equal(t, int64(count), atomic.LoadInt64(&hits))
+
+	stages := breakdown.Close()
+	require.Len(t, stages, 2)
+	assert.InDelta(t, 5, stages[0].Metrics.Requests, 2)
+	assert.InDelta(t, 20, stages[1].Metrics.Requests, 2)
+	assert.Equal(t, 1.0, stages[1].Metrics.Success)
+}
+
+func TestAttack_Stops(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+	tr := vegeta.NewStaticTargeter(vegeta.Target{Method: http.MethodGet, URL: server.URL})
+	profile := Ramp{From: 10, To: 10, Duration: time.Hour}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for range Attack(ctx, tr, profile, Arrivals{}, profile.Duration, "test") {
+		}
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("attack did not stop with its context")
+	}
+
+	// hits in flight are cancelled too
+	blocking := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-time.After(5 * time.Second):
+		}
+	}))
+	defer blocking.Close()
+	slow := vegeta.NewStaticTargeter(vegeta.Target{Method: http.MethodGet, URL: blocking.URL})
+	ctx, cancel = context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	began := time.Now()
+	for res := range Attack(ctx, slow, profile, Arrivals{}, profile.Duration, "test") {
+		assert.NotEmpty(t, res.Error)
+	}
+	assert.Less(t, int64(time.Since(began)), int64(time.Second))
+
+	failing := func(*vegeta.Target) error { return vegeta.ErrNoTargets }
+	var results int
+	for res := range Attack(context.Background(), failing, profile, Arrivals{}, profile.Duration, "test") {
+		assert.Equal(t, vegeta.ErrNoTargets.Error(), res.Error)
+		results++
+	}
+	assert.Equal(t, 1, results, "the attack stops once the targeter fails")
+}
@@ -0,0 +1,54 @@
+package load
+
+import (
+	"time"
+
+	vegeta "github.com/tsenart/vegeta/lib"
+)
+
+// StageMetrics are the metrics of the results of a stage.
+type StageMetrics struct {
+	Stage
+	Metrics vegeta.Metrics `json:"metrics"`
+}
+
+// Breakdown sorts the results of a run that began at a given time into the
+// stages they were sent in.
+type Breakdown struct {
+	began  time.Time
+	stages []StageMetrics
+}
+
+// NewBreakdown returns an empty breakdown of a run that began at began.
+func NewBreakdown(stages []Stage, began time.Time) *Breakdown {
+	b := &Breakdown{began: began, stages: make([]StageMetrics, len(stages))}
+	for i, stage := range stages {
+		b.stages[i].Stage = stage
+	}
+	return b
+}
+
+// Add adds r to the metrics of the stage it was sent in. Results sent past
+// the last stage count towards it.
+func (b *Breakdown) Add(r *vegeta.Result) {
+	if len(b.stages) == 0 {
+		return
+	}
+	offset := r.Timestamp.Sub(b.began)
+	i := 0
+	for i < len(b.stages)-1 && offset >= b.stages[i].End {
+		i++
+	}
+	b.stages[i].Metrics.Add(r)
+}
+
+// Close computes the metrics of every stage and returns them. Stages without
+// results are left empty.
+func (b *Breakdown) Close() []StageMetrics {
+	for i := range b.stages {
+		if b.stages[i].Metrics.Requests > 0 {
+			b.stages[i].Metrics.Close()
+		}
+	}
+	return b.stages
+}
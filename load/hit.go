@@ -0,0 +1,99 @@
+package load
+
+import (
+	"context"
+	"crypto/tls"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	vegeta "github.com/tsenart/vegeta/lib"
+)
+
+// hitter sends single requests and reports them as vegeta results. vegeta
+// only attacks at constant rates, and starting an attack for every request
+// spins up workers until one is scheduled, which delays requests on busy
+// hosts. The client matches the defaults of a vegeta attacker.
+type hitter struct {
+	name   string
+	client *http.Client
+
+	mu  sync.Mutex
+	seq uint64
+}
+
+func newHitter(name string) *hitter {
+	dialer := &net.Dialer{
+		LocalAddr: &net.TCPAddr{IP: vegeta.DefaultLocalAddr.IP, Zone: vegeta.DefaultLocalAddr.Zone},
+		KeepAlive: 30 * time.Second,
+		Timeout:   vegeta.DefaultTimeout,
+	}
+	return &hitter{
+		name: name,
+		client: &http.Client{
+			Timeout: vegeta.DefaultTimeout,
+			Transport: &http.Transport{
+				Proxy:                 http.ProxyFromEnvironment,
+				DialContext:           dialer.DialContext,
+				ResponseHeaderTimeout: vegeta.DefaultTimeout,
+				TLSClientConfig:       &tls.Config{InsecureSkipVerify: true},
+				TLSHandshakeTimeout:   10 * time.Second,
+				MaxIdleConnsPerHost:   vegeta.DefaultConnections,
+			},
+		},
+	}
+}
+
+// hit sends the next target of tr and returns its result. Errors of tr are
+// returned as well as recorded in the result. The request is cancelled when
+// ctx is done.
+func (h *hitter) hit(ctx context.Context, tr vegeta.Targeter) (*vegeta.Result, error) {
+	res := vegeta.Result{Attack: h.name}
+	h.mu.Lock()
+	res.Timestamp = time.Now()
+	res.Seq = h.seq
+	h.seq++
+	h.mu.Unlock()
+	// failed requests take time too
+	defer func() { res.Latency = time.Since(res.Timestamp) }()
+
+	var tgt vegeta.Target
+	if err := tr(&tgt); err != nil {
+		res.Error = err.Error()
+		return &res, err
+	}
+	res.Error = h.do(ctx, &tgt, &res)
+	return &res, nil
+}
+
+// do sends tgt and fills in res, returning the error of the request if any.
+func (h *hitter) do(ctx context.Context, tgt *vegeta.Target, res *vegeta.Result) string {
+	req, err := tgt.Request()
+	if err != nil {
+		return err.Error()
+	}
+	r, err := h.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return err.Error()
+	}
+	defer r.Body.Close()
+
+	if res.Body, err = ioutil.ReadAll(r.Body); err != nil {
+		return err.Error()
+	}
+	if _, err = io.Copy(ioutil.Discard, r.Body); err != nil {
+		return err.Error()
+	}
+
+	res.BytesIn = uint64(len(res.Body))
+	if req.ContentLength != -1 {
+		res.BytesOut = uint64(req.ContentLength)
+	}
+	if res.Code = uint16(r.StatusCode); res.Code < 200 || res.Code >= 400 {
+		return r.Status
+	}
+	return ""
+}
@@ -0,0 +1,263 @@
+// Package load drives attacks whose request rate changes over the course of
+// a run: linear ramps, staircase steps, spikes and sine waves.
+package load
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"time"
+)
+
+// DefaultSegments is the number of stages a ramp is broken down into by
+// default.
+const DefaultSegments = 10
+
+// Profile is the planned request rate of a run over time.
+type Profile interface {
+	// Rate returns the planned requests per second at elapsed into the run.
+	Rate(elapsed time.Duration) float64
+	// Stages returns the consecutive parts of the run that results are
+	// broken down by.
+	Stages() []Stage
+}
+
+// Stage is a part of a run. From and To are the planned rates at its start
+// and end.
+type Stage struct {
+	Name  string        `json:"name"`
+	Start time.Duration `json:"start"` // offset from the start of the run
+	End   time.Duration `json:"end"`
+	From  float64       `json:"from"`
+	To    float64       `json:"to"`
+}
+
+// Spec describes a profile. Exactly one of Ramp, Steps, Spike and Sine is
+// set.
+type Spec struct {
+	// TPS is the base rate of spikes and the mean rate of sine waves.
+	TPS   float64
+	Ramp  *RampSpec
+	Steps []Step
+	Spike *SpikeSpec
+	Sine  *SineSpec
+}
+
+// RampSpec describes a linear ramp from From to To TPS over the whole run,
+// broken down into Segments stages, DefaultSegments when zero.
+type RampSpec struct {
+	From, To float64
+	Segments int
+}
+
+// SpikeSpec describes a spike to Peak TPS lasting Length, At into the run.
+type SpikeSpec struct {
+	Peak   float64
+	At     time.Duration
+	Length time.Duration
+}
+
+// SineSpec describes a sine wave of Amplitude TPS around the base rate.
+type SineSpec struct {
+	Amplitude float64
+	Period    time.Duration
+}
+
+// Parse returns the profile described by spec for a run of the given
+// duration.
+func Parse(spec Spec, duration time.Duration) (Profile, error) {
+	set := 0
+	for _, ok := range []bool{spec.Ramp != nil, len(spec.Steps) > 0, spec.Spike != nil, spec.Sine != nil} {
+		if ok {
+			set++
+		}
+	}
+	switch {
+	case set != 1:
+		return nil, errors.New("exactly one of ramp, steps, spike and sine is required")
+	case duration <= 0:
+		return nil, errors.New("duration must be greater than zero")
+	case spec.TPS < 0:
+		return nil, errors.New("tps must not be negative")
+	}
+
+	switch {
+	case spec.Ramp != nil:
+		ramp := Ramp{From: spec.Ramp.From, To: spec.Ramp.To, Segments: spec.Ramp.Segments, Duration: duration}
+		switch {
+		case ramp.From < 0 || ramp.To < 0:
+			return nil, errors.New("ramp rates must not be negative")
+		case ramp.From == 0 && ramp.To == 0:
+			return nil, errors.New("ramp needs a rate greater than zero")
+		case ramp.Segments < 0:
+			return nil, errors.New("ramp segments must not be negative")
+		case ramp.Segments == 0:
+			ramp.Segments = DefaultSegments
+		}
+		return ramp, nil
+
+	case len(spec.Steps) > 0:
+		positive := false
+		for i, step := range spec.Steps {
+			switch {
+			case step.TPS < 0:
+				return nil, fmt.Errorf("step %d: tps must not be negative", i+1)
+			case step.Hold <= 0:
+				return nil, fmt.Errorf("step %d: hold must be greater than zero", i+1)
+			}
+			positive = positive || step.TPS > 0
+		}
+		if !positive {
+			return nil, errors.New("steps need a rate greater than zero")
+		}
+		return Steps{Steps: spec.Steps, Duration: duration}, nil
+
+	case spec.Spike != nil:
+		spike := Spike{Base: spec.TPS, Peak: spec.Spike.Peak, At: spec.Spike.At, Length: spec.Spike.Length, Duration: duration}
+		switch {
+		case spike.Peak <= 0:
+			return nil, errors.New("spike peak must be greater than zero")
+		case spike.Length <= 0:
+			return nil, errors.New("spike length must be greater than zero")
+		case spike.At < 0 || spike.At >= duration:
+			return nil, errors.New("spike must start within the run")
+		}
+		return spike, nil
+
+	default:
+		sine := Sine{Mean: spec.TPS, Amplitude: spec.Sine.Amplitude, Period: spec.Sine.Period, Duration: duration}
+		switch {
+		case sine.Mean <= 0:
+			return nil, errors.New("tps must be greater than zero")
+		case sine.Amplitude < 0:
+			return nil, errors.New("sine amplitude must not be negative")
+		case sine.Period <= 0:
+			return nil, errors.New("sine period must be greater than zero")
+		}
+		return sine, nil
+	}
+}
+
+// Ramp changes the rate linearly from From to To over Duration, broken down
+// into Segments stages of equal length.
+type Ramp struct {
+	From, To float64
+	Segments int
+	Duration time.Duration
+}
+
+func (r Ramp) Rate(elapsed time.Duration) float64 {
+	if elapsed >= r.Duration {
+		return r.To
+	}
+	return r.From + (r.To-r.From)*float64(elapsed)/float64(r.Duration)
+}
+
+func (r Ramp) Stages() []Stage {
+	segments := r.Segments
+	if segments < 1 {
+		segments = 1
+	}
+	stages := make([]Stage, 0, segments)
+	for i := 0; i < segments; i++ {
+		start := r.Duration * time.Duration(i) / time.Duration(segments)
+		end := r.Duration * time.Duration(i+1) / time.Duration(segments)
+		stages = append(stages, Stage{Name: fmt.Sprintf("ramp %d", i+1), Start: start, End: end, From: r.Rate(start), To: r.Rate(end)})
+	}
+	return stages
+}
+
+// Step is a rate held for a while.
+type Step struct {
+	TPS  float64
+	Hold time.Duration
+}
+
+// Steps holds the rate of every step in turn. The last step holds until the
+// end of the run; steps beyond it are cut.
+type Steps struct {
+	Steps    []Step
+	Duration time.Duration
+}
+
+func (s Steps) Rate(elapsed time.Duration) float64 {
+	var start time.Duration
+	for _, step := range s.Steps {
+		if start += step.Hold; elapsed < start {
+			return step.TPS
+		}
+	}
+	return s.Steps[len(s.Steps)-1].TPS
+}
+
+func (s Steps) Stages() []Stage {
+	var stages []Stage
+	var start time.Duration
+	for i, step := range s.Steps {
+		if start >= s.Duration {
+			break
+		}
+		end := start + step.Hold
+		if end > s.Duration || i == len(s.Steps)-1 {
+			end = s.Duration
+		}
+		stages = append(stages, Stage{Name: fmt.Sprintf("step %d", i+1), Start: start, End: end, From: step.TPS, To: step.TPS})
+		start = end
+	}
+	return stages
+}
+
+// Spike runs at Base, except for Length from At where it runs at Peak.
+type Spike struct {
+	Base, Peak float64
+	At, Length time.Duration
+	Duration   time.Duration
+}
+
+func (s Spike) Rate(elapsed time.Duration) float64 {
+	if elapsed >= s.At && elapsed < s.At+s.Length {
+		return s.Peak
+	}
+	return s.Base
+}
+
+func (s Spike) Stages() []Stage {
+	var stages []Stage
+	if s.At > 0 {
+		stages = append(stages, Stage{Name: "before spike", End: s.At, From: s.Base, To: s.Base})
+	}
+	end := s.At + s.Length
+	if end > s.Duration {
+		end = s.Duration
+	}
+	stages = append(stages, Stage{Name: "spike", Start: s.At, End: end, From: s.Peak, To: s.Peak})
+	if end < s.Duration {
+		stages = append(stages, Stage{Name: "after spike", Start: end, End: s.Duration, From: s.Base, To: s.Base})
+	}
+	return stages
+}
+
+// Sine runs at Mean plus a sine wave of Amplitude and Period, never below
+// zero. Results are broken down by period.
+type Sine struct {
+	Mean, Amplitude float64
+	Period          time.Duration
+	Duration        time.Duration
+}
+
+func (s Sine) Rate(elapsed time.Duration) float64 {
+	rate := s.Mean + s.Amplitude*math.Sin(2*math.Pi*float64(elapsed)/float64(s.Period))
+	return math.Max(rate, 0)
+}
+
+func (s Sine) Stages() []Stage {
+	var stages []Stage
+	for start, i := time.Duration(0), 1; start < s.Duration; start, i = start+s.Period, i+1 {
+		end := start + s.Period
+		if end > s.Duration {
+			end = s.Duration
+		}
+		stages = append(stages, Stage{Name: fmt.Sprintf("period %d", i), Start: start, End: end, From: s.Rate(start), To: s.Rate(end)})
+	}
+	return stages
+}
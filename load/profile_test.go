@@ -0,0 +1,130 @@
+package load
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParse(t *testing.T) {
+	tests := map[string]struct {
+		spec    Spec
+		want    Profile
+		wantErr bool
+	}{
+		"ramp": {
+			spec: Spec{Ramp: &RampSpec{From: 10, To: 100}},
+			want: Ramp{From: 10, To: 100, Segments: DefaultSegments, Duration: time.Minute},
+		},
+		"steps": {
+			spec: Spec{Steps: []Step{{TPS: 10, Hold: time.Second}}},
+			want: Steps{Steps: []Step{{TPS: 10, Hold: time.Second}}, Duration: time.Minute},
+		},
+		"spike": {
+			spec: Spec{TPS: 5, Spike: &SpikeSpec{Peak: 50, At: time.Second, Length: time.Second}},
+			want: Spike{Base: 5, Peak: 50, At: time.Second, Length: time.Second, Duration: time.Minute},
+		},
+		"sine": {
+			spec: Spec{TPS: 5, Sine: &SineSpec{Amplitude: 2, Period: time.Second}},
+			want: Sine{Mean: 5, Amplitude: 2, Period: time.Second, Duration: time.Minute},
+		},
+		"none":             {spec: Spec{TPS: 5}, wantErr: true},
+		"two":              {spec: Spec{Ramp: &RampSpec{To: 1}, Steps: []Step{{TPS: 1, Hold: time.Second}}}, wantErr: true},
+		"flat ramp":        {spec: Spec{Ramp: &RampSpec{}}, wantErr: true},
+		"step no hold":     {spec: Spec{Steps: []Step{{TPS: 1}}}, wantErr: true},
+		"idle steps":       {spec: Spec{Steps: []Step{{Hold: time.Second}}}, wantErr: true},
+		"late spike":       {spec: Spec{Spike: &SpikeSpec{Peak: 1, At: time.Hour, Length: time.Second}}, wantErr: true},
+		"sine without tps": {spec: Spec{Sine: &SineSpec{Amplitude: 1, Period: time.Second}}, wantErr: true},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			got, err := Parse(test.spec, time.Minute)
+			if test.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, test.want, got)
+		})
+	}
+}
+
+func TestProfile_Rate(t *testing.T) {
+	steps := Steps{Steps: []Step{{TPS: 10, Hold: time.Second}, {TPS: 20, Hold: time.Second}}, Duration: 5 * time.Second}
+	spike := Spike{Base: 5, Peak: 50, At: time.Second, Length: time.Second, Duration: 5 * time.Second}
+	sine := Sine{Mean: 10, Amplitude: 20, Period: 4 * time.Second, Duration: 8 * time.Second}
+	tests := map[string]struct {
+		profile Profile
+		elapsed time.Duration
+		want    float64
+	}{
+		"ramp start":      {profile: Ramp{From: 10, To: 110, Duration: 10 * time.Second}, want: 10},
+		"ramp middle":     {profile: Ramp{From: 10, To: 110, Duration: 10 * time.Second}, elapsed: 5 * time.Second, want: 60},
+		"ramp down":       {profile: Ramp{From: 100, To: 0, Duration: 10 * time.Second}, elapsed: 9 * time.Second, want: 10},
+		"first step":      {profile: steps, elapsed: 999 * time.Millisecond, want: 10},
+		"second step":     {profile: steps, elapsed: time.Second, want: 20},
+		"last step holds": {profile: steps, elapsed: 4 * time.Second, want: 20},
+		"before spike":    {profile: spike, elapsed: 500 * time.Millisecond, want: 5},
+		"spike":           {profile: spike, elapsed: 1500 * time.Millisecond, want: 50},
+		"after spike":     {profile: spike, elapsed: 2 * time.Second, want: 5},
+		"sine peak":       {profile: sine, elapsed: time.Second, want: 30},
+		"sine clamped":    {profile: sine, elapsed: 3 * time.Second, want: 0},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			assert.InDelta(t, test.want, test.profile.Rate(test.elapsed), 1e-9)
+		})
+	}
+}
+
+func TestProfile_Stages(t *testing.T) {
+	tests := map[string]struct {
+		profile Profile
+		want    []Stage
+	}{
+		"ramp": {
+			profile: Ramp{From: 0, To: 100, Segments: 2, Duration: 10 * time.Second},
+			want: []Stage{
+				{Name: "ramp 1", End: 5 * time.Second, From: 0, To: 50},
+				{Name: "ramp 2", Start: 5 * time.Second, End: 10 * time.Second, From: 50, To: 100},
+			},
+		},
+		"steps": {
+			profile: Steps{Steps: []Step{{TPS: 10, Hold: time.Second}, {TPS: 20, Hold: time.Second}, {TPS: 30, Hold: time.Minute}}, Duration: 1500 * time.Millisecond},
+			want: []Stage{
+				{Name: "step 1", End: time.Second, From: 10, To: 10},
+				{Name: "step 2", Start: time.Second, End: 1500 * time.Millisecond, From: 20, To: 20},
+			},
+		},
+		"spike": {
+			profile: Spike{Base: 5, Peak: 50, At: time.Second, Length: time.Second, Duration: 5 * time.Second},
+			want: []Stage{
+				{Name: "before spike", End: time.Second, From: 5, To: 5},
+				{Name: "spike", Start: time.Second, End: 2 * time.Second, From: 50, To: 50},
+				{Name: "after spike", Start: 2 * time.Second, End: 5 * time.Second, From: 5, To: 5},
+			},
+		},
+		"sine": {
+			profile: Sine{Mean: 10, Amplitude: 5, Period: 2 * time.Second, Duration: 3 * time.Second},
+			want: []Stage{
+				{Name: "period 1", End: 2 * time.Second, From: 10, To: 10},
+				{Name: "period 2", Start: 2 * time.Second, End: 3 * time.Second, From: 10, To: 10},
+			},
+		},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			got := test.profile.Stages()
+			require.Len(t, got, len(test.want))
+			for i := range got {
+				assert.Equal(t, test.want[i].Name, got[i].Name)
+				assert.Equal(t, test.want[i].Start, got[i].Start)
+				assert.Equal(t, test.want[i].End, got[i].End)
+				assert.InDelta(t, test.want[i].From, got[i].From, 1e-9)
+				assert.InDelta(t, test.want[i].To, got[i].To, 1e-9)
+			}
+		})
+	}
+}
@@ -0,0 +1,109 @@
+package load
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	vegeta "github.com/tsenart/vegeta/lib"
+)
+
+// Users is a closed-model load: Count virtual users, each looping through
+// every target in turn. A user waits for the response to a request and then
+// thinks for ThinkTime before sending the next one. Pacing is the least time
+// between the starts of a user's iterations through the targets.
+type Users struct {
+	Count     int           `json:"count"`
+	ThinkTime time.Duration `json:"thinkTime,omitempty"`
+	Pacing    time.Duration `json:"pacing,omitempty"`
+}
+
+// Validate reports whether the users can be run.
+func (u Users) Validate() error {
+	switch {
+	case u.Count <= 0:
+		return errors.New("count must be greater than zero")
+	case u.ThinkTime < 0:
+		return errors.New("think time must not be negative")
+	case u.Pacing < 0:
+		return errors.New("pacing must not be negative")
+	}
+	return nil
+}
+
+// AttackUsers runs users against targets for duration and sends the results
+// on the returned channel as they arrive, which is closed once every user
+// stopped. Users stop once duration passed, after the response to the request
+// they are waiting for, or once ctx is done, cancelling that request.
+//
+// A user sends one request at a time, so each keeps to about one connection.
+func AttackUsers(ctx context.Context, targets []vegeta.Target, users Users, duration time.Duration, name string) <-chan *vegeta.Result {
+	h := newHitter(name)
+
+	results := make(chan *vegeta.Result)
+	go func() {
+		defer close(results)
+		stop, cancel := context.WithTimeout(ctx, duration)
+		defer cancel()
+
+		var running sync.WaitGroup
+		for i := 0; i < users.Count; i++ {
+			running.Add(1)
+			go func() {
+				defer running.Done()
+				users.loop(ctx, stop, h, targets, results)
+			}()
+		}
+		running.Wait()
+	}()
+	return results
+}
+
+// loop runs a single user until stop is done. Requests are sent with ctx.
+func (u Users) loop(ctx, stop context.Context, h *hitter, targets []vegeta.Target, results chan<- *vegeta.Result) {
+	// a targeter of its own walks the user through the targets in order
+	tr := vegeta.NewStaticTargeter(targets...)
+	for {
+		began := time.Now()
+		for range targets {
+			if stop.Err() != nil {
+				return
+			}
+			// the static targeter never runs out
+			res, _ := h.hit(ctx, tr)
+			results <- res
+			if !sleep(stop, u.ThinkTime) {
+				return
+			}
+		}
+		if !sleep(stop, time.Until(began.Add(u.Pacing))) {
+			return
+		}
+	}
+}
+
+// sleep waits for d and reports whether ctx is still not done.
+func sleep(ctx context.Context, d time.Duration) bool {
+	if d <= 0 {
+		return ctx.Err() == nil
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// Throughput returns the successful requests per second of metrics, over
+// the whole of the attack including the wait for its last responses.
+func Throughput(metrics *vegeta.Metrics) float64 {
+	secs := (metrics.Duration + metrics.Wait).Seconds()
+	if secs <= 0 {
+		return 0
+	}
+	return metrics.Success * float64(metrics.Requests) / secs
+}
@@ -0,0 +1,121 @@
+package load
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	vegeta "github.com/tsenart/vegeta/lib"
+)
+
+func TestUsers_Validate(t *testing.T) {
+	tests := map[string]struct {
+		users   Users
+		wantErr bool
+	}{
+		"users":          {users: Users{Count: 10, ThinkTime: time.Second, Pacing: 5 * time.Second}},
+		"no users":       {users: Users{}, wantErr: true},
+		"negative think": {users: Users{Count: 1, ThinkTime: -time.Second}, wantErr: true},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			err := test.users.Validate()
+			if test.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+		})
+	}
+}
+
+func TestAttackUsers(t *testing.T) {
+	var mu sync.Mutex
+	inFlight, peak := 0, 0
+	paths := make(map[string]int)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		inFlight++
+		if inFlight > peak {
+			peak = inFlight
+		}
+		paths[r.URL.Path]++
+		mu.Unlock()
+
+		time.Sleep(20 * time.Millisecond)
+
+		mu.Lock()
+		inFlight--
+		mu.Unlock()
+	}))
+	defer server.Close()
+	targets := []vegeta.Target{
+		{Method: http.MethodGet, URL: server.URL + "/a"},
+		{Method: http.MethodGet, URL: server.URL + "/b"},
+	}
+
+	var metrics vegeta.Metrics
+	for res := range AttackUsers(context.Background(), targets, Users{Count: 2, ThinkTime: 10 * time.Millisecond}, 300*time.Millisecond, "test") {
+		metrics.Add(res)
+	}
+	metrics.Close()
+
+	mu.Lock()
+	defer mu.Unlock()
+	assert.Equal(t, 2, peak, "one request in flight per user")
+	// every user waits about 30ms per request
+	assert.InDelta(t, 20, metrics.Requests, 8)
+	assert.InDelta(t, paths["/a"], paths["/b"], 2, "users loop through every target")
+	assert.Equal(t, 1.0, metrics.Success)
+	assert.InDelta(t, 66, Throughput(&metrics), 25)
+}
+
+func TestAttackUsers_Pacing(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+	targets := []vegeta.Target{{Method: http.MethodGet, URL: server.URL}}
+
+	var timestamps []time.Time
+	for res := range AttackUsers(context.Background(), targets, Users{Count: 1, Pacing: 100 * time.Millisecond}, 350*time.Millisecond, "test") {
+		timestamps = append(timestamps, res.Timestamp)
+	}
+	require.Len(t, timestamps, 4)
+	for i := 1; i < len(timestamps); i++ {
+		assert.InDelta(t, float64(100*time.Millisecond), float64(timestamps[i].Sub(timestamps[i-1])), float64(20*time.Millisecond))
+	}
+}
+
+func TestAttackUsers_Cancel(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-time.After(5 * time.Second):
+		}
+	}))
+	defer server.Close()
+	targets := []vegeta.Target{{Method: http.MethodGet, URL: server.URL}}
+
+	// cancelling the attack cancels the requests in flight
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	began := time.Now()
+	var results []*vegeta.Result
+	for res := range AttackUsers(ctx, targets, Users{Count: 1}, time.Minute, "test") {
+		results = append(results, res)
+	}
+	assert.Less(t, int64(time.Since(began)), int64(time.Second))
+	require.Len(t, results, 1)
+	assert.NotEmpty(t, results[0].Error)
+	assert.GreaterOrEqual(t, int64(results[0].Latency), int64(50*time.Millisecond), "failed requests record their latency")
+}
+
+func TestThroughput(t *testing.T) {
+	metrics := vegeta.Metrics{Requests: 100, Success: 0.5, Duration: 9 * time.Second, Wait: time.Second}
+	assert.Equal(t, 5.0, Throughput(&metrics))
+	assert.Zero(t, Throughput(&vegeta.Metrics{}))
+}
@@ -1,16 +1,23 @@
 package main
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
 	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
 	"os"
+	"reflect"
 	"strconv"
 	"testing"
+	"time"
 
 	uuid "github.com/satori/go.uuid"
 
 	"github.com/javking07/toadlester/app"
+	"github.com/javking07/toadlester/artifacts"
 	"github.com/javking07/toadlester/conf"
 	"github.com/javking07/toadlester/model"
 	"github.com/rs/zerolog/log"
@@ -19,12 +26,20 @@ import (
 var a app.App
 var config *conf.Config
 
+// postgresTestsEnv runs the tests against the postgres of conf.SaneDefaults,
+// as started by docker-compose, instead of in-memory storage.
+const postgresTestsEnv = "TOADLESTER_POSTGRES_TESTS"
+
 func TestMain(m *testing.M) {
 	config = conf.SaneDefaults()
+	if os.Getenv(postgresTestsEnv) == "" {
+		config.Database.Type = "memory"
+	}
+	config.Artifacts = &conf.ArtifactsConfig{Store: "database"}
 
 	a.Bootstrap(config)
 	log.Info().Msg("confirming table exists...")
-	if err := a.Storage.Init(model.CreateTableQuery); err != nil {
+	if err := a.Storage.Init(context.Background()); err != nil {
 		log.Fatal().Msgf("Error creating tests table: %v", err)
 	} else {
 		purgeTable()
@@ -46,10 +61,12 @@ func executeRequest(req *http.Request) *httptest.ResponseRecorder {
 	return rr
 }
 
-//purgeTable deletes items from table
+// purgeTable deletes items from tables
 func purgeTable() {
-	if err := a.Storage.Purge("tests"); err != nil {
-		log.Error().Msg(err.Error())
+	for _, table := range []string{"tests", "load_tests"} {
+		if err := a.Storage.Purge(context.Background(), table); err != nil {
+			log.Error().Msg(err.Error())
+		}
 	}
 }
 
@@ -59,9 +76,474 @@ func addData(count int) {
 		count = 1
 	}
 	for i := 0; i < count; i++ {
-		_, err := a.Storage.Insert(uuid.NewV4().String(), strconv.Itoa(i), []byte(fmt.Sprintf(`{"tps": 100, "url": "http://example.com", "name": "today", "method": "GET", "duration": "10s"}`)))
+		started := time.Now().Add(-time.Duration(i) * time.Minute)
+		_, err := a.Storage.Insert(context.Background(), model.Payload{
+			ID:         uuid.NewV4().String(),
+			Name:       strconv.Itoa(i),
+			StartedAt:  &started,
+			Rate:       100,
+			Duration:   10 * time.Second,
+			LatencyP99: time.Duration(i) * time.Millisecond,
+			Success:    1,
+			Requests:   1000,
+			Status:     model.StatusSucceeded,
+			Data:       []byte(`{"tps": 100, "url": "http://example.com", "name": "today", "method": "GET", "duration": "10s"}`),
+		})
 		if err != nil {
 			log.Fatal().Msgf("error adding data: %v", err)
 		}
 	}
 }
+
+func checkResponseCode(t *testing.T, expected, actual int) {
+	if expected != actual {
+		t.Errorf("expected response code %d. got %d\n", expected, actual)
+	}
+}
+
+// firstResultID returns the id of the first stored result.
+func firstResultID(t *testing.T) string {
+	req, _ := http.NewRequest("GET", "/results?count=1", nil)
+	response := executeRequest(req)
+	checkResponseCode(t, http.StatusOK, response.Code)
+
+	var results []model.Payload
+	if err := json.Unmarshal(response.Body.Bytes(), &results); err != nil || len(results) != 1 {
+		t.Fatalf("expected a single result. got %s", response.Body.String())
+	}
+	return results[0].ID
+}
+
+func TestGetResults(t *testing.T) {
+	req, _ := http.NewRequest("GET", "/results?count=3&start=0", nil)
+	response := executeRequest(req)
+	checkResponseCode(t, http.StatusOK, response.Code)
+
+	var results []model.Payload
+	if err := json.Unmarshal(response.Body.Bytes(), &results); err != nil {
+		t.Fatalf("error decoding results: %v", err)
+	}
+	if len(results) != 3 {
+		t.Errorf("expected 3 results. got %d", len(results))
+	}
+}
+
+func TestGetResult(t *testing.T) {
+	id := firstResultID(t)
+
+	req, _ := http.NewRequest("GET", "/results/"+id, nil)
+	response := executeRequest(req)
+	checkResponseCode(t, http.StatusOK, response.Code)
+
+	var result model.Payload
+	if err := json.Unmarshal(response.Body.Bytes(), &result); err != nil {
+		t.Fatalf("error decoding result: %v", err)
+	}
+	if result.ID != id {
+		t.Errorf("expected result id %s. got %s", id, result.ID)
+	}
+}
+
+func TestGetNonExistentResult(t *testing.T) {
+	req, _ := http.NewRequest("GET", "/results/"+uuid.NewV4().String(), nil)
+	response := executeRequest(req)
+	checkResponseCode(t, http.StatusNotFound, response.Code)
+
+	req, _ = http.NewRequest("GET", "/results/not-a-uuid", nil)
+	response = executeRequest(req)
+	checkResponseCode(t, http.StatusBadRequest, response.Code)
+}
+
+func TestUpdateResult(t *testing.T) {
+	id := firstResultID(t)
+
+	body := []byte(`{"name": "updated", "data": {"tps": 50}}`)
+	req, _ := http.NewRequest("PUT", "/results/"+id, bytes.NewBuffer(body))
+	response := executeRequest(req)
+	checkResponseCode(t, http.StatusOK, response.Code)
+
+	req, _ = http.NewRequest("GET", "/results/"+id, nil)
+	response = executeRequest(req)
+	var result model.Payload
+	if err := json.Unmarshal(response.Body.Bytes(), &result); err != nil {
+		t.Fatalf("error decoding result: %v", err)
+	}
+	if result.Name != "updated" {
+		t.Errorf("expected name to be updated. got %s", result.Name)
+	}
+}
+
+func TestDeleteResult(t *testing.T) {
+	id := firstResultID(t)
+
+	req, _ := http.NewRequest("DELETE", "/results/"+id, nil)
+	response := executeRequest(req)
+	checkResponseCode(t, http.StatusOK, response.Code)
+
+	req, _ = http.NewRequest("GET", "/results/"+id, nil)
+	response = executeRequest(req)
+	checkResponseCode(t, http.StatusNotFound, response.Code)
+
+	req, _ = http.NewRequest("DELETE", "/results/"+id, nil)
+	response = executeRequest(req)
+	checkResponseCode(t, http.StatusNotFound, response.Code)
+}
+
+func TestCreateTest(t *testing.T) {
+	body := []byte(`{"name": "create", "duration": "10s", "tps": 5, "targets": [{"url": "http://localhost:8080/healthz"}]}`)
+	req, _ := http.NewRequest("POST", "/tests", bytes.NewBuffer(body))
+	response := executeRequest(req)
+	checkResponseCode(t, http.StatusCreated, response.Code)
+
+	req, _ = http.NewRequest("POST", "/tests", bytes.NewBuffer(body))
+	response = executeRequest(req)
+	checkResponseCode(t, http.StatusConflict, response.Code)
+
+	req, _ = http.NewRequest("POST", "/tests", bytes.NewBuffer([]byte(`{"name": "invalid", "tps": 5}`)))
+	response = executeRequest(req)
+	checkResponseCode(t, http.StatusBadRequest, response.Code)
+
+	// target files are only read for tests from the config file
+	body = []byte(`{"name": "file", "duration": "10s", "tps": 5, "target": "./testing/target.txt"}`)
+	req, _ = http.NewRequest("POST", "/tests", bytes.NewBuffer(body))
+	response = executeRequest(req)
+	checkResponseCode(t, http.StatusBadRequest, response.Code)
+}
+
+func TestGetTest(t *testing.T) {
+	addTest(t, "get")
+
+	req, _ := http.NewRequest("GET", "/tests/get", nil)
+	response := executeRequest(req)
+	checkResponseCode(t, http.StatusOK, response.Code)
+
+	var test conf.TestConfig
+	if err := json.Unmarshal(response.Body.Bytes(), &test); err != nil {
+		t.Fatalf("error decoding test: %v", err)
+	}
+	if test.Name != "get" || test.Duration.Duration != 10*time.Second {
+		t.Errorf("unexpected test definition %+v", test)
+	}
+
+	req, _ = http.NewRequest("GET", "/tests", nil)
+	response = executeRequest(req)
+	checkResponseCode(t, http.StatusOK, response.Code)
+
+	req, _ = http.NewRequest("GET", "/tests/missing", nil)
+	response = executeRequest(req)
+	checkResponseCode(t, http.StatusNotFound, response.Code)
+}
+
+func TestUpdateTest(t *testing.T) {
+	addTest(t, "update")
+
+	body := []byte(`{"duration": "20s", "tps": 10, "targets": [{"url": "http://localhost:8080/healthz"}]}`)
+	req, _ := http.NewRequest("PUT", "/tests/update", bytes.NewBuffer(body))
+	response := executeRequest(req)
+	checkResponseCode(t, http.StatusOK, response.Code)
+
+	test, err := a.Storage.SelectTest(context.Background(), "update")
+	if err != nil {
+		t.Fatalf("error selecting test: %v", err)
+	}
+	if test.TPS != 10 || test.Duration.Duration != 20*time.Second {
+		t.Errorf("expected test to be updated. got %+v", test)
+	}
+}
+
+func TestDeleteTest(t *testing.T) {
+	addTest(t, "delete")
+
+	req, _ := http.NewRequest("DELETE", "/tests/delete", nil)
+	response := executeRequest(req)
+	checkResponseCode(t, http.StatusOK, response.Code)
+
+	req, _ = http.NewRequest("DELETE", "/tests/delete", nil)
+	response = executeRequest(req)
+	checkResponseCode(t, http.StatusNotFound, response.Code)
+}
+
+// addTest stores a test definition with the given name
+func addTest(t *testing.T, name string) {
+	err := a.Storage.InsertTest(context.Background(), conf.TestConfig{
+		Name:     name,
+		Duration: conf.NewDuration(10 * time.Second),
+		TPS:      5,
+		Target:   "./testing/target.txt",
+	})
+	if err != nil {
+		t.Fatalf("error adding test: %v", err)
+	}
+}
+
+func TestAdHocRun(t *testing.T) {
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer target.Close()
+
+	body := []byte(fmt.Sprintf(`{"name": "smoke", "url": "%s", "duration": "1s", "tps": 5}`, target.URL))
+	req, _ := http.NewRequest("POST", "/runs", bytes.NewBuffer(body))
+	response := executeRequest(req)
+	checkResponseCode(t, http.StatusAccepted, response.Code)
+
+	var run app.Run
+	if err := json.Unmarshal(response.Body.Bytes(), &run); err != nil {
+		t.Fatalf("error decoding run: %v", err)
+	}
+	if response.Header().Get("Location") != "/runs/"+run.ID {
+		t.Errorf("expected location of run. got %s", response.Header().Get("Location"))
+	}
+
+	run = waitForRun(t, run.ID)
+	if run.State != app.RunSucceeded {
+		t.Fatalf("expected run to succeed. got %s: %s", run.State, run.Error)
+	}
+	if run.Metrics == nil || run.Metrics.Requests == 0 || run.ResultID == "" {
+		t.Fatalf("expected run metrics and result. got %+v", run)
+	}
+
+	req, _ = http.NewRequest("GET", "/results/"+run.ResultID, nil)
+	response = executeRequest(req)
+	checkResponseCode(t, http.StatusOK, response.Code)
+
+	var result model.Payload
+	if err := json.Unmarshal(response.Body.Bytes(), &result); err != nil {
+		t.Fatalf("error decoding result: %v", err)
+	}
+	if result.Status != model.StatusSucceeded || result.Requests != run.Metrics.Requests || result.Rate != 5 || result.StartedAt == nil {
+		t.Errorf("expected run summary to be stored. got %+v", result)
+	}
+}
+
+func TestRawResults(t *testing.T) {
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer target.Close()
+
+	body := []byte(fmt.Sprintf(`{"name": "raw", "url": "%s", "duration": "1s", "tps": 10}`, target.URL))
+	req, _ := http.NewRequest("POST", "/runs", bytes.NewBuffer(body))
+	response := executeRequest(req)
+	checkResponseCode(t, http.StatusAccepted, response.Code)
+
+	var run app.Run
+	if err := json.Unmarshal(response.Body.Bytes(), &run); err != nil {
+		t.Fatalf("error decoding run: %v", err)
+	}
+	if run = waitForRun(t, run.ID); run.State != app.RunSucceeded {
+		t.Fatalf("expected run to succeed. got %s: %s", run.State, run.Error)
+	}
+
+	req, _ = http.NewRequest("GET", "/results/"+run.ResultID+"/raw", nil)
+	response = executeRequest(req)
+	checkResponseCode(t, http.StatusOK, response.Code)
+	if format := response.Header().Get("X-Results-Format"); format != "gob" {
+		t.Errorf("expected gob raw results. got %q", format)
+	}
+
+	req, _ = http.NewRequest("GET", "/results/"+run.ResultID+"/analysis?quantiles=0.5,0.99&buckets=[0,1ms,10ms]&interval=500ms", nil)
+	response = executeRequest(req)
+	checkResponseCode(t, http.StatusOK, response.Code)
+
+	var analysis artifacts.Analysis
+	if err := json.Unmarshal(response.Body.Bytes(), &analysis); err != nil {
+		t.Fatalf("error decoding analysis: %v", err)
+	}
+	if analysis.Requests != run.Metrics.Requests || len(analysis.Quantiles) != 2 || len(analysis.Histogram) != 3 || len(analysis.Series) == 0 {
+		t.Errorf("expected analysis of every request. got %+v", analysis)
+	}
+
+	req, _ = http.NewRequest("GET", "/results/"+run.ResultID+"/plot", nil)
+	response = executeRequest(req)
+	checkResponseCode(t, http.StatusOK, response.Code)
+	if response.Header().Get("Content-Type") != "image/svg+xml" {
+		t.Errorf("expected svg plot. got %s", response.Header().Get("Content-Type"))
+	}
+
+	req, _ = http.NewRequest("GET", "/results/"+run.ResultID+"/analysis?quantiles=2", nil)
+	response = executeRequest(req)
+	checkResponseCode(t, http.StatusBadRequest, response.Code)
+
+	req, _ = http.NewRequest("GET", "/results/"+uuid.NewV4().String()+"/raw", nil)
+	response = executeRequest(req)
+	checkResponseCode(t, http.StatusNotFound, response.Code)
+}
+
+func TestStoredTestRun(t *testing.T) {
+	req, _ := http.NewRequest("POST", "/tests/missing/runs", nil)
+	response := executeRequest(req)
+	checkResponseCode(t, http.StatusNotFound, response.Code)
+
+	err := a.Storage.InsertTest(context.Background(), conf.TestConfig{
+		Name:     "broken",
+		Duration: conf.NewDuration(time.Second),
+		TPS:      5,
+		Target:   "./testing/missing.txt",
+	})
+	if err != nil {
+		t.Fatalf("error adding test: %v", err)
+	}
+
+	req, _ = http.NewRequest("POST", "/tests/broken/runs", nil)
+	response = executeRequest(req)
+	checkResponseCode(t, http.StatusAccepted, response.Code)
+
+	var run app.Run
+	if err := json.Unmarshal(response.Body.Bytes(), &run); err != nil {
+		t.Fatalf("error decoding run: %v", err)
+	}
+	if run = waitForRun(t, run.ID); run.State != app.RunFailed {
+		t.Errorf("expected run with missing target to fail. got %s", run.State)
+	}
+
+	req, _ = http.NewRequest("GET", "/runs/missing", nil)
+	response = executeRequest(req)
+	checkResponseCode(t, http.StatusNotFound, response.Code)
+}
+
+// waitForRun polls the run with the given id until it finishes
+func waitForRun(t *testing.T, id string) app.Run {
+	deadline := time.Now().Add(10 * time.Second)
+	for time.Now().Before(deadline) {
+		req, _ := http.NewRequest("GET", "/runs/"+id, nil)
+		response := executeRequest(req)
+		checkResponseCode(t, http.StatusOK, response.Code)
+
+		var run app.Run
+		if err := json.Unmarshal(response.Body.Bytes(), &run); err != nil {
+			t.Fatalf("error decoding run: %v", err)
+		}
+		if run.State == app.RunSucceeded || run.State == app.RunFailed {
+			return run
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	t.Fatalf("run %s did not finish", id)
+	return app.Run{}
+}
+
+func TestHealth(t *testing.T) {
+	req, _ := http.NewRequest("GET", "/healthz", nil)
+	response := executeRequest(req)
+	checkResponseCode(t, http.StatusServiceUnavailable, response.Code)
+
+	req, _ = http.NewRequest("GET", "/readyz", nil)
+	response = executeRequest(req)
+	checkResponseCode(t, http.StatusServiceUnavailable, response.Code)
+
+	var health app.Health
+	if err := json.Unmarshal(response.Body.Bytes(), &health); err != nil {
+		t.Fatalf("error decoding health: %v", err)
+	}
+	if !health.Database.Healthy || health.Timer.Running {
+		t.Errorf("expected healthy database and stopped timer. got %+v", health)
+	}
+
+	go a.InitTimer(config)
+	defer func() {
+		for _, c := range a.Channels {
+			c <- app.ChannelMessage{}
+		}
+	}()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for response.Code != http.StatusOK && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+		req, _ = http.NewRequest("GET", "/readyz", nil)
+		response = executeRequest(req)
+	}
+	checkResponseCode(t, http.StatusOK, response.Code)
+
+	req, _ = http.NewRequest("GET", "/healthz", nil)
+	response = executeRequest(req)
+	checkResponseCode(t, http.StatusOK, response.Code)
+}
+
+func TestQueryResults(t *testing.T) {
+	now := addQueryData(t, "query")
+	since := url.QueryEscape(now.Add(-150 * time.Second).Format(time.RFC3339))
+	until := url.QueryEscape(now.Add(-90 * time.Second).Format(time.RFC3339))
+
+	tests := map[string]struct {
+		query string
+		want  []string
+	}{
+		"by name":          {query: "name=q2", want: []string{"q2"}},
+		"newest first":     {query: "count=2", want: []string{"q0", "q1"}},
+		"oldest first":     {query: "count=2&order=asc", want: []string{"q5", "q4"}},
+		"by label":         {query: "label=parity:odd&sort=latencyP99", want: []string{"q5", "q3", "q1"}},
+		"since":            {query: "since=" + since, want: []string{"q0", "q1", "q2"}},
+		"by latency":       {query: "sort=latencyP99&order=asc&count=2", want: []string{"q0", "q1"}},
+		"no matches":       {query: "name=missing", want: []string{}},
+		"multiple filters": {query: "label=parity:even&until=" + until, want: []string{"q2", "q4"}},
+		"unknown label":    {query: "label=missing:1", want: []string{}},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			names, _ := queryResults(t, "label=suite:query&"+test.query)
+			if !reflect.DeepEqual(test.want, names) {
+				t.Errorf("expected results %v. got %v", test.want, names)
+			}
+		})
+	}
+
+	for _, query := range []string{"sort=name", "order=up", "since=yesterday", "label=novalue", "cursor=bad"} {
+		req, _ := http.NewRequest("GET", "/results?"+query, nil)
+		response := executeRequest(req)
+		checkResponseCode(t, http.StatusBadRequest, response.Code)
+	}
+}
+
+func TestQueryResultsCursor(t *testing.T) {
+	addQueryData(t, "cursor")
+
+	var names []string
+	cursor := ""
+	for page := 0; page < 10; page++ {
+		results, next := queryResults(t, "label=suite:cursor&count=4&sort=latencyP99&cursor="+cursor)
+		names = append(names, results...)
+		if cursor = next; cursor == "" {
+			break
+		}
+	}
+
+	want := []string{"q5", "q4", "q3", "q2", "q1", "q0"}
+	if !reflect.DeepEqual(want, names) {
+		t.Errorf("expected every result by descending p99. got %v", names)
+	}
+}
+
+// addQueryData adds six results labeled with the given suite, started a
+// minute apart, and returns the start time of the newest.
+func addQueryData(t *testing.T, suite string) time.Time {
+	now := time.Now().Truncate(time.Second)
+	for i := 0; i < 6; i++ {
+		started := now.Add(-time.Duration(i) * time.Minute)
+		_, err := a.Storage.Insert(context.Background(), model.Payload{
+			ID:         uuid.NewV4().String(),
+			Name:       "q" + strconv.Itoa(i),
+			StartedAt:  &started,
+			LatencyP99: time.Duration(i) * time.Millisecond,
+			Labels:     map[string]string{"suite": suite, "parity": []string{"even", "odd"}[i%2]},
+		})
+		if err != nil {
+			t.Fatalf("error adding data: %v", err)
+		}
+	}
+	return now
+}
+
+// queryResults returns the names of the results matching the query and the
+// cursor of the next page
+func queryResults(t *testing.T, query string) ([]string, string) {
+	req, _ := http.NewRequest("GET", "/results?"+query, nil)
+	response := executeRequest(req)
+	checkResponseCode(t, http.StatusOK, response.Code)
+
+	var results []model.Payload
+	if err := json.Unmarshal(response.Body.Bytes(), &results); err != nil {
+		t.Fatalf("error decoding results: %v", err)
+	}
+	names := []string{}
+	for _, result := range results {
+		names = append(names, result.Name)
+	}
+	return names, response.Header().Get("X-Next-Cursor")
+}
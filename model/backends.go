@@ -0,0 +1,65 @@
+package model
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/javking07/toadlester/conf"
+)
+
+// DefaultBackend is the storage backend used when DatabaseConfig.Type is
+// empty.
+const DefaultBackend = "postgres"
+
+// Opener opens a storage backend without preparing its schema.
+type Opener func(context.Context, *conf.DatabaseConfig) (Storage, error)
+
+var (
+	backendsMu sync.RWMutex
+	backends   = make(map[string]Opener)
+)
+
+// RegisterBackend makes a storage backend available under the given type
+// name. It panics if the name is already registered.
+func RegisterBackend(name string, open Opener) {
+	backendsMu.Lock()
+	defer backendsMu.Unlock()
+
+	if _, ok := backends[name]; ok {
+		panic(fmt.Sprintf("storage backend %q registered twice", name))
+	}
+	backends[name] = open
+}
+
+// Backends returns the names of the registered storage backends.
+func Backends() []string {
+	backendsMu.RLock()
+	defer backendsMu.RUnlock()
+
+	names := make([]string, 0, len(backends))
+	for name := range backends {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// OpenStorage opens the storage backend selected by config.Type. The schema
+// is not touched; call Init to prepare it.
+func OpenStorage(ctx context.Context, config *conf.DatabaseConfig) (Storage, error) {
+	name := strings.ToLower(config.Type)
+	if name == "" {
+		name = DefaultBackend
+	}
+
+	backendsMu.RLock()
+	open, ok := backends[name]
+	backendsMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown database type %q, expected one of %s", config.Type, strings.Join(Backends(), ", "))
+	}
+	return open(ctx, config)
+}
@@ -0,0 +1,417 @@
+package model
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/javking07/toadlester/conf"
+)
+
+func init() {
+	RegisterBackend("memory", func(context.Context, *conf.DatabaseConfig) (Storage, error) {
+		return NewMemoryStorage(), nil
+	})
+}
+
+// MemoryStorage keeps results and test definitions in memory. Nothing
+// survives a restart, so it is meant for development and tests.
+type MemoryStorage struct {
+	mu        sync.RWMutex
+	results   map[string]Payload
+	tests     map[string]conf.TestConfig
+	artifacts map[string]Artifact
+}
+
+// NewMemoryStorage returns an empty in-memory storage.
+func NewMemoryStorage() *MemoryStorage {
+	return &MemoryStorage{
+		results:   make(map[string]Payload),
+		tests:     make(map[string]conf.TestConfig),
+		artifacts: make(map[string]Artifact),
+	}
+}
+
+// Init does nothing; memory storage has no schema.
+func (m *MemoryStorage) Init(_ context.Context) error {
+	return nil
+}
+
+func (m *MemoryStorage) Insert(_ context.Context, payload Payload) (int64, error) {
+	if payload.Status == "" {
+		payload.Status = StatusSucceeded
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.results[payload.ID]; ok {
+		return 0, ErrConflict
+	}
+	m.results[payload.ID] = copyPayload(payload)
+	return 1, nil
+}
+
+func (m *MemoryStorage) Select(_ context.Context, itemId string) (Payload, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	item, ok := m.results[itemId]
+	if !ok {
+		return Payload{}, ErrNotFound
+	}
+	return copyPayload(item), nil
+}
+
+func (m *MemoryStorage) SelectAll(_ context.Context, count, start int) ([]Payload, error) {
+	m.mu.RLock()
+	payload := make([]Payload, 0, len(m.results))
+	for _, item := range m.results {
+		payload = append(payload, copyPayload(item))
+	}
+	m.mu.RUnlock()
+
+	// newest first, results without a start time last
+	sort.Slice(payload, func(i, j int) bool {
+		a, b := payload[i].StartedAt, payload[j].StartedAt
+		switch {
+		case a == nil || b == nil:
+			if (a == nil) != (b == nil) {
+				return b == nil
+			}
+		case !a.Equal(*b):
+			return a.After(*b)
+		}
+		return payload[i].ID < payload[j].ID
+	})
+	return page(payload, start, count), nil
+}
+
+func (m *MemoryStorage) Query(_ context.Context, q ResultQuery) ([]Payload, string, error) {
+	if err := q.Validate(); err != nil {
+		return nil, "", err
+	}
+
+	value := sortValues[q.SortBy]
+	// before reports whether a sorts before b in the requested order
+	before := func(av int64, aID string, bv int64, bID string) bool {
+		if av == bv {
+			if q.Descending {
+				return aID > bID
+			}
+			return aID < bID
+		}
+		if q.Descending {
+			return av > bv
+		}
+		return av < bv
+	}
+	var after *cursor
+	if q.Cursor != "" {
+		c, _ := decodeCursor(q.Cursor)
+		after = &c
+	}
+
+	m.mu.RLock()
+	payload := []Payload{}
+	for _, item := range m.results {
+		if q.matches(item) && (after == nil || before(after.Value, after.ID, value(item), item.ID)) {
+			payload = append(payload, copyPayload(item))
+		}
+	}
+	m.mu.RUnlock()
+
+	sort.Slice(payload, func(i, j int) bool {
+		return before(value(payload[i]), payload[i].ID, value(payload[j]), payload[j].ID)
+	})
+	payload = page(payload, q.Offset, q.Limit+1)
+
+	var next string
+	if len(payload) > q.Limit {
+		payload = payload[:q.Limit]
+		next = q.nextCursor(payload[len(payload)-1])
+	}
+	return payload, next, nil
+}
+
+func (m *MemoryStorage) ResultNames(_ context.Context) ([]string, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	seen := make(map[string]bool)
+	names := []string{}
+	for _, item := range m.results {
+		if !seen[item.Name] {
+			seen[item.Name] = true
+			names = append(names, item.Name)
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+func (m *MemoryStorage) Update(_ context.Context, id string, payload Payload) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	item, ok := m.results[id]
+	if !ok {
+		return ErrNotFound
+	}
+	item, err := item.updated(payload)
+	if err != nil {
+		return err
+	}
+	m.results[id] = item
+	return nil
+}
+
+func (m *MemoryStorage) Delete(_ context.Context, id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.results[id]; !ok {
+		return ErrNotFound
+	}
+	delete(m.results, id)
+	delete(m.artifacts, id)
+	return nil
+}
+
+// Healthy always succeeds; memory storage cannot become unavailable.
+func (m *MemoryStorage) Healthy(_ context.Context) error {
+	return nil
+}
+
+// Purge empties the table with the given name, using the table names of the
+// sql backends.
+func (m *MemoryStorage) Purge(_ context.Context, table string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	switch table {
+	case "tests":
+		m.results = make(map[string]Payload)
+		m.artifacts = make(map[string]Artifact)
+	case "artifacts":
+		m.artifacts = make(map[string]Artifact)
+	case "load_tests":
+		m.tests = make(map[string]conf.TestConfig)
+	default:
+		return fmt.Errorf("Error purging %s table: no such table", table)
+	}
+	log.Info().Msgf("Purging %s table", table)
+	return nil
+}
+
+func (m *MemoryStorage) InsertTest(_ context.Context, test conf.TestConfig) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.tests[test.Name]; ok {
+		return ErrConflict
+	}
+	test, err := copyTest(test)
+	if err != nil {
+		return err
+	}
+	m.tests[test.Name] = test
+	return nil
+}
+
+func (m *MemoryStorage) SelectTest(_ context.Context, name string) (conf.TestConfig, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	test, ok := m.tests[name]
+	if !ok {
+		return conf.TestConfig{}, ErrNotFound
+	}
+	return copyTest(test)
+}
+
+func (m *MemoryStorage) SelectTests(_ context.Context) ([]conf.TestConfig, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	tests := make([]conf.TestConfig, 0, len(m.tests))
+	for _, test := range m.tests {
+		test, err := copyTest(test)
+		if err != nil {
+			return nil, err
+		}
+		tests = append(tests, test)
+	}
+	sort.Slice(tests, func(i, j int) bool { return tests[i].Name < tests[j].Name })
+	return tests, nil
+}
+
+func (m *MemoryStorage) UpdateTest(_ context.Context, name string, test conf.TestConfig) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.tests[name]; !ok {
+		return ErrNotFound
+	}
+	if _, ok := m.tests[test.Name]; ok && test.Name != name {
+		return ErrConflict
+	}
+	test, err := copyTest(test)
+	if err != nil {
+		return err
+	}
+	delete(m.tests, name)
+	m.tests[test.Name] = test
+	return nil
+}
+
+func (m *MemoryStorage) DeleteTest(_ context.Context, name string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.tests[name]; !ok {
+		return ErrNotFound
+	}
+	delete(m.tests, name)
+	return nil
+}
+
+func (m *MemoryStorage) InsertArtifact(_ context.Context, artifact Artifact) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.results[artifact.ResultID]; !ok {
+		return ErrNotFound
+	}
+	if _, ok := m.artifacts[artifact.ResultID]; ok {
+		return ErrConflict
+	}
+	artifact.Data = append([]byte(nil), artifact.Data...)
+	artifact.CreatedAt = time.Now().UTC()
+	m.artifacts[artifact.ResultID] = artifact
+	return nil
+}
+
+func (m *MemoryStorage) SelectArtifact(_ context.Context, resultID string) (Artifact, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	artifact, ok := m.artifacts[resultID]
+	if !ok {
+		return Artifact{ResultID: resultID}, ErrNotFound
+	}
+	artifact.Data = append([]byte(nil), artifact.Data...)
+	return artifact, nil
+}
+
+// matches reports whether a result passes the filters of q.
+func (q ResultQuery) matches(p Payload) bool {
+	if q.Name != "" && p.Name != q.Name {
+		return false
+	}
+	if !q.Since.IsZero() && (p.StartedAt == nil || p.StartedAt.Before(q.Since)) {
+		return false
+	}
+	if !q.Until.IsZero() && (p.StartedAt == nil || !p.StartedAt.Before(q.Until)) {
+		return false
+	}
+	for k, v := range q.Labels {
+		if label, ok := p.Labels[k]; !ok || label != v {
+			return false
+		}
+	}
+	return true
+}
+
+// page returns up to count items of payload from offset start.
+func page(payload []Payload, start, count int) []Payload {
+	if start >= len(payload) {
+		return []Payload{}
+	}
+	payload = payload[start:]
+	if count < len(payload) {
+		payload = payload[:count]
+	}
+	return payload
+}
+
+// copyPayload copies the maps and slices of a payload so that stored results
+// are not shared with callers.
+func copyPayload(p Payload) Payload {
+	if p.StartedAt != nil {
+		t := *p.StartedAt
+		p.StartedAt = &t
+	}
+	if p.EndedAt != nil {
+		t := *p.EndedAt
+		p.EndedAt = &t
+	}
+	labels := make(map[string]string, len(p.Labels))
+	for k, v := range p.Labels {
+		labels[k] = v
+	}
+	p.Labels = labels
+	p.Data = append(json.RawMessage(nil), p.Data...)
+	return p
+}
+
+// copyTest copies a test definition through json, the way the other backends
+// store it, so that the copy shares none of its slices, maps and pointers.
+func copyTest(t conf.TestConfig) (conf.TestConfig, error) {
+	var test conf.TestConfig
+	data, err := json.Marshal(t)
+	if err != nil {
+		return test, err
+	}
+	err = json.Unmarshal(data, &test)
+	return test, err
+}
+
+func (m *MemoryStorage) Compact(_ context.Context, c Compaction) (CompactionResult, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var result CompactionResult
+	runs := []Payload{}
+	for _, item := range m.results {
+		if item.Name == c.Name && item.Status != StatusAggregated && item.StartedAt != nil && item.StartedAt.Before(c.RawBefore) {
+			runs = append(runs, item)
+		}
+	}
+
+	if c.Resolution > 0 {
+		existing := make(map[string]Payload)
+		for _, id := range c.aggregateIDs(runs) {
+			if aggregate, ok := m.results[id]; ok {
+				existing[id] = aggregate
+			}
+		}
+		aggregates, err := c.rollUp(runs, existing)
+		if err != nil {
+			return result, err
+		}
+		for _, aggregate := range aggregates {
+			m.results[aggregate.ID] = copyPayload(aggregate)
+		}
+		result.Aggregated = int64(len(aggregates))
+	}
+	for _, run := range runs {
+		delete(m.results, run.ID)
+		delete(m.artifacts, run.ID)
+	}
+	result.Compacted = int64(len(runs))
+
+	if !c.AggregateBefore.IsZero() {
+		for id, item := range m.results {
+			if item.Name == c.Name && item.Status == StatusAggregated && item.StartedAt.Before(c.AggregateBefore) {
+				delete(m.results, id)
+				result.Expired++
+			}
+		}
+	}
+	return result, nil
+}
@@ -0,0 +1,39 @@
+package model
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/javking07/toadlester/conf"
+)
+
+func TestMemoryStorage_Concurrent(t *testing.T) {
+	ctx := context.Background()
+	db := NewMemoryStorage()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			id := fmt.Sprintf("result-%d", i)
+			_, err := db.Insert(ctx, Payload{ID: id, Name: "test", Labels: map[string]string{"i": id}})
+			assert.NoError(t, err)
+			assert.NoError(t, db.InsertTest(ctx, conf.TestConfig{Name: id, Duration: conf.NewDuration(time.Second), TPS: 1, Target: "t"}))
+			_, _, err = db.Query(ctx, ResultQuery{Name: "test", Limit: 5})
+			assert.NoError(t, err)
+			_, err = db.SelectTests(ctx)
+			assert.NoError(t, err)
+		}(i)
+	}
+	wg.Wait()
+
+	data, err := db.SelectAll(ctx, 100, 0)
+	assert.NoError(t, err)
+	assert.Len(t, resultIDs(t, data), 10)
+}
@@ -0,0 +1,71 @@
+package model
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// Migration is a versioned schema change. Up applies the change and Down
+// reverts it.
+type Migration struct {
+	Version int    `json:"version"`
+	Name    string `json:"name"`
+	Up      string `json:"-"`
+	Down    string `json:"-"`
+}
+
+// MigrationStatus reports whether a migration has been applied.
+type MigrationStatus struct {
+	Migration
+	Applied   bool       `json:"applied"`
+	AppliedAt *time.Time `json:"appliedAt,omitempty"`
+}
+
+// Migrator is implemented by storages with a versioned schema.
+type Migrator interface {
+	// MigrateUp applies every pending migration and returns how many were
+	// applied.
+	MigrateUp(context.Context) (int, error)
+	// MigrateDown reverts up to steps of the most recently applied migrations
+	// and returns how many were reverted.
+	MigrateDown(ctx context.Context, steps int) (int, error)
+	// MigrationStatus lists every known migration and whether it is applied.
+	MigrationStatus(context.Context) ([]MigrationStatus, error)
+}
+
+// validateMigrations checks that migrations are in strictly increasing
+// version order.
+func validateMigrations(migrations []Migration) error {
+	for i, m := range migrations {
+		if m.Version <= 0 {
+			return fmt.Errorf("migration %q has invalid version %d", m.Name, m.Version)
+		}
+		if i > 0 && m.Version <= migrations[i-1].Version {
+			return fmt.Errorf("migration %d %q is out of order", m.Version, m.Name)
+		}
+	}
+	return nil
+}
+
+// migrationStatus merges migrations with the versions recorded as applied.
+func migrationStatus(migrations []Migration, applied map[int]time.Time) []MigrationStatus {
+	status := make([]MigrationStatus, len(migrations))
+	for i, m := range migrations {
+		status[i] = MigrationStatus{Migration: m}
+		if at, ok := applied[m.Version]; ok {
+			status[i].Applied = true
+			status[i].AppliedAt = &at
+		}
+	}
+	return status
+}
+
+func findMigration(migrations []Migration, version int) *Migration {
+	for i := range migrations {
+		if migrations[i].Version == version {
+			return &migrations[i]
+		}
+	}
+	return nil
+}
@@ -0,0 +1,46 @@
+package model
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPostgresMigrations(t *testing.T) {
+	assert.NoError(t, validateMigrations(postgresMigrations))
+	for _, m := range postgresMigrations {
+		assert.NotEmpty(t, m.Name)
+		assert.NotEmpty(t, m.Up, "migration %d has no up", m.Version)
+		assert.NotEmpty(t, m.Down, "migration %d has no down", m.Version)
+	}
+}
+
+func TestValidateMigrations(t *testing.T) {
+	tests := map[string]struct {
+		migrations []Migration
+		wantErr    bool
+	}{
+		"ordered":       {migrations: []Migration{{Version: 1}, {Version: 3}}},
+		"out of order":  {migrations: []Migration{{Version: 2}, {Version: 1}}, wantErr: true},
+		"duplicate":     {migrations: []Migration{{Version: 1}, {Version: 1}}, wantErr: true},
+		"zero version":  {migrations: []Migration{{Version: 0}}, wantErr: true},
+		"no migrations": {},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			err := validateMigrations(test.migrations)
+			assert.Equal(t, test.wantErr, err != nil, "error: %v", err)
+		})
+	}
+}
+
+func TestMigrationStatus(t *testing.T) {
+	at := time.Now()
+	status := migrationStatus([]Migration{{Version: 1}, {Version: 2}}, map[int]time.Time{1: at})
+
+	assert.True(t, status[0].Applied)
+	assert.Equal(t, &at, status[0].AppliedAt)
+	assert.False(t, status[1].Applied)
+	assert.Nil(t, status[1].AppliedAt)
+}
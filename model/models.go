@@ -1,70 +1,227 @@
 package model
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"time"
+
+	"github.com/javking07/toadlester/conf"
+)
+
+var (
+	// ErrNotFound is returned by Storage when the requested item does not exist.
+	ErrNotFound = errors.New("item not found")
+	// ErrConflict is returned by Storage when an item with the same key exists.
+	ErrConflict = errors.New("item already exists")
+	// ErrNoResults is returned by Payload.Results when a result carries no
+	// run metrics.
+	ErrNoResults = errors.New("result has no run metrics")
+	// ErrInvalidResult is returned by Storage when the data of an updated
+	// result cannot be read.
+	ErrInvalidResult = errors.New("invalid result")
 )
 
+// Statuses recorded with stored results.
+const (
+	StatusSucceeded  = "succeeded"
+	StatusFailed     = "failed"
+	StatusAggregated = "aggregated" // a roll up of older runs, see Compaction
+	StatusSkipped    = "skipped"    // a scheduled run skipped because the previous one was still in progress
+)
+
+// Payload is a stored test result. The summary columns make runs queryable;
+// Data holds the full vegeta metrics.
 type Payload struct {
-	ID   string          `json:"id"`
-	Name string          `json:"name"`
-	Data json.RawMessage `json:"data"` // or could be []interface{}
-	//Payload interface{} `json:"data"`
+	ID          string            `json:"id"`
+	Name        string            `json:"name"`
+	StartedAt   *time.Time        `json:"startedAt,omitempty"`
+	EndedAt     *time.Time        `json:"endedAt,omitempty"`
+	Rate        float64           `json:"rate"`     // requested requests per second
+	Duration    time.Duration     `json:"duration"` // requested duration
+	LatencyMean time.Duration     `json:"latencyMean"`
+	LatencyP50  time.Duration     `json:"latencyP50"`
+	LatencyP95  time.Duration     `json:"latencyP95"`
+	LatencyP99  time.Duration     `json:"latencyP99"`
+	LatencyMax  time.Duration     `json:"latencyMax"`
+	Success     float64           `json:"success"`
+	Requests    uint64            `json:"requests"`
+	Status      string            `json:"status"`
+	Labels      map[string]string `json:"labels,omitempty"`
+	Data        json.RawMessage   `json:"data"` // or could be []interface{}
+}
+
+// Artifact is the raw result stream of a run, gzip compressed in a vegeta
+// encoding.
+type Artifact struct {
+	ResultID  string    `json:"resultId"`
+	Format    string    `json:"format"`
+	Data      []byte    `json:"-"`
+	CreatedAt time.Time `json:"createdAt"`
 }
 
+// LoadTestSimple is an ad-hoc test of a single request, declared like the
+// inline targets of a test.
 type LoadTestSimple struct {
-	Name     string         `json:"name"`
-	Method   string         `json:"method"`
-	Url      string         `json:"url"`
-	Duration CustomDuration `json:"duration"` // in seconds
-	TPS      int            `json:"tps"`
+	Name string `json:"name"`
+	conf.TargetConfig
+	Duration conf.Duration `json:"duration"`
+	TPS      int           `json:"tps"`
 }
 
-type LoadTestComplex struct {
-	Name     string         `json:"name"`
-	Duration CustomDuration `json:"duration"` // in seconds
-	TPS      int            `json:"tps"`
-	Target   string         `json:"target"`
+// Validate reports whether the ad-hoc test can be run.
+func (l LoadTestSimple) Validate() error {
+	if err := l.TargetConfig.Validate(); err != nil {
+		return err
+	}
+	switch {
+	case l.Duration.Duration <= 0:
+		return errors.New("duration must be greater than zero")
+	case l.TPS <= 0:
+		return errors.New("tps must be greater than zero")
+	}
+	return nil
 }
 
+// LoadTestResults are the metrics held in the data of a result: the vegeta
+// metrics of a succeeded run, or the error of a failed one.
 type LoadTestResults struct {
-	Latencies struct {
-		Total   int `json:"total"`
-		Mean    int `json:"mean"`
-		Five0Th int `json:"50th"`
-		Nine5Th int `json:"95th"`
-		Nine9Th int `json:"99th"`
-		Max     int `json:"max"`
-	} `json:"latencies"`
-	BytesIn struct {
-		Total int `json:"total"`
-		Mean  int `json:"mean"`
-	} `json:"bytes_in"`
-	BytesOut struct {
-		Total int `json:"total"`
-		Mean  int `json:"mean"`
-	} `json:"bytes_out"`
-	Earliest    time.Time `json:"earliest"`
-	Latest      time.Time `json:"latest"`
-	End         time.Time `json:"end"`
-	Duration    int       `json:"duration"`
-	Wait        int       `json:"wait"`
-	Requests    int       `json:"requests"`
-	Rate        float64   `json:"rate"`
-	Success     int       `json:"success"`
-	StatusCodes struct {
-		Num200 int `json:"200"`
-	} `json:"status_codes"`
-	Errors []interface{} `json:"errors"`
+	Latencies   LatencyResults `json:"latencies"`
+	BytesIn     ByteResults    `json:"bytes_in"`
+	BytesOut    ByteResults    `json:"bytes_out"`
+	Earliest    time.Time      `json:"earliest"`
+	Latest      time.Time      `json:"latest"`
+	End         time.Time      `json:"end"`
+	Duration    time.Duration  `json:"duration"`
+	Wait        time.Duration  `json:"wait"`
+	Requests    uint64         `json:"requests"`
+	Rate        float64        `json:"rate"`
+	Success     float64        `json:"success"`
+	StatusCodes map[string]int `json:"status_codes"`    // response count per status code, 0 for requests without a response
+	Errors      []string       `json:"errors"`          // distinct request errors
+	Error       string         `json:"error,omitempty"` // why a failed run did not complete
+	// Stages break the metrics of runs with a load profile down by stage.
+	Stages []StageResults `json:"stages,omitempty"`
+	// Arrivals is the arrival process the requests of rate based runs
+	// followed.
+	Arrivals *ArrivalResults `json:"arrivals,omitempty"`
+	// Users are the virtual users of runs with a fixed number of users.
+	Users *UserResults `json:"users,omitempty"`
+	// Throughput is the number of successful requests per second achieved.
+	Throughput float64 `json:"throughput,omitempty"`
+}
+
+// UserResults are the virtual users of a run.
+type UserResults struct {
+	Count     int           `json:"count"`
+	ThinkTime time.Duration `json:"thinkTime,omitempty"`
+	Pacing    time.Duration `json:"pacing,omitempty"`
+}
+
+// ArrivalResults are the arrival process of a run: uniform, poisson or
+// bursty with bursts of On and pauses of Off.
+type ArrivalResults struct {
+	Process string        `json:"process"`
+	On      time.Duration `json:"on,omitempty"`
+	Off     time.Duration `json:"off,omitempty"`
+}
+
+// StageResults are the metrics of a stage of a run with a load profile.
+// Start and End are offsets from the start of the run; From and To are the
+// planned rates at either end.
+type StageResults struct {
+	Name    string          `json:"name"`
+	Start   time.Duration   `json:"start"`
+	End     time.Duration   `json:"end"`
+	From    float64         `json:"from"`
+	To      float64         `json:"to"`
+	Metrics LoadTestResults `json:"metrics"`
+}
+
+// LatencyResults are the request latencies of a run.
+type LatencyResults struct {
+	Total time.Duration `json:"total"`
+	Mean  time.Duration `json:"mean"`
+	P50   time.Duration `json:"50th"`
+	P95   time.Duration `json:"95th"`
+	P99   time.Duration `json:"99th"`
+	Max   time.Duration `json:"max"`
+}
+
+// ByteResults are the bytes sent or received during a run.
+type ByteResults struct {
+	Total uint64  `json:"total"`
+	Mean  float64 `json:"mean"`
+}
+
+// Results decodes the metrics held in the data of the result. Aggregated
+// results keep only their rolled up summary and skipped runs have none; both
+// return ErrNoResults.
+func (p Payload) Results() (LoadTestResults, error) {
+	var results LoadTestResults
+	if p.Status == StatusAggregated || p.Status == StatusSkipped || len(p.Data) == 0 {
+		return results, ErrNoResults
+	}
+	if err := json.Unmarshal(p.Data, &results); err != nil {
+		return results, fmt.Errorf("invalid data of result %s: %v", p.ID, err)
+	}
+	return results, nil
+}
+
+// updated returns p with the name and data of update. The summary columns of
+// succeeded runs are recomputed from the run metrics in the new data, as when
+// the run was stored; other results keep theirs.
+func (p Payload) updated(update Payload) (Payload, error) {
+	p.Name, p.Data = update.Name, append(json.RawMessage(nil), update.Data...)
+	if p.Status != StatusSucceeded {
+		return p, nil
+	}
+	results, err := p.Results()
+	if err != nil {
+		return p, fmt.Errorf("%w: %v", ErrInvalidResult, err)
+	}
+	if !results.Earliest.IsZero() {
+		p.StartedAt = &results.Earliest
+	}
+	if !results.End.IsZero() {
+		p.EndedAt = &results.End
+	}
+	p.LatencyMean = results.Latencies.Mean
+	p.LatencyP50 = results.Latencies.P50
+	p.LatencyP95 = results.Latencies.P95
+	p.LatencyP99 = results.Latencies.P99
+	p.LatencyMax = results.Latencies.Max
+	p.Success = results.Success
+	p.Requests = results.Requests
+	return p, nil
 }
 
+// Storage keeps load test results and test definitions. Every method takes a
+// context so that callers can bound and cancel storage work.
 type Storage interface {
-	Init(string) error
-	Insert(string, string, []byte) (int64, error)
-	Select(int) ([]byte, error)
-	SelectAll(int, int) ([]byte, error)
-	Update(int, Payload) error
-	Delete(int) error
-	Purge(string) error // deletes all items from table
-	Healthy() error
+	Init(context.Context) error // prepares the schema
+	Insert(context.Context, Payload) (int64, error)
+	Select(context.Context, string) (Payload, error)
+	SelectAll(context.Context, int, int) ([]Payload, error)        // newest first
+	Query(context.Context, ResultQuery) ([]Payload, string, error) // returns results and the cursor of the next page
+	ResultNames(context.Context) ([]string, error)                 // distinct names of stored results
+	Update(context.Context, string, Payload) error
+	Delete(context.Context, string) error
+	Purge(context.Context, string) error // deletes all items from table
+	Healthy(context.Context) error
+
+	InsertTest(context.Context, conf.TestConfig) error
+	SelectTest(context.Context, string) (conf.TestConfig, error)
+	SelectTests(context.Context) ([]conf.TestConfig, error)
+	UpdateTest(context.Context, string, conf.TestConfig) error
+	DeleteTest(context.Context, string) error
+
+	// Compact applies a retention rule to the results of one test.
+	Compact(context.Context, Compaction) (CompactionResult, error)
+
+	// InsertArtifact stores the raw results of a stored result. Artifacts are
+	// deleted along with their result.
+	InsertArtifact(context.Context, Artifact) error
+	SelectArtifact(context.Context, string) (Artifact, error)
 }
@@ -0,0 +1,74 @@
+package model
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	vegeta "github.com/tsenart/vegeta/lib"
+)
+
+func TestPayload_Results(t *testing.T) {
+	var metrics vegeta.Metrics
+	start := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i, code := range []uint16{200, 200, 503, 0} {
+		res := vegeta.Result{Code: code, Timestamp: start.Add(time.Duration(i) * time.Second), Latency: time.Duration(i+1) * time.Millisecond, BytesIn: 10}
+		if code != 200 {
+			res.Error = "error " + string(rune('a'+i))
+		}
+		metrics.Add(&res)
+	}
+	metrics.Close()
+	data, err := json.Marshal(metrics)
+	require.NoError(t, err)
+
+	results, err := Payload{ID: "a", Status: StatusSucceeded, Data: data}.Results()
+	require.NoError(t, err)
+	assert.Equal(t, uint64(4), results.Requests)
+	assert.Equal(t, 0.5, results.Success)
+	assert.Equal(t, map[string]int{"0": 1, "200": 2, "503": 1}, results.StatusCodes)
+	assert.ElementsMatch(t, []string{"error c", "error d"}, results.Errors)
+	assert.Equal(t, metrics.Latencies.Mean, results.Latencies.Mean)
+	assert.Equal(t, metrics.Latencies.P99, results.Latencies.P99)
+	assert.Equal(t, 4*time.Millisecond, results.Latencies.Max)
+	assert.Equal(t, uint64(40), results.BytesIn.Total)
+	assert.Equal(t, 3*time.Second, results.Duration)
+
+	results, err = Payload{ID: "b", Status: StatusFailed, Data: json.RawMessage(`{"error":"target not found"}`)}.Results()
+	require.NoError(t, err)
+	assert.Equal(t, "target not found", results.Error)
+
+	_, err = Payload{ID: "c", Status: StatusAggregated, Data: json.RawMessage(`{"runs":3}`)}.Results()
+	assert.True(t, errors.Is(err, ErrNoResults))
+	_, err = Payload{ID: "d", Data: json.RawMessage(`[]`)}.Results()
+	assert.Error(t, err)
+}
+
+func TestLoadTestSimple(t *testing.T) {
+	tests := map[string]struct {
+		body    string
+		wantErr bool
+	}{
+		"get":         {body: `{"name": "smoke", "url": "https://example.com/health", "duration": "30s", "tps": 10}`},
+		"post":        {body: `{"method": "POST", "url": "https://example.com/orders", "headers": {"Content-Type": "application/json"}, "body": "{}", "duration": "30s", "tps": 10}`},
+		"no url":      {body: `{"duration": "30s", "tps": 10}`, wantErr: true},
+		"invalid url": {body: `{"url": "example", "duration": "30s", "tps": 10}`, wantErr: true},
+		"no tps":      {body: `{"url": "https://example.com/health", "duration": "30s"}`, wantErr: true},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			var l LoadTestSimple
+			require.NoError(t, json.Unmarshal([]byte(test.body), &l))
+			err := l.Validate()
+			if test.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, 30*time.Second, l.Duration.Duration)
+		})
+	}
+}
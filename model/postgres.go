@@ -2,137 +2,586 @@ package model
 
 import (
 	"context"
-	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
 
 	_ "github.com/lib/pq"
 	"github.com/rs/zerolog/log"
 
+	"github.com/jackc/pgconn"
 	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
 	"github.com/javking07/toadlester/conf"
 )
 
+// Postgres error codes mapped onto storage errors.
+const (
+	uniqueViolation     = "23505"
+	foreignKeyViolation = "23503"
+)
+
+const (
+	// defaultConnectTimeout bounds connecting when no timeout is configured.
+	defaultConnectTimeout = 10 * time.Second
+	// defaultQueryTimeout bounds each statement when no timeout is configured,
+	// so a hung database cannot block callers forever.
+	defaultQueryTimeout = 30 * time.Second
+)
+
+func init() {
+	RegisterBackend("postgres", func(ctx context.Context, config *conf.DatabaseConfig) (Storage, error) {
+		return ConnectPostgres(ctx, config)
+	})
+}
+
+// PostgresStorage keeps results and test definitions in postgres, through a
+// connection pool shared by all callers.
 type PostgresStorage struct {
-	databaseConn *pgx.Conn
+	pool         *pgxpool.Pool
 	dbName       string
+	queryTimeout time.Duration
 }
 
-func BootstrapPostgres(config *conf.DatabaseConfig) (PostgresStorage, error) {
-	// connect to database
-	dbInfo := fmt.Sprintf("postgres://%s:%s@%s:%d/%s",
-		config.User,
-		config.Password,
-		config.Host,
-		config.Port,
-		config.DatabaseName,
-	)
-	log.Info().Msgf("attempting to connect to database with info %s", dbInfo)
-	conn, err := pgx.Connect(context.Background(), dbInfo)
+// BootstrapPostgres connects to postgres and applies pending migrations.
+func BootstrapPostgres(ctx context.Context, config *conf.DatabaseConfig) (PostgresStorage, error) {
+	db, err := ConnectPostgres(ctx, config)
 	if err != nil {
 		return PostgresStorage{}, err
-	} else {
-		log.Info().Msgf("connected to database on host %s:%v", config.Host, config.Port)
 	}
+	if err := db.Init(ctx); err != nil {
+		db.Close()
+		return PostgresStorage{}, err
+	}
+	return db, nil
+}
 
-	if conn == nil {
-		return PostgresStorage{}, fmt.Errorf("no database available: %v", conn)
+// ConnectPostgres opens a connection pool to postgres without touching the
+// schema.
+func ConnectPostgres(ctx context.Context, config *conf.DatabaseConfig) (PostgresStorage, error) {
+	// connect to database
+	dbInfo := postgresURL(config)
+	log.Info().Msgf("attempting to connect to database with info %s", dbInfo.Redacted())
+	poolConfig, err := pgxpool.ParseConfig(dbInfo.String())
+	if err != nil {
+		return PostgresStorage{}, err
+	}
+	if config.MaxConns > 0 {
+		poolConfig.MaxConns = config.MaxConns
+	}
+	if config.MinConns > 0 {
+		poolConfig.MinConns = config.MinConns
 	}
-	_, err = conn.Exec(context.Background(), CreateTableQuery)
+	if config.MaxConnLifetime != nil {
+		poolConfig.MaxConnLifetime = *config.MaxConnLifetime
+	}
+	if config.MaxConnIdleTime != nil {
+		poolConfig.MaxConnIdleTime = *config.MaxConnIdleTime
+	}
+	poolConfig.ConnConfig.ConnectTimeout = durationOr(config.ConnectTimeout, defaultConnectTimeout)
+
+	pool, err := pgxpool.ConnectConfig(ctx, poolConfig)
 	if err != nil {
 		return PostgresStorage{}, err
 	}
+	log.Info().Msgf("connected to database on host %s:%v with up to %d connections", config.Host, config.Port, poolConfig.MaxConns)
 
-	return PostgresStorage{conn, config.DatabaseName}, nil
+	return PostgresStorage{pool, config.DatabaseName, durationOr(config.QueryTimeout, defaultQueryTimeout)}, nil
+}
+
+// postgresURL builds the connection url for config, including its tls
+// settings. Certificate paths are read when the url is parsed.
+func postgresURL(config *conf.DatabaseConfig) *url.URL {
+	params := url.Values{}
+	if config.SslMode != "" {
+		params.Set("sslmode", config.SslMode)
+	}
+	if config.SslRootCert != "" {
+		params.Set("sslrootcert", config.SslRootCert)
+	}
+	if config.SslCert != "" {
+		params.Set("sslcert", config.SslCert)
+	}
+	if config.SslKey != "" {
+		params.Set("sslkey", config.SslKey)
+	}
+	return &url.URL{
+		Scheme:   "postgres",
+		User:     url.UserPassword(config.User, config.Password),
+		Host:     net.JoinHostPort(config.Host, strconv.Itoa(config.Port)),
+		Path:     "/" + config.DatabaseName,
+		RawQuery: params.Encode(),
+	}
 }
 
-func (p PostgresStorage) Init(query string) error {
-	if p.databaseConn == nil {
-		return fmt.Errorf("no databse available: %v", p.databaseConn)
+// Init brings the schema up to date by applying pending migrations.
+func (p PostgresStorage) Init(ctx context.Context) error {
+	if p.pool == nil {
+		return fmt.Errorf("no databse available: %v", p.pool)
 	}
-	_, err := p.databaseConn.Exec(context.Background(), query)
+	applied, err := p.MigrateUp(ctx)
 	if err != nil {
 		return err
 	}
+	log.Info().Msgf("applied %d migrations", applied)
 	return nil
 }
 
-func (p PostgresStorage) Insert(id string, itemName string, payload []byte) (int64, error) {
-	query := `INSERT INTO tests (id, name,data) VALUES ($1,$2, $3)`
-	result, err := p.databaseConn.Exec(context.Background(), query, id, itemName, payload)
+// Close closes every connection of the pool.
+func (p PostgresStorage) Close() error {
+	p.pool.Close()
+	return nil
+}
 
-	if err != nil {
-		return 0, err
-	}
+// withTimeout bounds a single statement by the query timeout.
+func (p PostgresStorage) withTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	return context.WithTimeout(ctx, p.queryTimeout)
+}
 
-	if affected := result.RowsAffected(); affected <= 1 {
-		return affected, err
-	} else {
-		return affected, nil
+func (p PostgresStorage) Insert(ctx context.Context, payload Payload) (int64, error) {
+	ctx, cancel := p.withTimeout(ctx)
+	defer cancel()
+
+	result, err := insertResult(ctx, p.pool, payload)
+	if err != nil {
+		return 0, postgresError(err)
 	}
+	return result.RowsAffected(), nil
 }
 
-func (p PostgresStorage) Select(itemId int) ([]byte, error) {
-	var data Payload
-	err := p.databaseConn.QueryRow(context.Background(), `SELECT id, name, data FROM tests WHERE id=$1`, itemId).Scan(&data.ID, &data.Name, &data.Data)
-	if err != nil {
-		return nil, err
+// insertResult inserts a result through db, which may be a transaction.
+func insertResult(ctx context.Context, db pgxQuerier, payload Payload) (pgconn.CommandTag, error) {
+	if payload.Status == "" {
+		payload.Status = StatusSucceeded
+	}
+	if payload.Labels == nil {
+		payload.Labels = map[string]string{}
 	}
+	query := `INSERT INTO tests (id, name, started_at, ended_at, rate, duration,
+latency_mean, latency_p50, latency_p95, latency_p99, latency_max, success, requests, status, labels, data)
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16)`
+	return db.Exec(ctx, query, payload.ID, payload.Name, payload.StartedAt, payload.EndedAt,
+		payload.Rate, int64(payload.Duration), int64(payload.LatencyMean), int64(payload.LatencyP50), int64(payload.LatencyP95),
+		int64(payload.LatencyP99), int64(payload.LatencyMax), payload.Success, int64(payload.Requests), payload.Status, payload.Labels, []byte(payload.Data))
+}
 
-	var items []Payload
-	items = append(items, data)
+func (p PostgresStorage) Select(ctx context.Context, itemId string) (Payload, error) {
+	ctx, cancel := p.withTimeout(ctx)
+	defer cancel()
 
-	return json.Marshal(items)
+	row := p.pool.QueryRow(ctx, "SELECT "+resultColumns+" FROM tests WHERE id=$1", itemId)
+	item, err := scanResult(row)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return Payload{}, ErrNotFound
+	}
+	return item, err
 }
 
-func (p PostgresStorage) SelectAll(count, start int) ([]byte, error) {
-	rows, err := p.databaseConn.Query(context.Background(), "SELECT id, name, data FROM tests LIMIT $1 OFFSET $2", count, start)
+func (p PostgresStorage) SelectAll(ctx context.Context, count, start int) ([]Payload, error) {
+	ctx, cancel := p.withTimeout(ctx)
+	defer cancel()
+
+	rows, err := p.pool.Query(ctx, "SELECT "+resultColumns+" FROM tests ORDER BY started_at DESC NULLS LAST, id LIMIT $1 OFFSET $2", count, start)
 	if err != nil {
 		return nil, err
 	}
 	defer func() { rows.Close() }()
 
-	var payload []Payload
+	payload := []Payload{}
 	for rows.Next() {
-		var item Payload
-		err := rows.Scan(&item.ID, &item.Name, &item.Data)
+		item, err := scanResult(rows)
 		if err != nil {
 			return nil, err
 		}
 		payload = append(payload, item)
 	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return payload, nil
+}
+
+func (p PostgresStorage) Query(ctx context.Context, q ResultQuery) ([]Payload, string, error) {
+	ctx, cancel := p.withTimeout(ctx)
+	defer cancel()
+
+	if err := q.Validate(); err != nil {
+		return nil, "", err
+	}
+
+	var where []string
+	var args []interface{}
+	arg := func(v interface{}) string {
+		args = append(args, v)
+		return fmt.Sprintf("$%d", len(args))
+	}
 
-	if len(payload) == 0 {
-		return nil, sql.ErrNoRows
+	if q.Name != "" {
+		where = append(where, "name = "+arg(q.Name))
+	}
+	if !q.Since.IsZero() {
+		where = append(where, "started_at >= "+arg(q.Since))
+	}
+	if !q.Until.IsZero() {
+		where = append(where, "started_at < "+arg(q.Until))
 	}
-	return json.Marshal(payload)
+	if len(q.Labels) > 0 {
+		where = append(where, "labels @> "+arg(q.Labels))
+	}
+
+	column, order, cmp := postgresSortColumns[q.SortBy], "ASC", ">"
+	if q.Descending {
+		order, cmp = "DESC", "<"
+	}
+	if q.Cursor != "" {
+		c, _ := decodeCursor(q.Cursor)
+		var value interface{} = c.Value
+		if q.SortBy == SortStartedAt {
+			value = time.Unix(0, c.Value).UTC()
+		}
+		where = append(where, fmt.Sprintf("(%s, id) %s (%s, %s)", column, cmp, arg(value), arg(c.ID)))
+	}
+
+	query := "SELECT " + resultColumns + " FROM tests"
+	if len(where) > 0 {
+		query += " WHERE " + strings.Join(where, " AND ")
+	}
+	// fetch one extra row to learn whether there is a next page
+	query += fmt.Sprintf(" ORDER BY %s %s, id %s LIMIT %s OFFSET %s", column, order, order, arg(q.Limit+1), arg(q.Offset))
+
+	rows, err := p.pool.Query(ctx, query, args...)
+	if err != nil {
+		return nil, "", err
+	}
+	defer rows.Close()
+
+	payload := []Payload{}
+	for rows.Next() {
+		item, err := scanResult(rows)
+		if err != nil {
+			return nil, "", err
+		}
+		payload = append(payload, item)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, "", err
+	}
+
+	var next string
+	if len(payload) > q.Limit {
+		payload = payload[:q.Limit]
+		next = q.nextCursor(payload[len(payload)-1])
+	}
+	return payload, next, nil
 }
 
-func (p PostgresStorage) Update(id int, payload Payload) error {
-	_, err := p.databaseConn.Exec(context.Background(), "UPDATE tests SET name=$1, data=$2 WHERE id=$3", payload.Name, payload.Data, id)
-	return err
+// durationOr returns d, or fallback when d is not set.
+func durationOr(d *time.Duration, fallback time.Duration) time.Duration {
+	if d == nil || *d <= 0 {
+		return fallback
+	}
+	return *d
 }
 
-func (p PostgresStorage) Delete(id int) error {
-	_, err := p.databaseConn.Exec(context.Background(), "DELETE FROM tests where id=$1", id)
-	return err
+// scanResult scans a row of resultColumns.
+func scanResult(row pgx.Row) (Payload, error) {
+	var item Payload
+	var duration, mean, p50, p95, p99, max, requests int64
+	err := row.Scan(&item.ID, &item.Name, &item.StartedAt, &item.EndedAt, &item.Rate, &duration,
+		&mean, &p50, &p95, &p99, &max, &item.Success, &requests, &item.Status, &item.Labels, &item.Data)
+	item.Duration = time.Duration(duration)
+	item.LatencyMean = time.Duration(mean)
+	item.LatencyP50 = time.Duration(p50)
+	item.LatencyP95 = time.Duration(p95)
+	item.LatencyP99 = time.Duration(p99)
+	item.LatencyMax = time.Duration(max)
+	item.Requests = uint64(requests)
+	return item, err
 }
 
-func (p PostgresStorage) Healthy() error {
-	err := p.databaseConn.Ping(context.Background())
+func (p PostgresStorage) ResultNames(ctx context.Context) ([]string, error) {
+	ctx, cancel := p.withTimeout(ctx)
+	defer cancel()
+
+	rows, err := p.pool.Query(ctx, "SELECT DISTINCT name FROM tests ORDER BY name")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	names := []string{}
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		names = append(names, name)
+	}
+	return names, rows.Err()
+}
+
+func (p PostgresStorage) Update(ctx context.Context, id string, payload Payload) error {
+	ctx, cancel := p.withTimeout(ctx)
+	defer cancel()
+
+	tx, err := p.pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback(ctx) }()
+
+	item, err := scanResult(tx.QueryRow(ctx, "SELECT "+resultColumns+" FROM tests WHERE id=$1 FOR UPDATE", id))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return ErrNotFound
+	} else if err != nil {
+		return err
+	}
+	if item, err = item.updated(payload); err != nil {
+		return err
+	}
+	query := `UPDATE tests SET name=$1, started_at=$2, ended_at=$3, latency_mean=$4, latency_p50=$5, latency_p95=$6,
+latency_p99=$7, latency_max=$8, success=$9, requests=$10, data=$11 WHERE id=$12`
+	if _, err := tx.Exec(ctx, query, item.Name, item.StartedAt, item.EndedAt, int64(item.LatencyMean), int64(item.LatencyP50),
+		int64(item.LatencyP95), int64(item.LatencyP99), int64(item.LatencyMax), item.Success, int64(item.Requests), []byte(item.Data), id); err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+func (p PostgresStorage) Delete(ctx context.Context, id string) error {
+	ctx, cancel := p.withTimeout(ctx)
+	defer cancel()
+
+	result, err := p.pool.Exec(ctx, "DELETE FROM tests where id=$1", id)
+	if err != nil {
+		return err
+	}
+	if result.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (p PostgresStorage) Healthy(ctx context.Context) error {
+	ctx, cancel := p.withTimeout(ctx)
+	defer cancel()
+
+	err := p.pool.Ping(ctx)
 	if err != nil {
 		return err
 	}
 	return nil
 }
 
-func (p PostgresStorage) Purge(table string) error {
-	if _, err := p.databaseConn.Exec(context.Background(), fmt.Sprintf("DELETE FROM %s", table)); err != nil {
+func (p PostgresStorage) Purge(ctx context.Context, table string) error {
+	ctx, cancel := p.withTimeout(ctx)
+	defer cancel()
+
+	if _, err := p.pool.Exec(ctx, fmt.Sprintf("DELETE FROM %s", table)); err != nil {
 		return fmt.Errorf("Error purging %s table: %v", table, err)
 	}
 	log.Info().Msgf("Purging %s table", table)
-	if _, err := p.databaseConn.Exec(context.Background(), fmt.Sprintf("ALTER SEQUENCE %s_id_seq RESTART WITH 1", table)); err != nil {
+	return nil
+}
+
+func (p PostgresStorage) InsertTest(ctx context.Context, test conf.TestConfig) error {
+	ctx, cancel := p.withTimeout(ctx)
+	defer cancel()
+
+	data, err := json.Marshal(test)
+	if err != nil {
+		return err
+	}
+	_, err = p.pool.Exec(ctx, "INSERT INTO load_tests (name, data) VALUES ($1, $2)", test.Name, data)
+	return postgresError(err)
+}
+
+func (p PostgresStorage) SelectTest(ctx context.Context, name string) (conf.TestConfig, error) {
+	ctx, cancel := p.withTimeout(ctx)
+	defer cancel()
+
+	var test conf.TestConfig
+	var data []byte
+	err := p.pool.QueryRow(ctx, "SELECT data FROM load_tests WHERE name=$1", name).Scan(&data)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return test, ErrNotFound
+	} else if err != nil {
+		return test, err
+	}
+	err = json.Unmarshal(data, &test)
+	return test, err
+}
+
+func (p PostgresStorage) SelectTests(ctx context.Context) ([]conf.TestConfig, error) {
+	ctx, cancel := p.withTimeout(ctx)
+	defer cancel()
+
+	rows, err := p.pool.Query(ctx, "SELECT data FROM load_tests ORDER BY name")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tests := []conf.TestConfig{}
+	for rows.Next() {
+		var data []byte
+		if err := rows.Scan(&data); err != nil {
+			return nil, err
+		}
+		var test conf.TestConfig
+		if err := json.Unmarshal(data, &test); err != nil {
+			return nil, err
+		}
+		tests = append(tests, test)
+	}
+	return tests, rows.Err()
+}
+
+func (p PostgresStorage) UpdateTest(ctx context.Context, name string, test conf.TestConfig) error {
+	ctx, cancel := p.withTimeout(ctx)
+	defer cancel()
+
+	data, err := json.Marshal(test)
+	if err != nil {
+		return err
+	}
+	result, err := p.pool.Exec(ctx, "UPDATE load_tests SET name=$1, data=$2 WHERE name=$3", test.Name, data, name)
+	if err != nil {
+		return postgresError(err)
+	}
+	if result.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (p PostgresStorage) DeleteTest(ctx context.Context, name string) error {
+	ctx, cancel := p.withTimeout(ctx)
+	defer cancel()
+
+	result, err := p.pool.Exec(ctx, "DELETE FROM load_tests WHERE name=$1", name)
+	if err != nil {
 		return err
 	}
+	if result.RowsAffected() == 0 {
+		return ErrNotFound
+	}
 	return nil
 }
+
+// Compact rolls runs up and deletes them in a single transaction, so that a
+// failed compaction leaves results untouched. It is not bound by the query
+// timeout; a large backlog can take a while.
+func (p PostgresStorage) Compact(ctx context.Context, c Compaction) (CompactionResult, error) {
+	var result CompactionResult
+	tx, err := p.pool.Begin(ctx)
+	if err != nil {
+		return result, err
+	}
+	defer func() { _ = tx.Rollback(ctx) }()
+
+	if c.Resolution > 0 {
+		runs, err := queryResults(ctx, tx, "SELECT "+resultColumns+" FROM tests WHERE name=$1 AND status<>$2 AND started_at<$3",
+			c.Name, StatusAggregated, c.RawBefore)
+		if err != nil {
+			return result, err
+		}
+		ids := c.aggregateIDs(runs)
+		existing := make(map[string]Payload)
+		stored, err := queryResults(ctx, tx, "SELECT "+resultColumns+" FROM tests WHERE id::text = ANY($1)", ids)
+		if err != nil {
+			return result, err
+		}
+		for _, aggregate := range stored {
+			existing[aggregate.ID] = aggregate
+		}
+		aggregates, err := c.rollUp(runs, existing)
+		if err != nil {
+			return result, err
+		}
+		if _, err := tx.Exec(ctx, "DELETE FROM tests WHERE id::text = ANY($1)", ids); err != nil {
+			return result, err
+		}
+		for _, aggregate := range aggregates {
+			if _, err := insertResult(ctx, tx, aggregate); err != nil {
+				return result, err
+			}
+		}
+		result.Aggregated = int64(len(aggregates))
+	}
+
+	deleted, err := tx.Exec(ctx, "DELETE FROM tests WHERE name=$1 AND status<>$2 AND started_at<$3", c.Name, StatusAggregated, c.RawBefore)
+	if err != nil {
+		return result, err
+	}
+	result.Compacted = deleted.RowsAffected()
+
+	if !c.AggregateBefore.IsZero() {
+		expired, err := tx.Exec(ctx, "DELETE FROM tests WHERE name=$1 AND status=$2 AND started_at<$3", c.Name, StatusAggregated, c.AggregateBefore)
+		if err != nil {
+			return result, err
+		}
+		result.Expired = expired.RowsAffected()
+	}
+	return result, tx.Commit(ctx)
+}
+
+func (p PostgresStorage) InsertArtifact(ctx context.Context, artifact Artifact) error {
+	ctx, cancel := p.withTimeout(ctx)
+	defer cancel()
+
+	_, err := p.pool.Exec(ctx, "INSERT INTO artifacts (result_id, format, data) VALUES ($1, $2, $3)",
+		artifact.ResultID, artifact.Format, artifact.Data)
+	return postgresError(err)
+}
+
+func (p PostgresStorage) SelectArtifact(ctx context.Context, resultID string) (Artifact, error) {
+	ctx, cancel := p.withTimeout(ctx)
+	defer cancel()
+
+	artifact := Artifact{ResultID: resultID}
+	err := p.pool.QueryRow(ctx, "SELECT format, data, created_at FROM artifacts WHERE result_id=$1", resultID).
+		Scan(&artifact.Format, &artifact.Data, &artifact.CreatedAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return artifact, ErrNotFound
+	}
+	return artifact, err
+}
+
+// queryResults runs a query selecting resultColumns through db.
+func queryResults(ctx context.Context, db pgxQuerier, query string, args ...interface{}) ([]Payload, error) {
+	rows, err := db.Query(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	payload := []Payload{}
+	for rows.Next() {
+		item, err := scanResult(rows)
+		if err != nil {
+			return nil, err
+		}
+		payload = append(payload, item)
+	}
+	return payload, rows.Err()
+}
+
+// postgresError maps postgres errors onto storage errors.
+func postgresError(err error) error {
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		switch pgErr.Code {
+		case uniqueViolation:
+			return ErrConflict
+		case foreignKeyViolation:
+			return ErrNotFound
+		}
+	}
+	return err
+}
@@ -0,0 +1,161 @@
+package model
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgconn"
+	"github.com/jackc/pgx/v4"
+	"github.com/rs/zerolog/log"
+)
+
+// migrationLockKey is the postgres advisory lock held while migrating, so
+// that instances starting together do not apply migrations concurrently.
+const migrationLockKey int64 = 7164616480
+
+// pgxQuerier is satisfied by both the pool and a single pooled connection.
+type pgxQuerier interface {
+	Exec(ctx context.Context, sql string, args ...interface{}) (pgconn.CommandTag, error)
+	Query(ctx context.Context, sql string, args ...interface{}) (pgx.Rows, error)
+	Begin(ctx context.Context) (pgx.Tx, error)
+}
+
+func (p PostgresStorage) MigrateUp(ctx context.Context) (int, error) {
+	conn, unlock, err := p.lockMigrations(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer unlock()
+
+	applied, err := appliedMigrations(ctx, conn)
+	if err != nil {
+		return 0, err
+	}
+
+	count := 0
+	for _, m := range postgresMigrations {
+		if _, ok := applied[m.Version]; ok {
+			continue
+		}
+		log.Info().Msgf("applying migration %d %s", m.Version, m.Name)
+		if err := runMigration(ctx, conn, m.Up, "INSERT INTO schema_migrations (version, name) VALUES ($1, $2)", m.Version, m.Name); err != nil {
+			return count, fmt.Errorf("error applying migration %d %s: %v", m.Version, m.Name, err)
+		}
+		count++
+	}
+	return count, nil
+}
+
+func (p PostgresStorage) MigrateDown(ctx context.Context, steps int) (int, error) {
+	conn, unlock, err := p.lockMigrations(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer unlock()
+
+	applied, err := appliedMigrations(ctx, conn)
+	if err != nil {
+		return 0, err
+	}
+	for version := range applied {
+		if findMigration(postgresMigrations, version) == nil {
+			return 0, fmt.Errorf("database has unknown migration %d applied", version)
+		}
+	}
+
+	count := 0
+	for i := len(postgresMigrations) - 1; i >= 0 && count < steps; i-- {
+		m := postgresMigrations[i]
+		if _, ok := applied[m.Version]; !ok {
+			continue
+		}
+		log.Info().Msgf("reverting migration %d %s", m.Version, m.Name)
+		if err := runMigration(ctx, conn, m.Down, "DELETE FROM schema_migrations WHERE version=$1", m.Version); err != nil {
+			return count, fmt.Errorf("error reverting migration %d %s: %v", m.Version, m.Name, err)
+		}
+		count++
+	}
+	return count, nil
+}
+
+func (p PostgresStorage) MigrationStatus(ctx context.Context) ([]MigrationStatus, error) {
+	if _, err := p.pool.Exec(ctx, createMigrationsTableQuery); err != nil {
+		return nil, err
+	}
+	applied, err := appliedMigrations(ctx, p.pool)
+	if err != nil {
+		return nil, err
+	}
+	return migrationStatus(postgresMigrations, applied), nil
+}
+
+// lockMigrations takes the migration advisory lock, creating the migrations
+// table once it is held. The lock belongs to a session, so it returns the
+// pooled connection holding it; migrations must run on that connection. The
+// returned func releases the lock and the connection.
+func (p PostgresStorage) lockMigrations(ctx context.Context) (pgxQuerier, func(), error) {
+	if err := validateMigrations(postgresMigrations); err != nil {
+		return nil, nil, err
+	}
+	conn, err := p.pool.Acquire(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	if _, err := conn.Exec(ctx, "SELECT pg_advisory_lock($1)", migrationLockKey); err != nil {
+		conn.Release()
+		return nil, nil, fmt.Errorf("error taking migration lock: %v", err)
+	}
+	unlock := func() {
+		// the caller's context may be done by now, which must not leave the
+		// lock held on a connection that goes back to the pool
+		if _, err := conn.Exec(context.Background(), "SELECT pg_advisory_unlock($1)", migrationLockKey); err != nil {
+			log.Error().Msgf("error releasing migration lock: %v", err)
+			_ = conn.Conn().Close(context.Background())
+		}
+		conn.Release()
+	}
+	if _, err := conn.Exec(ctx, createMigrationsTableQuery); err != nil {
+		unlock()
+		return nil, nil, err
+	}
+	return conn, unlock, nil
+}
+
+// appliedMigrations returns the applied migration versions and when they
+// were applied.
+func appliedMigrations(ctx context.Context, db pgxQuerier) (map[int]time.Time, error) {
+	rows, err := db.Query(ctx, "SELECT version, applied_at FROM schema_migrations")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	applied := make(map[int]time.Time)
+	for rows.Next() {
+		var version int
+		var at time.Time
+		if err := rows.Scan(&version, &at); err != nil {
+			return nil, err
+		}
+		applied[version] = at
+	}
+	return applied, rows.Err()
+}
+
+// runMigration runs a migration query and records it in a single transaction.
+func runMigration(ctx context.Context, db pgxQuerier, query, record string, args ...interface{}) error {
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback(ctx) }()
+
+	if _, err := tx.Exec(ctx, query); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(ctx, record, args...); err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
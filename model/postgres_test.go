@@ -0,0 +1,109 @@
+package model
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v4/pgxpool"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/javking07/toadlester/conf"
+)
+
+func TestPostgresURL(t *testing.T) {
+	config := &conf.DatabaseConfig{
+		Host:         "db.example.com",
+		Port:         5432,
+		User:         "toad",
+		Password:     "s3cret",
+		DatabaseName: "toadlester",
+		SslMode:      "verify-full",
+		SslRootCert:  "/etc/ssl/ca.pem",
+		SslCert:      "/etc/ssl/client.pem",
+		SslKey:       "/etc/ssl/client.key",
+	}
+
+	u := postgresURL(config)
+	password, _ := u.User.Password()
+	assert.Equal(t, "s3cret", password)
+	assert.Equal(t, "verify-full", u.Query().Get("sslmode"))
+	assert.Equal(t, "/etc/ssl/ca.pem", u.Query().Get("sslrootcert"))
+	assert.Equal(t, "/etc/ssl/client.pem", u.Query().Get("sslcert"))
+	assert.Equal(t, "/etc/ssl/client.key", u.Query().Get("sslkey"))
+	assert.NotContains(t, u.Redacted(), "s3cret")
+}
+
+func TestPostgresURL_TLS(t *testing.T) {
+	ca := writeTestCA(t)
+	base := conf.DatabaseConfig{Host: "localhost", Port: 5432, User: "postgres", Password: "p@ss:word/", DatabaseName: "postgres"}
+	tests := map[string]struct {
+		sslMode    string
+		rootCert   string
+		wantTLS    bool
+		skipVerify bool
+		wantErr    bool
+	}{
+		"disable":      {sslMode: "disable"},
+		"require":      {sslMode: "require", wantTLS: true, skipVerify: true},
+		"verify-ca":    {sslMode: "verify-ca", rootCert: ca, wantTLS: true, skipVerify: true},
+		"verify-full":  {sslMode: "verify-full", rootCert: ca, wantTLS: true},
+		"unknown mode": {sslMode: "sometimes", wantErr: true},
+		"missing ca":   {sslMode: "verify-ca", rootCert: filepath.Join(t.TempDir(), "missing.pem"), wantErr: true},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			config := base
+			config.SslMode, config.SslRootCert = test.sslMode, test.rootCert
+
+			parsed, err := pgxpool.ParseConfig(postgresURL(&config).String())
+			if test.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, "p@ss:word/", parsed.ConnConfig.Password)
+			tlsConfig := parsed.ConnConfig.TLSConfig
+			require.Equal(t, test.wantTLS, tlsConfig != nil)
+			if tlsConfig == nil {
+				return
+			}
+			// verify-ca checks the chain itself, without the hostname
+			assert.Equal(t, test.skipVerify, tlsConfig.InsecureSkipVerify)
+			if test.rootCert != "" {
+				assert.NotNil(t, tlsConfig.RootCAs)
+			}
+		})
+	}
+}
+
+// writeTestCA writes a self-signed CA certificate and returns its path.
+func writeTestCA(t *testing.T) string {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "toadlester test ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+
+	path := filepath.Join(t.TempDir(), "ca.pem")
+	require.NoError(t, ioutil.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600))
+	return path
+}
@@ -1,7 +1,177 @@
 package model
 
-//CreateTableQuery is sql query for creating fda_data table
-const CreateTableQuery string = `CREATE TABLE IF NOT EXISTS tests (
+// postgresMigrations are the versioned schema changes of the postgres
+// backend, in the order they are applied. Released migrations must never be
+// edited; add a new one instead.
+var postgresMigrations = []Migration{
+	{
+		Version: 1,
+		Name:    "create_tests",
+		Up: `CREATE TABLE IF NOT EXISTS tests (
 id uuid PRIMARY KEY,
 name TEXT NOT NULL,
-data jsonb);`
+data jsonb);`,
+		Down: `DROP TABLE IF EXISTS tests;`,
+	},
+	{
+		Version: 2,
+		Name:    "create_load_tests",
+		Up: `CREATE TABLE IF NOT EXISTS load_tests (
+name TEXT PRIMARY KEY,
+data jsonb NOT NULL);`,
+		Down: `DROP TABLE IF EXISTS load_tests;`,
+	},
+	{
+		Version: 3,
+		Name:    "add_run_columns",
+		Up: `ALTER TABLE tests
+ADD COLUMN started_at TIMESTAMPTZ,
+ADD COLUMN ended_at TIMESTAMPTZ,
+ADD COLUMN rate DOUBLE PRECISION,
+ADD COLUMN duration BIGINT,
+ADD COLUMN latency_mean BIGINT,
+ADD COLUMN latency_p50 BIGINT,
+ADD COLUMN latency_p95 BIGINT,
+ADD COLUMN latency_p99 BIGINT,
+ADD COLUMN latency_max BIGINT,
+ADD COLUMN success DOUBLE PRECISION,
+ADD COLUMN requests BIGINT,
+ADD COLUMN status TEXT NOT NULL DEFAULT 'succeeded';
+UPDATE tests SET
+started_at = (data->>'earliest')::timestamptz,
+ended_at = (data->>'end')::timestamptz,
+latency_mean = (data->'latencies'->>'mean')::bigint,
+latency_p50 = (data->'latencies'->>'50th')::bigint,
+latency_p95 = (data->'latencies'->>'95th')::bigint,
+latency_p99 = (data->'latencies'->>'99th')::bigint,
+latency_max = (data->'latencies'->>'max')::bigint,
+success = (data->>'success')::double precision,
+requests = (data->>'requests')::bigint
+WHERE data ? 'latencies';
+CREATE INDEX tests_name_started_at_idx ON tests (name, started_at);`,
+		Down: `DROP INDEX IF EXISTS tests_name_started_at_idx;
+ALTER TABLE tests
+DROP COLUMN started_at,
+DROP COLUMN ended_at,
+DROP COLUMN rate,
+DROP COLUMN duration,
+DROP COLUMN latency_mean,
+DROP COLUMN latency_p50,
+DROP COLUMN latency_p95,
+DROP COLUMN latency_p99,
+DROP COLUMN latency_max,
+DROP COLUMN success,
+DROP COLUMN requests,
+DROP COLUMN status;`,
+	},
+	{
+		Version: 4,
+		Name:    "add_run_labels",
+		Up: `ALTER TABLE tests ADD COLUMN labels jsonb NOT NULL DEFAULT '{}';
+CREATE INDEX tests_labels_idx ON tests USING GIN (labels);
+CREATE INDEX tests_started_at_idx ON tests (started_at);`,
+		Down: `DROP INDEX IF EXISTS tests_started_at_idx;
+DROP INDEX IF EXISTS tests_labels_idx;
+ALTER TABLE tests DROP COLUMN labels;`,
+	},
+	{
+		Version: 5,
+		Name:    "create_artifacts",
+		Up: `CREATE TABLE IF NOT EXISTS artifacts (
+result_id uuid PRIMARY KEY REFERENCES tests (id) ON DELETE CASCADE,
+format TEXT NOT NULL,
+data bytea NOT NULL,
+created_at TIMESTAMPTZ NOT NULL DEFAULT now());`,
+		Down: `DROP TABLE IF EXISTS artifacts;`,
+	},
+}
+
+// resultColumns are the columns selected for a stored result, in the order
+// scanned by scanResult
+const resultColumns string = `id, name, started_at, ended_at, COALESCE(rate, 0), COALESCE(duration, 0),
+COALESCE(latency_mean, 0), COALESCE(latency_p50, 0), COALESCE(latency_p95, 0), COALESCE(latency_p99, 0), COALESCE(latency_max, 0),
+COALESCE(success, 0), COALESCE(requests, 0), status, labels, data`
+
+// postgresSortColumns are the sql expressions results are sorted on for each
+// sort field
+var postgresSortColumns = map[string]string{
+	SortStartedAt:   "COALESCE(started_at, 'epoch'::timestamptz)",
+	SortLatencyMean: "COALESCE(latency_mean, 0)",
+	SortLatencyP50:  "COALESCE(latency_p50, 0)",
+	SortLatencyP95:  "COALESCE(latency_p95, 0)",
+	SortLatencyP99:  "COALESCE(latency_p99, 0)",
+	SortLatencyMax:  "COALESCE(latency_max, 0)",
+}
+
+// createMigrationsTableQuery creates the table recording applied migrations
+const createMigrationsTableQuery string = `CREATE TABLE IF NOT EXISTS schema_migrations (
+version INTEGER PRIMARY KEY,
+name TEXT NOT NULL,
+applied_at TIMESTAMPTZ NOT NULL DEFAULT now());`
+
+// sqliteMigrations are the versioned schema changes of the sqlite backend.
+// Times are stored as unix nanoseconds and labels as a json object.
+var sqliteMigrations = []Migration{
+	{
+		Version: 1,
+		Name:    "create_schema",
+		Up: `CREATE TABLE IF NOT EXISTS tests (
+id TEXT PRIMARY KEY,
+name TEXT NOT NULL,
+started_at INTEGER,
+ended_at INTEGER,
+rate REAL NOT NULL DEFAULT 0,
+duration INTEGER NOT NULL DEFAULT 0,
+latency_mean INTEGER NOT NULL DEFAULT 0,
+latency_p50 INTEGER NOT NULL DEFAULT 0,
+latency_p95 INTEGER NOT NULL DEFAULT 0,
+latency_p99 INTEGER NOT NULL DEFAULT 0,
+latency_max INTEGER NOT NULL DEFAULT 0,
+success REAL NOT NULL DEFAULT 0,
+requests INTEGER NOT NULL DEFAULT 0,
+status TEXT NOT NULL DEFAULT 'succeeded',
+labels TEXT NOT NULL DEFAULT '{}',
+data BLOB);
+CREATE INDEX tests_name_started_at_idx ON tests (name, started_at);
+CREATE INDEX tests_started_at_idx ON tests (started_at);
+CREATE TABLE IF NOT EXISTS load_tests (
+name TEXT PRIMARY KEY,
+data BLOB NOT NULL);`,
+		Down: `DROP TABLE IF EXISTS load_tests;
+DROP TABLE IF EXISTS tests;`,
+	},
+	{
+		Version: 2,
+		Name:    "create_artifacts",
+		Up: `CREATE TABLE IF NOT EXISTS artifacts (
+result_id TEXT PRIMARY KEY REFERENCES tests (id) ON DELETE CASCADE,
+format TEXT NOT NULL,
+data BLOB NOT NULL,
+created_at INTEGER NOT NULL);`,
+		Down: `DROP TABLE IF EXISTS artifacts;`,
+	},
+}
+
+// sqliteResultColumns are the columns selected for a stored result, in the
+// order scanned by scanSQLiteResult
+const sqliteResultColumns string = `id, name, started_at, ended_at, rate, duration,
+latency_mean, latency_p50, latency_p95, latency_p99, latency_max,
+success, requests, status, labels, data`
+
+// sqliteSortColumns are the sql expressions results are sorted on for each
+// sort field
+var sqliteSortColumns = map[string]string{
+	SortStartedAt:   "COALESCE(started_at, 0)",
+	SortLatencyMean: "latency_mean",
+	SortLatencyP50:  "latency_p50",
+	SortLatencyP95:  "latency_p95",
+	SortLatencyP99:  "latency_p99",
+	SortLatencyMax:  "latency_max",
+}
+
+// createSQLiteMigrationsTableQuery creates the table recording applied
+// migrations
+const createSQLiteMigrationsTableQuery string = `CREATE TABLE IF NOT EXISTS schema_migrations (
+version INTEGER PRIMARY KEY,
+name TEXT NOT NULL,
+applied_at INTEGER NOT NULL);`
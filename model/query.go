@@ -0,0 +1,115 @@
+package model
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrInvalidQuery is returned by Storage when a result query cannot be run.
+var ErrInvalidQuery = errors.New("invalid query")
+
+// Sort fields of a result query.
+const (
+	SortStartedAt   = "startedAt"
+	SortLatencyMean = "latencyMean"
+	SortLatencyP50  = "latencyP50"
+	SortLatencyP95  = "latencyP95"
+	SortLatencyP99  = "latencyP99"
+	SortLatencyMax  = "latencyMax"
+)
+
+// sortValues return the value of each sort field for a result. Results
+// without a start time sort as the unix epoch.
+var sortValues = map[string]func(Payload) int64{
+	SortStartedAt: func(p Payload) int64 {
+		if p.StartedAt == nil {
+			return 0
+		}
+		return p.StartedAt.UnixNano()
+	},
+	SortLatencyMean: func(p Payload) int64 { return int64(p.LatencyMean) },
+	SortLatencyP50:  func(p Payload) int64 { return int64(p.LatencyP50) },
+	SortLatencyP95:  func(p Payload) int64 { return int64(p.LatencyP95) },
+	SortLatencyP99:  func(p Payload) int64 { return int64(p.LatencyP99) },
+	SortLatencyMax:  func(p Payload) int64 { return int64(p.LatencyMax) },
+}
+
+// ResultQuery filters, sorts and pages stored results. Zero values leave a
+// filter out. Pages are continued with the cursor returned alongside the
+// previous page.
+type ResultQuery struct {
+	Name       string
+	Since      time.Time // inclusive
+	Until      time.Time // exclusive
+	Labels     map[string]string
+	SortBy     string // defaults to SortStartedAt
+	Descending bool
+	Limit      int
+	Offset     int
+	Cursor     string
+}
+
+// Validate reports whether the query can be run, filling in defaults.
+func (q *ResultQuery) Validate() error {
+	if q.SortBy == "" {
+		q.SortBy = SortStartedAt
+	}
+	if _, ok := sortValues[q.SortBy]; !ok {
+		return fmt.Errorf("%w: unknown sort field %q", ErrInvalidQuery, q.SortBy)
+	}
+	if q.Limit < 1 {
+		return fmt.Errorf("%w: limit must be greater than zero", ErrInvalidQuery)
+	}
+	if q.Offset < 0 {
+		return fmt.Errorf("%w: offset must not be negative", ErrInvalidQuery)
+	}
+	if q.Cursor != "" {
+		c, err := decodeCursor(q.Cursor)
+		if err != nil {
+			return err
+		}
+		if c.SortBy != q.SortBy || c.Descending != q.Descending {
+			return fmt.Errorf("%w: cursor continues a query sorted by %s, %s", ErrInvalidQuery, c.SortBy, order(c.Descending))
+		}
+	}
+	return nil
+}
+
+// cursor is the position after which the next page of a query starts: the
+// sort value and id of the last result of the previous page. The sort of the
+// query is kept along, as the position means nothing in another order.
+type cursor struct {
+	Value      int64  `json:"v"`
+	ID         string `json:"id"`
+	SortBy     string `json:"s"`
+	Descending bool   `json:"d,omitempty"`
+}
+
+// nextCursor returns the cursor continuing after result.
+func (q ResultQuery) nextCursor(result Payload) string {
+	b, _ := json.Marshal(cursor{Value: sortValues[q.SortBy](result), ID: result.ID, SortBy: q.SortBy, Descending: q.Descending})
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// order names a sort direction.
+func order(descending bool) string {
+	if descending {
+		return "descending"
+	}
+	return "ascending"
+}
+
+func decodeCursor(s string) (cursor, error) {
+	var c cursor
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err == nil {
+		err = json.Unmarshal(b, &c)
+	}
+	if err != nil || c.ID == "" || c.SortBy == "" {
+		return c, fmt.Errorf("%w: malformed cursor", ErrInvalidQuery)
+	}
+	return c, nil
+}
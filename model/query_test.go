@@ -0,0 +1,79 @@
+package model
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestResultQuery_Validate(t *testing.T) {
+	tests := map[string]struct {
+		query   ResultQuery
+		wantErr bool
+	}{
+		"defaults":       {query: ResultQuery{Limit: 10}},
+		"latency sort":   {query: ResultQuery{Limit: 10, SortBy: SortLatencyP99}},
+		"unknown sort":   {query: ResultQuery{Limit: 10, SortBy: "name"}, wantErr: true},
+		"no limit":       {query: ResultQuery{}, wantErr: true},
+		"negative start": {query: ResultQuery{Limit: 10, Offset: -1}, wantErr: true},
+		"bad cursor":     {query: ResultQuery{Limit: 10, Cursor: "nope"}, wantErr: true},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			err := test.query.Validate()
+			if test.wantErr {
+				assert.True(t, errors.Is(err, ErrInvalidQuery), "error: %v", err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.NotEmpty(t, test.query.SortBy)
+		})
+	}
+}
+
+func TestResultQuery_Cursor(t *testing.T) {
+	started := time.Unix(0, 1234567890)
+	result := Payload{ID: "id", StartedAt: &started, LatencyP99: time.Second}
+
+	for field, want := range map[string]int64{
+		SortStartedAt:  started.UnixNano(),
+		SortLatencyP99: int64(time.Second),
+	} {
+		q := ResultQuery{SortBy: field}
+		c, err := decodeCursor(q.nextCursor(result))
+		assert.NoError(t, err)
+		assert.Equal(t, cursor{Value: want, ID: "id", SortBy: field}, c)
+	}
+
+	q := ResultQuery{SortBy: SortStartedAt}
+	c, err := decodeCursor(q.nextCursor(Payload{ID: "legacy"}))
+	assert.NoError(t, err)
+	assert.Equal(t, int64(0), c.Value)
+}
+
+func TestResultQuery_ValidateCursor(t *testing.T) {
+	result := Payload{ID: "id", LatencyP99: time.Second}
+	next := ResultQuery{SortBy: SortLatencyP99, Descending: true}.nextCursor(result)
+
+	tests := map[string]struct {
+		query   ResultQuery
+		wantErr bool
+	}{
+		"same sort":       {query: ResultQuery{Limit: 10, SortBy: SortLatencyP99, Descending: true, Cursor: next}},
+		"other field":     {query: ResultQuery{Limit: 10, SortBy: SortLatencyMax, Descending: true, Cursor: next}, wantErr: true},
+		"default field":   {query: ResultQuery{Limit: 10, Descending: true, Cursor: next}, wantErr: true},
+		"other direction": {query: ResultQuery{Limit: 10, SortBy: SortLatencyP99, Cursor: next}, wantErr: true},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			err := test.query.Validate()
+			if test.wantErr {
+				assert.True(t, errors.Is(err, ErrInvalidQuery), "error: %v", err)
+				return
+			}
+			assert.NoError(t, err)
+		})
+	}
+}
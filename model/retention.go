@@ -0,0 +1,190 @@
+package model
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+
+	uuid "github.com/satori/go.uuid"
+
+	"github.com/javking07/toadlester/conf"
+)
+
+// aggregateNamespace namespaces the ids of aggregates, which are derived from
+// the test, bucket and resolution so that compacting twice merges into the
+// same aggregate.
+var aggregateNamespace = uuid.NewV5(uuid.NamespaceURL, "https://github.com/javking07/toadlester/aggregates")
+
+// Compaction is a retention rule applied to the results of one test at a
+// point in time.
+type Compaction struct {
+	Name string
+	// RawBefore is when runs must have started to be compacted.
+	RawBefore time.Time
+	// Resolution is the width of the aggregates runs are rolled up into.
+	// Zero deletes runs without rolling them up.
+	Resolution time.Duration
+	// AggregateBefore is when aggregates must have started to be deleted.
+	// Zero keeps aggregates forever.
+	AggregateBefore time.Time
+}
+
+// CompactionResult counts the results a compaction changed.
+type CompactionResult struct {
+	Compacted  int64 `json:"compacted"`  // runs rolled up or deleted
+	Aggregated int64 `json:"aggregated"` // aggregates written
+	Expired    int64 `json:"expired"`    // aggregates deleted
+}
+
+// NewCompaction returns the compaction of the results of test under rule.
+func NewCompaction(test string, rule conf.RetentionConfig, now time.Time) Compaction {
+	c := Compaction{
+		Name:       test,
+		RawBefore:  now.Add(-rule.Raw.Duration),
+		Resolution: conf.DefaultResolution,
+	}
+	if rule.Resolution != nil {
+		c.Resolution = rule.Resolution.Duration
+	}
+	if rule.Aggregate != nil {
+		if rule.Aggregate.Duration == 0 {
+			c.Resolution = 0
+		} else {
+			c.AggregateBefore = now.Add(-rule.Aggregate.Duration)
+		}
+	}
+	return c
+}
+
+// aggregateData is the data stored with an aggregate.
+type aggregateData struct {
+	Runs       int           `json:"runs"`
+	Failed     int           `json:"failed"`
+	Skipped    int           `json:"skipped,omitempty"`
+	Resolution time.Duration `json:"resolution"`
+}
+
+// aggregateID returns the id of the aggregate that run is rolled up into.
+func (c Compaction) aggregateID(run Payload) string {
+	bucket := run.StartedAt.Truncate(c.Resolution)
+	return uuid.NewV5(aggregateNamespace, fmt.Sprintf("%s/%d/%d", c.Name, bucket.UnixNano(), c.Resolution)).String()
+}
+
+// aggregateIDs returns the ids of the aggregates runs are rolled up into.
+func (c Compaction) aggregateIDs(runs []Payload) []string {
+	seen := make(map[string]bool)
+	ids := []string{}
+	for _, run := range runs {
+		if id := c.aggregateID(run); !seen[id] {
+			seen[id] = true
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+// rollUp merges runs into one aggregate per bucket, on top of the existing
+// aggregates with the same ids. Runs without a start time are skipped.
+func (c Compaction) rollUp(runs []Payload, existing map[string]Payload) ([]Payload, error) {
+	aggregates := make(map[string]Payload)
+	for id, aggregate := range existing {
+		aggregates[id] = aggregate
+	}
+	for _, run := range runs {
+		if run.StartedAt == nil {
+			continue
+		}
+		id := c.aggregateID(run)
+		aggregate, ok := aggregates[id]
+		if !ok {
+			started := run.StartedAt.Truncate(c.Resolution).UTC()
+			ended := started.Add(c.Resolution)
+			aggregate = Payload{
+				ID:        id,
+				Name:      c.Name,
+				StartedAt: &started,
+				EndedAt:   &ended,
+				Status:    StatusAggregated,
+				Labels:    run.Labels,
+			}
+		}
+		merged, err := mergeResult(aggregate, run, c.Resolution)
+		if err != nil {
+			return nil, err
+		}
+		aggregates[id] = merged
+	}
+
+	rolledUp := make([]Payload, 0, len(aggregates))
+	for _, aggregate := range aggregates {
+		rolledUp = append(rolledUp, aggregate)
+	}
+	sort.Slice(rolledUp, func(i, j int) bool { return rolledUp[i].StartedAt.Before(*rolledUp[j].StartedAt) })
+	return rolledUp, nil
+}
+
+// mergeResult merges run into aggregate. Means and percentiles are weighted
+// by request count, so merged percentiles are an approximation; the maximum
+// is exact.
+func mergeResult(aggregate, run Payload, resolution time.Duration) (Payload, error) {
+	var data aggregateData
+	if len(aggregate.Data) > 0 {
+		if err := json.Unmarshal(aggregate.Data, &data); err != nil {
+			return aggregate, fmt.Errorf("invalid aggregate %s: %v", aggregate.ID, err)
+		}
+	}
+
+	data.Resolution = resolution
+	if run.Status == StatusSkipped {
+		// skipped runs sent no requests and only count towards the total
+		// of skipped runs
+		data.Skipped++
+		var err error
+		aggregate.Data, err = json.Marshal(data)
+		return aggregate, err
+	}
+
+	total := aggregate.Requests + run.Requests
+	weigh := func(a, r float64) float64 {
+		if total == 0 {
+			return 0
+		}
+		return (a*float64(aggregate.Requests) + r*float64(run.Requests)) / float64(total)
+	}
+	latency := func(a, r time.Duration) time.Duration {
+		return time.Duration(weigh(float64(a), float64(r)))
+	}
+
+	aggregate.Rate = (aggregate.Rate*float64(data.Runs) + run.Rate) / float64(data.Runs+1)
+	aggregate.Duration += run.Duration
+	aggregate.LatencyMean = latency(aggregate.LatencyMean, run.LatencyMean)
+	aggregate.LatencyP50 = latency(aggregate.LatencyP50, run.LatencyP50)
+	aggregate.LatencyP95 = latency(aggregate.LatencyP95, run.LatencyP95)
+	aggregate.LatencyP99 = latency(aggregate.LatencyP99, run.LatencyP99)
+	if run.LatencyMax > aggregate.LatencyMax {
+		aggregate.LatencyMax = run.LatencyMax
+	}
+	aggregate.Success = weigh(aggregate.Success, run.Success)
+	aggregate.Requests = total
+	aggregate.Labels = commonLabels(aggregate.Labels, run.Labels)
+
+	data.Runs++
+	if run.Status == StatusFailed {
+		data.Failed++
+	}
+	var err error
+	aggregate.Data, err = json.Marshal(data)
+	return aggregate, err
+}
+
+// commonLabels returns the labels a and b have in common.
+func commonLabels(a, b map[string]string) map[string]string {
+	common := make(map[string]string)
+	for k, v := range a {
+		if bv, ok := b[k]; ok && bv == v {
+			common[k] = v
+		}
+	}
+	return common
+}
@@ -0,0 +1,492 @@
+package model
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/rs/zerolog/log"
+
+	"github.com/javking07/toadlester/conf"
+)
+
+// defaultSQLitePath is the database file used when no path is configured.
+const defaultSQLitePath = "toadlester.db"
+
+func init() {
+	open := func(ctx context.Context, config *conf.DatabaseConfig) (Storage, error) {
+		return ConnectSQLite(ctx, config)
+	}
+	RegisterBackend("sqlite", open)
+	RegisterBackend("sqlite3", open)
+}
+
+// SQLiteStorage keeps results and test definitions in a single sqlite file,
+// for running without a database server.
+type SQLiteStorage struct {
+	db   *sql.DB
+	path string
+}
+
+// ErrSQLiteUnavailable is returned by ConnectSQLite in builds without cgo,
+// which the sqlite driver needs.
+var ErrSQLiteUnavailable = errors.New("the sqlite backend needs a build with cgo enabled (CGO_ENABLED=1)")
+
+// ConnectSQLite opens the sqlite database file at config.Path, creating it if
+// needed, without touching the schema.
+func ConnectSQLite(ctx context.Context, config *conf.DatabaseConfig) (SQLiteStorage, error) {
+	if !sqliteBuilt {
+		return SQLiteStorage{}, ErrSQLiteUnavailable
+	}
+	path := config.Path
+	if path == "" {
+		path = defaultSQLitePath
+	}
+
+	// writers wait on each other instead of failing with SQLITE_BUSY, and
+	// transactions take the write lock up front so they cannot deadlock
+	params := url.Values{}
+	params.Set("_busy_timeout", "5000")
+	params.Set("_foreign_keys", "on")
+	params.Set("_txlock", "immediate")
+	dsn := "file:" + path + "?" + params.Encode()
+
+	log.Info().Msgf("opening sqlite database %s", path)
+	db, err := sql.Open("sqlite3", dsn)
+	if err != nil {
+		return SQLiteStorage{}, err
+	}
+	// a single connection serialises access, which sqlite needs anyway and
+	// which keeps in-memory databases from being opened once per connection
+	db.SetMaxOpenConns(1)
+	if err := db.PingContext(ctx); err != nil {
+		db.Close()
+		return SQLiteStorage{}, err
+	}
+	return SQLiteStorage{db, path}, nil
+}
+
+// Init brings the schema up to date by applying pending migrations.
+func (s SQLiteStorage) Init(ctx context.Context) error {
+	applied, err := s.MigrateUp(ctx)
+	if err != nil {
+		return err
+	}
+	log.Info().Msgf("applied %d migrations", applied)
+	return nil
+}
+
+func (s SQLiteStorage) Insert(ctx context.Context, payload Payload) (int64, error) {
+	result, err := insertSQLiteResult(ctx, s.db, payload)
+	if err != nil {
+		return 0, sqliteError(err)
+	}
+	return result.RowsAffected()
+}
+
+// sqliteQuerier is satisfied by both the database and a transaction.
+type sqliteQuerier interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+}
+
+// insertSQLiteResult inserts a result through db, which may be a transaction.
+func insertSQLiteResult(ctx context.Context, db sqliteQuerier, payload Payload) (sql.Result, error) {
+	if payload.Status == "" {
+		payload.Status = StatusSucceeded
+	}
+	labels, err := marshalLabels(payload.Labels)
+	if err != nil {
+		return nil, err
+	}
+	query := `INSERT INTO tests (id, name, started_at, ended_at, rate, duration,
+latency_mean, latency_p50, latency_p95, latency_p99, latency_max, success, requests, status, labels, data)
+VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+	return db.ExecContext(ctx, query, payload.ID, payload.Name, unixNano(payload.StartedAt), unixNano(payload.EndedAt),
+		payload.Rate, int64(payload.Duration), int64(payload.LatencyMean), int64(payload.LatencyP50), int64(payload.LatencyP95),
+		int64(payload.LatencyP99), int64(payload.LatencyMax), payload.Success, int64(payload.Requests), payload.Status, labels, []byte(payload.Data))
+}
+
+func (s SQLiteStorage) Select(ctx context.Context, itemId string) (Payload, error) {
+	row := s.db.QueryRowContext(ctx, "SELECT "+sqliteResultColumns+" FROM tests WHERE id=?", itemId)
+	item, err := scanSQLiteResult(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return Payload{}, ErrNotFound
+	}
+	return item, err
+}
+
+func (s SQLiteStorage) SelectAll(ctx context.Context, count, start int) ([]Payload, error) {
+	rows, err := s.db.QueryContext(ctx, "SELECT "+sqliteResultColumns+" FROM tests ORDER BY started_at IS NULL, started_at DESC, id LIMIT ? OFFSET ?", count, start)
+	if err != nil {
+		return nil, err
+	}
+	return scanSQLiteResults(rows)
+}
+
+func (s SQLiteStorage) Query(ctx context.Context, q ResultQuery) ([]Payload, string, error) {
+	if err := q.Validate(); err != nil {
+		return nil, "", err
+	}
+
+	var where []string
+	var args []interface{}
+	if q.Name != "" {
+		where = append(where, "name = ?")
+		args = append(args, q.Name)
+	}
+	if !q.Since.IsZero() {
+		where = append(where, "started_at >= ?")
+		args = append(args, q.Since.UnixNano())
+	}
+	if !q.Until.IsZero() {
+		where = append(where, "started_at < ?")
+		args = append(args, q.Until.UnixNano())
+	}
+	for k, v := range q.Labels {
+		where = append(where, "EXISTS (SELECT 1 FROM json_each(tests.labels) WHERE key = ? AND value = ?)")
+		args = append(args, k, v)
+	}
+
+	column, order, cmp := sqliteSortColumns[q.SortBy], "ASC", ">"
+	if q.Descending {
+		order, cmp = "DESC", "<"
+	}
+	if q.Cursor != "" {
+		c, _ := decodeCursor(q.Cursor)
+		where = append(where, fmt.Sprintf("(%s, id) %s (?, ?)", column, cmp))
+		args = append(args, c.Value, c.ID)
+	}
+
+	query := "SELECT " + sqliteResultColumns + " FROM tests"
+	if len(where) > 0 {
+		query += " WHERE " + strings.Join(where, " AND ")
+	}
+	// fetch one extra row to learn whether there is a next page
+	query += fmt.Sprintf(" ORDER BY %s %s, id %s LIMIT ? OFFSET ?", column, order, order)
+	args = append(args, q.Limit+1, q.Offset)
+
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, "", err
+	}
+	payload, err := scanSQLiteResults(rows)
+	if err != nil {
+		return nil, "", err
+	}
+
+	var next string
+	if len(payload) > q.Limit {
+		payload = payload[:q.Limit]
+		next = q.nextCursor(payload[len(payload)-1])
+	}
+	return payload, next, nil
+}
+
+func (s SQLiteStorage) ResultNames(ctx context.Context) ([]string, error) {
+	rows, err := s.db.QueryContext(ctx, "SELECT DISTINCT name FROM tests ORDER BY name")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	names := []string{}
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		names = append(names, name)
+	}
+	return names, rows.Err()
+}
+
+func (s SQLiteStorage) Update(ctx context.Context, id string, payload Payload) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	item, err := scanSQLiteResult(tx.QueryRowContext(ctx, "SELECT "+sqliteResultColumns+" FROM tests WHERE id=?", id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrNotFound
+	} else if err != nil {
+		return err
+	}
+	if item, err = item.updated(payload); err != nil {
+		return err
+	}
+	query := `UPDATE tests SET name=?, started_at=?, ended_at=?, latency_mean=?, latency_p50=?, latency_p95=?,
+latency_p99=?, latency_max=?, success=?, requests=?, data=? WHERE id=?`
+	if _, err := tx.ExecContext(ctx, query, item.Name, unixNano(item.StartedAt), unixNano(item.EndedAt), int64(item.LatencyMean),
+		int64(item.LatencyP50), int64(item.LatencyP95), int64(item.LatencyP99), int64(item.LatencyMax), item.Success,
+		int64(item.Requests), []byte(item.Data), id); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s SQLiteStorage) Delete(ctx context.Context, id string) error {
+	result, err := s.db.ExecContext(ctx, "DELETE FROM tests WHERE id=?", id)
+	if err != nil {
+		return err
+	}
+	return expectAffected(result)
+}
+
+func (s SQLiteStorage) Healthy(ctx context.Context) error {
+	return s.db.PingContext(ctx)
+}
+
+func (s SQLiteStorage) Purge(ctx context.Context, table string) error {
+	if _, err := s.db.ExecContext(ctx, fmt.Sprintf("DELETE FROM %s", table)); err != nil {
+		return fmt.Errorf("Error purging %s table: %v", table, err)
+	}
+	log.Info().Msgf("Purging %s table", table)
+	return nil
+}
+
+func (s SQLiteStorage) InsertTest(ctx context.Context, test conf.TestConfig) error {
+	data, err := json.Marshal(test)
+	if err != nil {
+		return err
+	}
+	_, err = s.db.ExecContext(ctx, "INSERT INTO load_tests (name, data) VALUES (?, ?)", test.Name, data)
+	return sqliteError(err)
+}
+
+func (s SQLiteStorage) SelectTest(ctx context.Context, name string) (conf.TestConfig, error) {
+	var test conf.TestConfig
+	var data []byte
+	err := s.db.QueryRowContext(ctx, "SELECT data FROM load_tests WHERE name=?", name).Scan(&data)
+	if errors.Is(err, sql.ErrNoRows) {
+		return test, ErrNotFound
+	} else if err != nil {
+		return test, err
+	}
+	err = json.Unmarshal(data, &test)
+	return test, err
+}
+
+func (s SQLiteStorage) SelectTests(ctx context.Context) ([]conf.TestConfig, error) {
+	rows, err := s.db.QueryContext(ctx, "SELECT data FROM load_tests ORDER BY name")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tests := []conf.TestConfig{}
+	for rows.Next() {
+		var data []byte
+		if err := rows.Scan(&data); err != nil {
+			return nil, err
+		}
+		var test conf.TestConfig
+		if err := json.Unmarshal(data, &test); err != nil {
+			return nil, err
+		}
+		tests = append(tests, test)
+	}
+	return tests, rows.Err()
+}
+
+func (s SQLiteStorage) UpdateTest(ctx context.Context, name string, test conf.TestConfig) error {
+	data, err := json.Marshal(test)
+	if err != nil {
+		return err
+	}
+	result, err := s.db.ExecContext(ctx, "UPDATE load_tests SET name=?, data=? WHERE name=?", test.Name, data, name)
+	if err != nil {
+		return sqliteError(err)
+	}
+	return expectAffected(result)
+}
+
+func (s SQLiteStorage) DeleteTest(ctx context.Context, name string) error {
+	result, err := s.db.ExecContext(ctx, "DELETE FROM load_tests WHERE name=?", name)
+	if err != nil {
+		return err
+	}
+	return expectAffected(result)
+}
+
+// Compact rolls runs up and deletes them in a single transaction, so that a
+// failed compaction leaves results untouched.
+func (s SQLiteStorage) Compact(ctx context.Context, c Compaction) (CompactionResult, error) {
+	var result CompactionResult
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return result, err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	if c.Resolution > 0 {
+		rows, err := tx.QueryContext(ctx, "SELECT "+sqliteResultColumns+" FROM tests WHERE name=? AND status<>? AND started_at<?",
+			c.Name, StatusAggregated, c.RawBefore.UnixNano())
+		if err != nil {
+			return result, err
+		}
+		runs, err := scanSQLiteResults(rows)
+		if err != nil {
+			return result, err
+		}
+
+		existing := make(map[string]Payload)
+		for _, id := range c.aggregateIDs(runs) {
+			rows, err := tx.QueryContext(ctx, "SELECT "+sqliteResultColumns+" FROM tests WHERE id=?", id)
+			if err != nil {
+				return result, err
+			}
+			stored, err := scanSQLiteResults(rows)
+			if err != nil {
+				return result, err
+			}
+			for _, aggregate := range stored {
+				existing[aggregate.ID] = aggregate
+			}
+		}
+		aggregates, err := c.rollUp(runs, existing)
+		if err != nil {
+			return result, err
+		}
+		for _, aggregate := range aggregates {
+			if _, err := tx.ExecContext(ctx, "DELETE FROM tests WHERE id=?", aggregate.ID); err != nil {
+				return result, err
+			}
+			if _, err := insertSQLiteResult(ctx, tx, aggregate); err != nil {
+				return result, err
+			}
+		}
+		result.Aggregated = int64(len(aggregates))
+	}
+
+	deleted, err := tx.ExecContext(ctx, "DELETE FROM tests WHERE name=? AND status<>? AND started_at<?", c.Name, StatusAggregated, c.RawBefore.UnixNano())
+	if err != nil {
+		return result, err
+	}
+	if result.Compacted, err = deleted.RowsAffected(); err != nil {
+		return result, err
+	}
+
+	if !c.AggregateBefore.IsZero() {
+		expired, err := tx.ExecContext(ctx, "DELETE FROM tests WHERE name=? AND status=? AND started_at<?", c.Name, StatusAggregated, c.AggregateBefore.UnixNano())
+		if err != nil {
+			return result, err
+		}
+		if result.Expired, err = expired.RowsAffected(); err != nil {
+			return result, err
+		}
+	}
+	return result, tx.Commit()
+}
+
+func (s SQLiteStorage) InsertArtifact(ctx context.Context, artifact Artifact) error {
+	_, err := s.db.ExecContext(ctx, "INSERT INTO artifacts (result_id, format, data, created_at) VALUES (?, ?, ?, ?)",
+		artifact.ResultID, artifact.Format, artifact.Data, time.Now().UnixNano())
+	return sqliteError(err)
+}
+
+func (s SQLiteStorage) SelectArtifact(ctx context.Context, resultID string) (Artifact, error) {
+	artifact := Artifact{ResultID: resultID}
+	var created int64
+	err := s.db.QueryRowContext(ctx, "SELECT format, data, created_at FROM artifacts WHERE result_id=?", resultID).
+		Scan(&artifact.Format, &artifact.Data, &created)
+	if errors.Is(err, sql.ErrNoRows) {
+		return artifact, ErrNotFound
+	}
+	artifact.CreatedAt = time.Unix(0, created).UTC()
+	return artifact, err
+}
+
+// Close closes the database file.
+func (s SQLiteStorage) Close() error {
+	return s.db.Close()
+}
+
+// scanSQLiteResult scans a row of sqliteResultColumns.
+func scanSQLiteResult(row interface{ Scan(...interface{}) error }) (Payload, error) {
+	var item Payload
+	var started, ended sql.NullInt64
+	var duration, mean, p50, p95, p99, max, requests int64
+	var labels string
+	var data []byte
+	err := row.Scan(&item.ID, &item.Name, &started, &ended, &item.Rate, &duration,
+		&mean, &p50, &p95, &p99, &max, &item.Success, &requests, &item.Status, &labels, &data)
+	if err != nil {
+		return item, err
+	}
+	item.StartedAt = fromUnixNano(started)
+	item.EndedAt = fromUnixNano(ended)
+	item.Duration = time.Duration(duration)
+	item.LatencyMean = time.Duration(mean)
+	item.LatencyP50 = time.Duration(p50)
+	item.LatencyP95 = time.Duration(p95)
+	item.LatencyP99 = time.Duration(p99)
+	item.LatencyMax = time.Duration(max)
+	item.Requests = uint64(requests)
+	if data != nil {
+		item.Data = json.RawMessage(data)
+	}
+	err = json.Unmarshal([]byte(labels), &item.Labels)
+	return item, err
+}
+
+// scanSQLiteResults scans and closes rows of sqliteResultColumns.
+func scanSQLiteResults(rows *sql.Rows) ([]Payload, error) {
+	defer rows.Close()
+
+	payload := []Payload{}
+	for rows.Next() {
+		item, err := scanSQLiteResult(rows)
+		if err != nil {
+			return nil, err
+		}
+		payload = append(payload, item)
+	}
+	return payload, rows.Err()
+}
+
+// marshalLabels encodes labels for a sqlite text column, storing no labels as
+// an empty object.
+func marshalLabels(labels map[string]string) (string, error) {
+	if labels == nil {
+		return "{}", nil
+	}
+	b, err := json.Marshal(labels)
+	return string(b), err
+}
+
+// unixNano converts an optional time to the nanosecond integer sqlite stores.
+func unixNano(t *time.Time) interface{} {
+	if t == nil {
+		return nil
+	}
+	return t.UnixNano()
+}
+
+func fromUnixNano(n sql.NullInt64) *time.Time {
+	if !n.Valid {
+		return nil
+	}
+	t := time.Unix(0, n.Int64).UTC()
+	return &t
+}
+
+// expectAffected returns ErrNotFound when result affected no rows.
+func expectAffected(result sql.Result) error {
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
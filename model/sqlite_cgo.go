@@ -0,0 +1,26 @@
+//go:build cgo
+// +build cgo
+
+package model
+
+import (
+	"errors"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+// sqliteBuilt reports whether the sqlite driver is part of the build. It
+// needs cgo; without it the driver is a stub that fails every connection.
+const sqliteBuilt = true
+
+// sqliteError maps sqlite errors onto storage errors.
+func sqliteError(err error) error {
+	var sqliteErr sqlite3.Error
+	if errors.As(err, &sqliteErr) && sqliteErr.Code == sqlite3.ErrConstraint {
+		if sqliteErr.ExtendedCode == sqlite3.ErrConstraintForeignKey {
+			return ErrNotFound
+		}
+		return ErrConflict
+	}
+	return err
+}
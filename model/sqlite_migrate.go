@@ -0,0 +1,122 @@
+package model
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+func (s SQLiteStorage) MigrateUp(ctx context.Context) (int, error) {
+	if err := s.prepareMigrations(ctx); err != nil {
+		return 0, err
+	}
+	applied, err := s.appliedMigrations(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	count := 0
+	for _, m := range sqliteMigrations {
+		if _, ok := applied[m.Version]; ok {
+			continue
+		}
+		log.Info().Msgf("applying migration %d %s", m.Version, m.Name)
+		if err := s.runMigration(ctx, m.Up, "INSERT INTO schema_migrations (version, name, applied_at) VALUES (?, ?, ?)", m.Version, m.Name, time.Now().UnixNano()); err != nil {
+			return count, fmt.Errorf("error applying migration %d %s: %v", m.Version, m.Name, err)
+		}
+		count++
+	}
+	return count, nil
+}
+
+func (s SQLiteStorage) MigrateDown(ctx context.Context, steps int) (int, error) {
+	if err := s.prepareMigrations(ctx); err != nil {
+		return 0, err
+	}
+	applied, err := s.appliedMigrations(ctx)
+	if err != nil {
+		return 0, err
+	}
+	for version := range applied {
+		if findMigration(sqliteMigrations, version) == nil {
+			return 0, fmt.Errorf("database has unknown migration %d applied", version)
+		}
+	}
+
+	count := 0
+	for i := len(sqliteMigrations) - 1; i >= 0 && count < steps; i-- {
+		m := sqliteMigrations[i]
+		if _, ok := applied[m.Version]; !ok {
+			continue
+		}
+		log.Info().Msgf("reverting migration %d %s", m.Version, m.Name)
+		if err := s.runMigration(ctx, m.Down, "DELETE FROM schema_migrations WHERE version=?", m.Version); err != nil {
+			return count, fmt.Errorf("error reverting migration %d %s: %v", m.Version, m.Name, err)
+		}
+		count++
+	}
+	return count, nil
+}
+
+func (s SQLiteStorage) MigrationStatus(ctx context.Context) ([]MigrationStatus, error) {
+	if err := s.prepareMigrations(ctx); err != nil {
+		return nil, err
+	}
+	applied, err := s.appliedMigrations(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return migrationStatus(sqliteMigrations, applied), nil
+}
+
+// prepareMigrations validates the migrations and creates the migrations
+// table. Unlike postgres no lock is needed: migrations run in immediate
+// transactions, which sqlite already serialises.
+func (s SQLiteStorage) prepareMigrations(ctx context.Context) error {
+	if err := validateMigrations(sqliteMigrations); err != nil {
+		return err
+	}
+	_, err := s.db.ExecContext(ctx, createSQLiteMigrationsTableQuery)
+	return err
+}
+
+// appliedMigrations returns the applied migration versions and when they
+// were applied.
+func (s SQLiteStorage) appliedMigrations(ctx context.Context) (map[int]time.Time, error) {
+	rows, err := s.db.QueryContext(ctx, "SELECT version, applied_at FROM schema_migrations")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	applied := make(map[int]time.Time)
+	for rows.Next() {
+		var version int
+		var at sql.NullInt64
+		if err := rows.Scan(&version, &at); err != nil {
+			return nil, err
+		}
+		applied[version] = time.Unix(0, at.Int64).UTC()
+	}
+	return applied, rows.Err()
+}
+
+// runMigration runs a migration query and records it in a single transaction.
+func (s SQLiteStorage) runMigration(ctx context.Context, query, record string, args ...interface{}) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	if _, err := tx.ExecContext(ctx, query); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, record, args...); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,13 @@
+//go:build !cgo
+// +build !cgo
+
+package model
+
+// sqliteBuilt reports whether the sqlite driver is part of the build. It
+// needs cgo; without it the driver is a stub that fails every connection.
+const sqliteBuilt = false
+
+// sqliteError returns err; without the driver there are no sqlite errors.
+func sqliteError(err error) error {
+	return err
+}
@@ -0,0 +1,51 @@
+package model
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/javking07/toadlester/conf"
+)
+
+func newSQLiteStorage(t *testing.T) SQLiteStorage {
+	t.Helper()
+	if !sqliteBuilt {
+		t.Skip(ErrSQLiteUnavailable)
+	}
+	ctx := context.Background()
+	db, err := OpenStorage(ctx, &conf.DatabaseConfig{Type: "sqlite", Path: filepath.Join(t.TempDir(), "test.db")})
+	require.NoError(t, err)
+	require.NoError(t, db.Init(ctx))
+	t.Cleanup(func() { _ = db.(SQLiteStorage).Close() })
+	return db.(SQLiteStorage)
+}
+
+func TestSQLiteStorage_Migrations(t *testing.T) {
+	ctx := context.Background()
+	db := newSQLiteStorage(t)
+
+	status, err := db.MigrationStatus(ctx)
+	require.NoError(t, err)
+	for _, s := range status {
+		assert.True(t, s.Applied, "migration %d", s.Version)
+	}
+
+	reverted, err := db.MigrateDown(ctx, len(sqliteMigrations))
+	require.NoError(t, err)
+	assert.Equal(t, len(sqliteMigrations), reverted)
+	applied, err := db.MigrateUp(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, len(sqliteMigrations), applied)
+}
+
+func TestConnectSQLite_WithoutCgo(t *testing.T) {
+	if sqliteBuilt {
+		t.Skip("sqlite is built")
+	}
+	_, err := OpenStorage(context.Background(), &conf.DatabaseConfig{Type: "sqlite", Path: filepath.Join(t.TempDir(), "test.db")})
+	assert.ErrorIs(t, err, ErrSQLiteUnavailable)
+}
@@ -0,0 +1,404 @@
+package model
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"testing"
+	"time"
+
+	uuid "github.com/satori/go.uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/javking07/toadlester/conf"
+)
+
+// postgresTestsEnv enables the conformance suite against the postgres of
+// conf.SaneDefaults, as started by docker-compose. Its tables are purged.
+const postgresTestsEnv = "TOADLESTER_POSTGRES_TESTS"
+
+func TestMemoryStorage(t *testing.T) {
+	ctx := context.Background()
+	testStorage(t, func(t *testing.T) Storage {
+		db, err := OpenStorage(ctx, &conf.DatabaseConfig{Type: "memory"})
+		require.NoError(t, err)
+		return db
+	})
+}
+
+func TestSQLiteStorage(t *testing.T) {
+	testStorage(t, func(t *testing.T) Storage {
+		return newSQLiteStorage(t)
+	})
+}
+
+func TestPostgresStorage(t *testing.T) {
+	if os.Getenv(postgresTestsEnv) == "" {
+		t.Skipf("set %s to run against postgres", postgresTestsEnv)
+	}
+	ctx := context.Background()
+	testStorage(t, func(t *testing.T) Storage {
+		db, err := BootstrapPostgres(ctx, conf.SaneDefaults().Database)
+		require.NoError(t, err)
+		t.Cleanup(func() { _ = db.Close() })
+		for _, table := range []string{"tests", "load_tests"} {
+			require.NoError(t, db.Purge(ctx, table))
+		}
+		return db
+	})
+}
+
+// testStorage is the conformance suite every storage backend must pass. open
+// returns an empty, initialised storage.
+func testStorage(t *testing.T, open func(t *testing.T) Storage) {
+	t.Run("Results", func(t *testing.T) { testStorageResults(t, open(t)) })
+	t.Run("SelectAll", func(t *testing.T) { testStorageSelectAll(t, open(t)) })
+	t.Run("ResultNames", func(t *testing.T) { testStorageResultNames(t, open(t)) })
+	t.Run("Query", func(t *testing.T) { testStorageQuery(t, open(t)) })
+	t.Run("Tests", func(t *testing.T) { testStorageTests(t, open(t)) })
+	t.Run("TestCopies", func(t *testing.T) { testStorageTestCopies(t, open(t)) })
+	t.Run("Purge", func(t *testing.T) { testStoragePurge(t, open(t)) })
+	t.Run("Compact", func(t *testing.T) { testStorageCompact(t, open(t)) })
+	t.Run("Artifacts", func(t *testing.T) { testStorageArtifacts(t, open(t)) })
+}
+
+// insertResults stores a result for each start offset in seconds and
+// returns their ids in the same order.
+func insertResults(t *testing.T, db Storage, name string, labels map[string]string, offsets ...int) []string {
+	t.Helper()
+	ctx := context.Background()
+	ids := make([]string, len(offsets))
+	for i, offset := range offsets {
+		started := time.Unix(int64(1600000000+offset), 0).UTC()
+		ids[i] = uuid.NewV4().String()
+		_, err := db.Insert(ctx, Payload{ID: ids[i], Name: name, StartedAt: &started, LatencyP99: time.Duration(offset) * time.Millisecond,
+			Labels: labels, Data: json.RawMessage(`{}`)})
+		require.NoError(t, err)
+	}
+	return ids
+}
+
+func resultIDs(t *testing.T, items []Payload) []string {
+	t.Helper()
+	ids := []string{}
+	for _, item := range items {
+		ids = append(ids, item.ID)
+	}
+	return ids
+}
+
+func testStorageResults(t *testing.T, db Storage) {
+	ctx := context.Background()
+	assert.NoError(t, db.Healthy(ctx))
+
+	started := time.Unix(0, 1000).UTC()
+	ended := started.Add(time.Second)
+	payload := Payload{ID: uuid.NewV4().String(), Name: "test", StartedAt: &started, EndedAt: &ended,
+		Rate: 10, Duration: time.Second, LatencyMean: 1, LatencyP50: 2, LatencyP95: 3, LatencyP99: 4, LatencyMax: 5,
+		Success: 0.5, Requests: 10, Labels: map[string]string{"env": "dev"}, Data: json.RawMessage(`{"requests":10}`)}
+	_, err := db.Insert(ctx, payload)
+	require.NoError(t, err)
+	_, err = db.Insert(ctx, payload)
+	assert.True(t, errors.Is(err, ErrConflict), "error: %v", err)
+
+	item, err := db.Select(ctx, payload.ID)
+	require.NoError(t, err)
+	want := payload
+	want.Status = StatusSucceeded
+	assert.Equal(t, want.StartedAt.UnixNano(), item.StartedAt.UnixNano())
+	assert.Equal(t, want.EndedAt.UnixNano(), item.EndedAt.UnixNano())
+	assert.JSONEq(t, string(want.Data), string(item.Data))
+	results, err := item.Results()
+	require.NoError(t, err)
+	assert.Equal(t, uint64(10), results.Requests)
+	want.StartedAt, want.EndedAt, want.Data = nil, nil, nil
+	item.StartedAt, item.EndedAt, item.Data = nil, nil, nil
+	assert.Equal(t, want, item)
+
+	require.NoError(t, db.Update(ctx, payload.ID, Payload{Name: "renamed", Data: json.RawMessage(`{"requests":20,"success":1,"latencies":{"99th":40,"max":50}}`)}))
+	item, err = db.Select(ctx, payload.ID)
+	require.NoError(t, err)
+	assert.Equal(t, "renamed", item.Name)
+	// the summary follows the new metrics
+	assert.Equal(t, uint64(20), item.Requests)
+	assert.Equal(t, 1.0, item.Success)
+	assert.Equal(t, time.Duration(40), item.LatencyP99)
+	assert.Equal(t, time.Duration(50), item.LatencyMax)
+	assert.Equal(t, time.Duration(0), item.LatencyMean)
+	assert.Equal(t, want.Rate, item.Rate)
+	assert.Equal(t, payload.StartedAt.UnixNano(), item.StartedAt.UnixNano(), "kept without a start in the metrics")
+	items, _, err := db.Query(ctx, ResultQuery{Name: "renamed", SortBy: "latencyP99", Limit: 1})
+	require.NoError(t, err)
+	require.Len(t, items, 1)
+	assert.Equal(t, time.Duration(40), items[0].LatencyP99)
+	assert.True(t, errors.Is(db.Update(ctx, payload.ID, Payload{Name: "renamed", Data: json.RawMessage(`[]`)}), ErrInvalidResult))
+	assert.True(t, errors.Is(db.Update(ctx, uuid.NewV4().String(), Payload{}), ErrNotFound))
+
+	require.NoError(t, db.Delete(ctx, payload.ID))
+	_, err = db.Select(ctx, payload.ID)
+	assert.True(t, errors.Is(err, ErrNotFound), "error: %v", err)
+	assert.True(t, errors.Is(db.Delete(ctx, payload.ID), ErrNotFound))
+}
+
+func testStorageSelectAll(t *testing.T, db Storage) {
+	ctx := context.Background()
+	data, err := db.SelectAll(ctx, 10, 0)
+	require.NoError(t, err)
+	assert.NotNil(t, data)
+	assert.Empty(t, data)
+
+	ids := insertResults(t, db, "test", nil, 1, 3, 2)
+	data, err = db.SelectAll(ctx, 2, 0)
+	require.NoError(t, err)
+	assert.Equal(t, []string{ids[1], ids[2]}, resultIDs(t, data))
+	data, err = db.SelectAll(ctx, 2, 2)
+	require.NoError(t, err)
+	assert.Equal(t, []string{ids[0]}, resultIDs(t, data))
+}
+
+func testStorageResultNames(t *testing.T, db Storage) {
+	ctx := context.Background()
+	names, err := db.ResultNames(ctx)
+	require.NoError(t, err)
+	assert.NotNil(t, names)
+	assert.Empty(t, names)
+
+	insertResults(t, db, "smoke", nil, 1, 2)
+	insertResults(t, db, "ad-hoc", nil, 3)
+	names, err = db.ResultNames(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"ad-hoc", "smoke"}, names)
+}
+
+func testStorageQuery(t *testing.T, db Storage) {
+	ctx := context.Background()
+	dev := insertResults(t, db, "a", map[string]string{"env": "dev"}, 1, 2)
+	prod := insertResults(t, db, "a", map[string]string{"env": "prod", "team": "x"}, 3, 4, 5)
+	other := insertResults(t, db, "b", nil, 6)
+
+	query := func(q ResultQuery) ([]string, string) {
+		data, next, err := db.Query(ctx, q)
+		require.NoError(t, err)
+		return resultIDs(t, data), next
+	}
+
+	ids, next := query(ResultQuery{Limit: 10})
+	assert.Equal(t, append(append(append([]string{}, dev...), prod...), other...), ids)
+	assert.Empty(t, next)
+
+	ids, _ = query(ResultQuery{Name: "b", Limit: 10})
+	assert.Equal(t, other, ids)
+
+	ids, _ = query(ResultQuery{Labels: map[string]string{"env": "prod", "team": "x"}, Limit: 10})
+	assert.Equal(t, prod, ids)
+
+	ids, _ = query(ResultQuery{Since: time.Unix(1600000002, 0), Until: time.Unix(1600000004, 0), Limit: 10})
+	assert.Equal(t, []string{dev[1], prod[0]}, ids)
+
+	ids, _ = query(ResultQuery{SortBy: SortLatencyP99, Descending: true, Limit: 2, Offset: 1})
+	assert.Equal(t, []string{prod[2], prod[1]}, ids)
+
+	// page through with the cursor
+	var paged []string
+	q := ResultQuery{Name: "a", Descending: true, Limit: 2}
+	for i := 0; i < 5; i++ {
+		ids, next := query(q)
+		paged = append(paged, ids...)
+		if next == "" {
+			break
+		}
+		q.Cursor = next
+	}
+	assert.Equal(t, []string{prod[2], prod[1], prod[0], dev[1], dev[0]}, paged)
+
+	_, _, err := db.Query(ctx, ResultQuery{})
+	assert.True(t, errors.Is(err, ErrInvalidQuery), "error: %v", err)
+}
+
+func testStorageTests(t *testing.T, db Storage) {
+	ctx := context.Background()
+	tests, err := db.SelectTests(ctx)
+	require.NoError(t, err)
+	assert.Empty(t, tests)
+
+	test := conf.TestConfig{Name: "test", Duration: conf.NewDuration(time.Second), TPS: 1, Target: "targets.txt",
+		Labels: map[string]string{"env": "dev"}}
+	require.NoError(t, db.InsertTest(ctx, test))
+	assert.True(t, errors.Is(db.InsertTest(ctx, test), ErrConflict))
+	other := conf.TestConfig{Name: "other", Duration: conf.NewDuration(time.Minute), TPS: 2, Target: "other.txt"}
+	require.NoError(t, db.InsertTest(ctx, other))
+
+	got, err := db.SelectTest(ctx, "test")
+	require.NoError(t, err)
+	assert.Equal(t, test, got)
+	_, err = db.SelectTest(ctx, "missing")
+	assert.True(t, errors.Is(err, ErrNotFound))
+
+	test.TPS = 3
+	require.NoError(t, db.UpdateTest(ctx, "test", test))
+	assert.True(t, errors.Is(db.UpdateTest(ctx, "missing", test), ErrNotFound))
+	assert.True(t, errors.Is(db.UpdateTest(ctx, "other", test), ErrConflict))
+
+	tests, err = db.SelectTests(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, []conf.TestConfig{other, test}, tests)
+
+	require.NoError(t, db.DeleteTest(ctx, "test"))
+	_, err = db.SelectTest(ctx, "test")
+	assert.True(t, errors.Is(err, ErrNotFound))
+	assert.True(t, errors.Is(db.DeleteTest(ctx, "test"), ErrNotFound))
+}
+
+func testStorageTestCopies(t *testing.T, db Storage) {
+	ctx := context.Background()
+	newTest := func() conf.TestConfig {
+		return conf.TestConfig{Name: "test", Duration: conf.NewDuration(time.Second), TPS: 1,
+			Targets: []conf.TargetConfig{{URL: "http://localhost/a", Headers: map[string]string{"Accept": "text/plain"}}},
+			Profile: &conf.ProfileConfig{Ramp: &conf.RampConfig{From: 1, To: 10, Segments: 2}}}
+	}
+	test := newTest()
+	require.NoError(t, db.InsertTest(ctx, test))
+
+	// neither the inserted test nor the returned ones share anything with the
+	// stored test
+	test.Targets[0].Headers["Accept"] = "application/json"
+	test.Profile.Ramp.To = 100
+	got, err := db.SelectTest(ctx, "test")
+	require.NoError(t, err)
+	assert.Equal(t, newTest(), got)
+
+	got.Targets[0].Headers["Accept"] = "application/json"
+	got.Targets[0].URL = "http://localhost/b"
+	got.Profile.Ramp.To = 100
+	tests, err := db.SelectTests(ctx)
+	require.NoError(t, err)
+	require.Len(t, tests, 1)
+	tests[0].Profile.Ramp.Segments = 5
+	got, err = db.SelectTest(ctx, "test")
+	require.NoError(t, err)
+	assert.Equal(t, newTest(), got)
+}
+
+func testStoragePurge(t *testing.T, db Storage) {
+	ctx := context.Background()
+	insertResults(t, db, "test", nil, 1, 2)
+	require.NoError(t, db.InsertTest(ctx, conf.TestConfig{Name: "test", Duration: conf.NewDuration(time.Second), TPS: 1, Target: "t"}))
+
+	require.NoError(t, db.Purge(ctx, "tests"))
+	items, err := db.SelectAll(ctx, 10, 0)
+	require.NoError(t, err)
+	assert.Empty(t, items)
+	tests, err := db.SelectTests(ctx)
+	require.NoError(t, err)
+	assert.Len(t, tests, 1)
+
+	require.NoError(t, db.Purge(ctx, "load_tests"))
+	tests, err = db.SelectTests(ctx)
+	require.NoError(t, err)
+	assert.Empty(t, tests)
+}
+
+func testStorageCompact(t *testing.T, db Storage) {
+	ctx := context.Background()
+	now := time.Date(2021, 1, 10, 12, 0, 0, 0, time.UTC)
+	day := 24 * time.Hour
+	insert := func(name string, age time.Duration, requests uint64, p99 time.Duration, status string) string {
+		started := now.Add(-age)
+		payload := Payload{ID: uuid.NewV4().String(), Name: name, StartedAt: &started, Rate: 10, Duration: time.Minute,
+			LatencyP99: p99, LatencyMax: p99, Success: 1, Requests: requests, Status: status,
+			Labels: map[string]string{"env": "prod", "run": started.String()}, Data: json.RawMessage(`{}`)}
+		_, err := db.Insert(ctx, payload)
+		require.NoError(t, err)
+		return payload.ID
+	}
+	aggregates := func() []Payload {
+		items, _, err := db.Query(ctx, ResultQuery{Name: "test", Limit: 100})
+		require.NoError(t, err)
+		var aggregates []Payload
+		for _, item := range items {
+			if item.Status == StatusAggregated {
+				aggregates = append(aggregates, item)
+			}
+		}
+		return aggregates
+	}
+
+	recent := insert("test", day, 100, time.Second, StatusSucceeded)
+	insert("test", 3*day, 100, time.Second, StatusSucceeded)
+	insert("test", 3*day+time.Hour, 300, 3*time.Second, StatusSucceeded)
+	insert("test", 3*day+2*time.Hour, 0, 0, StatusFailed)
+	insert("test", 3*day+3*time.Hour, 0, 0, StatusSkipped)
+	insert("test", 4*day, 100, time.Second, StatusSucceeded)
+	insert("test", 9*day, 100, time.Second, StatusSucceeded)
+	other := insert("other", 9*day, 100, time.Second, StatusSucceeded)
+
+	rule := conf.RetentionConfig{Raw: conf.NewDuration(2 * day), Aggregate: conf.NewDuration(5 * day)}
+	result, err := db.Compact(ctx, NewCompaction("test", rule, now))
+	require.NoError(t, err)
+	assert.Equal(t, CompactionResult{Compacted: 6, Aggregated: 3, Expired: 1}, result)
+
+	_, err = db.Select(ctx, recent)
+	assert.NoError(t, err, "recent runs are kept")
+	_, err = db.Select(ctx, other)
+	assert.NoError(t, err, "other tests are untouched")
+
+	got := aggregates()
+	require.Len(t, got, 2)
+	three := got[1]
+	assert.Equal(t, now.Add(-4*day).Truncate(day).UnixNano(), got[0].StartedAt.UnixNano())
+	assert.Equal(t, now.Add(-3*day).Truncate(day).UnixNano(), three.StartedAt.UnixNano())
+	assert.Equal(t, now.Add(-2*day).Truncate(day).UnixNano(), three.EndedAt.UnixNano())
+	assert.Equal(t, uint64(400), three.Requests)
+	assert.Equal(t, 2500*time.Millisecond, three.LatencyP99, "weighted by requests")
+	assert.Equal(t, 3*time.Second, three.LatencyMax)
+	assert.Equal(t, 3*time.Minute, three.Duration, "skipped runs add no time")
+	assert.Equal(t, map[string]string{"env": "prod"}, three.Labels, "only common labels are kept")
+	var data aggregateData
+	require.NoError(t, json.Unmarshal(three.Data, &data))
+	assert.Equal(t, aggregateData{Runs: 3, Failed: 1, Skipped: 1, Resolution: day}, data)
+
+	// later runs in the same bucket merge into the stored aggregate
+	insert("test", 3*day+3*time.Hour, 400, 5*time.Second, StatusSucceeded)
+	result, err = db.Compact(ctx, NewCompaction("test", rule, now))
+	require.NoError(t, err)
+	assert.Equal(t, CompactionResult{Compacted: 1, Aggregated: 1}, result)
+	got = aggregates()
+	require.Len(t, got, 2)
+	assert.Equal(t, three.ID, got[1].ID)
+	assert.Equal(t, uint64(800), got[1].Requests)
+	assert.Equal(t, 3750*time.Millisecond, got[1].LatencyP99)
+
+	// without aggregates old runs are deleted outright
+	insert("test", 3*day, 100, time.Second, StatusSucceeded)
+	rule.Aggregate = conf.NewDuration(0)
+	result, err = db.Compact(ctx, NewCompaction("test", rule, now))
+	require.NoError(t, err)
+	assert.Equal(t, CompactionResult{Compacted: 1}, result)
+	assert.Len(t, aggregates(), 2)
+}
+
+func testStorageArtifacts(t *testing.T, db Storage) {
+	ctx := context.Background()
+	ids := insertResults(t, db, "test", nil, 1, 2)
+
+	artifact := Artifact{ResultID: ids[0], Format: "gob", Data: []byte{0x1f, 0x8b, 0}}
+	require.NoError(t, db.InsertArtifact(ctx, artifact))
+	assert.True(t, errors.Is(db.InsertArtifact(ctx, artifact), ErrConflict))
+	err := db.InsertArtifact(ctx, Artifact{ResultID: uuid.NewV4().String(), Format: "gob", Data: []byte{0}})
+	assert.True(t, errors.Is(err, ErrNotFound), "artifacts need a result: %v", err)
+
+	got, err := db.SelectArtifact(ctx, ids[0])
+	require.NoError(t, err)
+	assert.Equal(t, artifact.Format, got.Format)
+	assert.Equal(t, artifact.Data, got.Data)
+	assert.False(t, got.CreatedAt.IsZero())
+	_, err = db.SelectArtifact(ctx, ids[1])
+	assert.True(t, errors.Is(err, ErrNotFound))
+
+	// artifacts go along with their result
+	require.NoError(t, db.Delete(ctx, ids[0]))
+	_, err = db.SelectArtifact(ctx, ids[0])
+	assert.True(t, errors.Is(err, ErrNotFound))
+}
@@ -0,0 +1,125 @@
+// Package schedule computes when scheduled tests run: every fixed interval,
+// on a cron expression, or once at a set time.
+package schedule
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"time"
+
+	"github.com/robfig/cron/v3"
+)
+
+// Spec describes a schedule. Exactly one of Every, Cron and At is set.
+type Spec struct {
+	Every time.Duration
+	// Cron is a standard five field cron expression or a descriptor such as
+	// @daily.
+	Cron string
+	// At is a one-shot time, RFC 3339 or without a zone offset, e.g.
+	// 2021-09-01T02:00:00.
+	At string
+	// TimeZone is the IANA time zone Cron and At are read in. Defaults to
+	// the local time zone.
+	TimeZone string
+	// Jitter delays every run by a random duration of up to Jitter.
+	Jitter time.Duration
+}
+
+// Schedule returns when a test runs next.
+type Schedule interface {
+	// Next returns the first run time after t, or the zero time when the
+	// schedule has no more runs.
+	Next(t time.Time) time.Time
+}
+
+// atLayouts are the accepted layouts of one-shot times without an offset.
+var atLayouts = []string{"2006-01-02T15:04:05", "2006-01-02T15:04", "2006-01-02 15:04:05", "2006-01-02 15:04"}
+
+// Parse returns the schedule described by spec. Interval schedules are
+// anchored at anchor, so that runs do not drift with their duration.
+func Parse(spec Spec, anchor time.Time) (Schedule, error) {
+	set := 0
+	for _, ok := range []bool{spec.Every != 0, spec.Cron != "", spec.At != ""} {
+		if ok {
+			set++
+		}
+	}
+	switch {
+	case set != 1:
+		return nil, errors.New("exactly one of every, cron and at is required")
+	case spec.Every < 0:
+		return nil, errors.New("every must be greater than zero")
+	case spec.Jitter < 0:
+		return nil, errors.New("jitter must not be negative")
+	}
+
+	loc := time.Local
+	if spec.TimeZone != "" {
+		var err error
+		if loc, err = time.LoadLocation(spec.TimeZone); err != nil {
+			return nil, fmt.Errorf("invalid time zone: %v", err)
+		}
+	}
+
+	switch {
+	case spec.Every > 0:
+		return Interval{Every: spec.Every, Anchor: anchor}, nil
+	case spec.Cron != "":
+		s, err := cron.ParseStandard(spec.Cron)
+		if err != nil {
+			return nil, fmt.Errorf("invalid cron expression: %v", err)
+		}
+		if spec, ok := s.(*cron.SpecSchedule); ok && spec.Location == time.Local {
+			// CRON_TZ in the expression takes precedence
+			spec.Location = loc
+		}
+		return s, nil
+	default:
+		at, err := time.Parse(time.RFC3339, spec.At)
+		for _, layout := range atLayouts {
+			if err == nil {
+				break
+			}
+			at, err = time.ParseInLocation(layout, spec.At, loc)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid at time %q", spec.At)
+		}
+		return Once{At: at}, nil
+	}
+}
+
+// Interval runs every Every, at Anchor plus whole intervals.
+type Interval struct {
+	Every  time.Duration
+	Anchor time.Time
+}
+
+func (s Interval) Next(t time.Time) time.Time {
+	if t.Before(s.Anchor) {
+		return s.Anchor
+	}
+	return s.Anchor.Add((t.Sub(s.Anchor)/s.Every + 1) * s.Every)
+}
+
+// Once runs a single time.
+type Once struct {
+	At time.Time
+}
+
+func (s Once) Next(t time.Time) time.Time {
+	if t.Before(s.At) {
+		return s.At
+	}
+	return time.Time{}
+}
+
+// Jitter returns a random delay of up to max from rnd.
+func Jitter(rnd *rand.Rand, max time.Duration) time.Duration {
+	if max <= 0 {
+		return 0
+	}
+	return time.Duration(rnd.Int63n(int64(max)))
+}
@@ -0,0 +1,95 @@
+package schedule
+
+import (
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParse(t *testing.T) {
+	anchor := time.Date(2021, 9, 1, 12, 0, 0, 0, time.UTC)
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	require.NoError(t, err)
+
+	tests := map[string]struct {
+		spec    Spec
+		after   time.Time
+		want    []time.Time
+		wantErr bool
+	}{
+		"interval": {
+			spec:  Spec{Every: time.Minute},
+			after: anchor.Add(90 * time.Second),
+			want:  []time.Time{anchor.Add(2 * time.Minute), anchor.Add(3 * time.Minute)},
+		},
+		"interval before anchor": {
+			spec:  Spec{Every: time.Minute},
+			after: anchor.Add(-time.Hour),
+			want:  []time.Time{anchor, anchor.Add(time.Minute)},
+		},
+		"cron in time zone": {
+			spec:  Spec{Cron: "0 2 * * *", TimeZone: "Europe/Berlin"},
+			after: anchor,
+			want:  []time.Time{time.Date(2021, 9, 2, 2, 0, 0, 0, berlin), time.Date(2021, 9, 3, 2, 0, 0, 0, berlin)},
+		},
+		"cron descriptor": {
+			spec:  Spec{Cron: "@hourly", TimeZone: "UTC"},
+			after: anchor.Add(time.Minute),
+			want:  []time.Time{anchor.Add(time.Hour), anchor.Add(2 * time.Hour)},
+		},
+		"cron with zone prefix": {
+			spec:  Spec{Cron: "CRON_TZ=UTC 30 * * * *", TimeZone: "Europe/Berlin"},
+			after: anchor,
+			want:  []time.Time{anchor.Add(30 * time.Minute), anchor.Add(90 * time.Minute)},
+		},
+		"at in time zone": {
+			spec:  Spec{At: "2021-09-02T02:00", TimeZone: "Europe/Berlin"},
+			after: anchor,
+			want:  []time.Time{time.Date(2021, 9, 2, 2, 0, 0, 0, berlin), {}},
+		},
+		"at with offset": {
+			spec:  Spec{At: "2021-09-02T02:00:00Z", TimeZone: "Europe/Berlin"},
+			after: anchor,
+			want:  []time.Time{time.Date(2021, 9, 2, 2, 0, 0, 0, time.UTC), {}},
+		},
+		"at in the past": {
+			spec:  Spec{At: "2021-09-01T00:00:00Z"},
+			after: anchor,
+			want:  []time.Time{{}},
+		},
+		"none":            {spec: Spec{}, wantErr: true},
+		"several":         {spec: Spec{Every: time.Minute, Cron: "@daily"}, wantErr: true},
+		"negative every":  {spec: Spec{Every: -time.Minute}, wantErr: true},
+		"negative jitter": {spec: Spec{Every: time.Minute, Jitter: -1}, wantErr: true},
+		"bad cron":        {spec: Spec{Cron: "* * *"}, wantErr: true},
+		"bad time zone":   {spec: Spec{Cron: "@daily", TimeZone: "Mars/Olympus"}, wantErr: true},
+		"bad at":          {spec: Spec{At: "tomorrow"}, wantErr: true},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			s, err := Parse(test.spec, anchor)
+			if test.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			next := test.after
+			for _, want := range test.want {
+				next = s.Next(next)
+				assert.True(t, want.Equal(next), "want %s, got %s", want, next)
+			}
+		})
+	}
+}
+
+func TestJitter(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+	assert.Zero(t, Jitter(rnd, 0))
+	for i := 0; i < 100; i++ {
+		j := Jitter(rnd, time.Second)
+		assert.True(t, j >= 0 && j < time.Second, "jitter %s", j)
+	}
+}
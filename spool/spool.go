@@ -0,0 +1,281 @@
+// Package spool queues result writes on local disk while storage is
+// unavailable and replays them, in order, once it recovers.
+package spool
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/javking07/toadlester/model"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	// entryExt names spooled results, which are named by their sequence
+	// number so that they sort in the order they were spooled.
+	entryExt = ".json"
+	// failedExt marks results that storage rejected while it was healthy.
+	// They are kept for inspection but no longer replayed.
+	failedExt = ".failed"
+	// artifactExt names the raw results attached to a spooled result, after
+	// the sequence number of the result.
+	artifactExt = ".artifact"
+)
+
+// ErrNotSpooled is returned by Attach when the result is not spooled.
+var ErrNotSpooled = errors.New("result is not spooled")
+
+// Spool is a disk backed queue of results waiting to be stored. It is safe
+// for concurrent use.
+type Spool struct {
+	dir string
+
+	mu      sync.Mutex
+	seq     uint64
+	pending int
+	// ids holds the sequence numbers of spooled results by result id.
+	ids map[string]uint64
+
+	// replaying serialises replays so that results are stored in order.
+	replaying sync.Mutex
+}
+
+// Open opens the spool in dir, creating it if needed. Results spooled before
+// a restart are picked up again.
+func Open(dir string) (*Spool, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	s := &Spool{dir: dir, ids: make(map[string]uint64)}
+
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, f := range files {
+		name := f.Name()
+		if strings.HasPrefix(name, ".tmp-") {
+			// left over from a write that never completed
+			_ = os.Remove(filepath.Join(dir, name))
+			continue
+		}
+		seq, ok := entrySeq(strings.TrimSuffix(name, failedExt))
+		if !ok {
+			continue
+		}
+		if seq >= s.seq {
+			s.seq = seq + 1
+		}
+		if strings.HasSuffix(name, failedExt) {
+			continue
+		}
+		payload, err := s.read(s.path(seq))
+		if err != nil {
+			return nil, err
+		}
+		s.ids[payload.ID] = seq
+		s.pending++
+	}
+	return s, nil
+}
+
+// Len returns the number of results waiting to be replayed.
+func (s *Spool) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.pending
+}
+
+// Push spools a result. It returns once the result is safely on disk.
+func (s *Spool) Push(payload model.Payload) error {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.write(data, s.path(s.seq)); err != nil {
+		return err
+	}
+	s.ids[payload.ID] = s.seq
+	s.seq++
+	s.pending++
+	return nil
+}
+
+// spooledArtifact is the form raw results are attached in.
+type spooledArtifact struct {
+	Format string `json:"format"`
+	Data   []byte `json:"data"`
+}
+
+// Attach spools the raw results of a spooled result, to be stored right
+// after it on replay. It returns ErrNotSpooled when the result is not, or no
+// longer, spooled.
+func (s *Spool) Attach(artifact model.Artifact) error {
+	// a replay in progress could store the result without its artifact
+	s.replaying.Lock()
+	defer s.replaying.Unlock()
+
+	s.mu.Lock()
+	seq, ok := s.ids[artifact.ResultID]
+	s.mu.Unlock()
+	if !ok {
+		return ErrNotSpooled
+	}
+	data, err := json.Marshal(spooledArtifact{Format: artifact.Format, Data: artifact.Data})
+	if err != nil {
+		return err
+	}
+	return s.write(data, s.path(seq)+artifactExt)
+}
+
+// write writes data to path durably, through a temporary file so that a
+// partial write never shows up under path.
+func (s *Spool) write(data []byte, path string) error {
+	tmp, err := ioutil.TempFile(s.dir, ".tmp-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// Replay inserts spooled results into storage in the order they were
+// spooled and returns how many were stored. Results that storage already
+// holds are not inserted twice. Replay stops at the first result that cannot
+// be inserted because storage is unhealthy; a result rejected by healthy
+// storage is set aside instead so that it does not hold up the rest. Raw
+// results attached to a result are stored right after it.
+func (s *Spool) Replay(ctx context.Context, storage model.Storage) (int, error) {
+	s.replaying.Lock()
+	defer s.replaying.Unlock()
+
+	seqs, err := s.entries()
+	if err != nil {
+		return 0, err
+	}
+
+	replayed := 0
+	for _, seq := range seqs {
+		path := s.path(seq)
+		id, err := s.insert(ctx, storage, path)
+		if err != nil {
+			if herr := storage.Healthy(ctx); herr != nil {
+				return replayed, err
+			}
+			log.Error().Msgf("setting aside spooled result %s rejected by storage: %v", filepath.Base(path), err)
+			if err := os.Rename(path, path+failedExt); err != nil {
+				return replayed, err
+			}
+			if err := os.Rename(path+artifactExt, path+artifactExt+failedExt); err != nil && !os.IsNotExist(err) {
+				return replayed, err
+			}
+		} else {
+			if err := os.Remove(path); err != nil {
+				return replayed, err
+			}
+			replayed++
+		}
+
+		s.mu.Lock()
+		delete(s.ids, id)
+		s.pending--
+		s.mu.Unlock()
+	}
+	return replayed, nil
+}
+
+// insert stores the spooled result at path, and its raw results if any, and
+// returns the id of the result. A result or raw results that are already
+// stored, because an earlier insert went through without being
+// acknowledged, count as inserted.
+func (s *Spool) insert(ctx context.Context, storage model.Storage, path string) (string, error) {
+	payload, err := s.read(path)
+	if err != nil {
+		return "", err
+	}
+	if _, err := storage.Insert(ctx, payload); err != nil && !errors.Is(err, model.ErrConflict) {
+		return payload.ID, err
+	}
+
+	data, err := ioutil.ReadFile(path + artifactExt)
+	if os.IsNotExist(err) {
+		return payload.ID, nil
+	} else if err != nil {
+		return payload.ID, err
+	}
+	var artifact spooledArtifact
+	if err := json.Unmarshal(data, &artifact); err != nil {
+		return payload.ID, fmt.Errorf("error decoding spooled raw results: %v", err)
+	}
+	err = storage.InsertArtifact(ctx, model.Artifact{ResultID: payload.ID, Format: artifact.Format, Data: artifact.Data})
+	if err != nil && !errors.Is(err, model.ErrConflict) {
+		return payload.ID, err
+	}
+	return payload.ID, os.Remove(path + artifactExt)
+}
+
+// read decodes the spooled result at path.
+func (s *Spool) read(path string) (model.Payload, error) {
+	var payload model.Payload
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return payload, err
+	}
+	if err := json.Unmarshal(data, &payload); err != nil {
+		return payload, fmt.Errorf("error decoding spooled result: %v", err)
+	}
+	return payload, nil
+}
+
+// entries returns the sequence numbers of the spooled results in order.
+func (s *Spool) entries() ([]uint64, error) {
+	files, err := ioutil.ReadDir(s.dir)
+	if err != nil {
+		return nil, err
+	}
+	var seqs []uint64
+	for _, f := range files {
+		if seq, ok := entrySeq(f.Name()); ok {
+			seqs = append(seqs, seq)
+		}
+	}
+	sort.Slice(seqs, func(i, j int) bool { return seqs[i] < seqs[j] })
+	return seqs, nil
+}
+
+func (s *Spool) path(seq uint64) string {
+	return filepath.Join(s.dir, fmt.Sprintf("%020d%s", seq, entryExt))
+}
+
+// entrySeq returns the sequence number of a spooled result file name.
+func entrySeq(name string) (uint64, bool) {
+	if !strings.HasSuffix(name, entryExt) {
+		return 0, false
+	}
+	seq, err := strconv.ParseUint(strings.TrimSuffix(name, entryExt), 10, 64)
+	return seq, err == nil
+}
@@ -0,0 +1,145 @@
+package spool
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/javking07/toadlester/model"
+)
+
+// flakyStorage is memory storage that can be taken down and records the
+// order of successful inserts.
+type flakyStorage struct {
+	model.Storage
+	down     bool
+	reject   string
+	inserted []string
+}
+
+var errDown = errors.New("storage is down")
+
+func (f *flakyStorage) Insert(ctx context.Context, payload model.Payload) (int64, error) {
+	if f.down {
+		return 0, errDown
+	}
+	if payload.ID == f.reject {
+		return 0, errors.New("rejected")
+	}
+	n, err := f.Storage.Insert(ctx, payload)
+	if err == nil {
+		f.inserted = append(f.inserted, payload.ID)
+	}
+	return n, err
+}
+
+func (f *flakyStorage) Healthy(context.Context) error {
+	if f.down {
+		return errDown
+	}
+	return nil
+}
+
+func TestSpool_Replay(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	storage := &flakyStorage{Storage: model.NewMemoryStorage(), down: true}
+
+	s, err := Open(dir)
+	require.NoError(t, err)
+	for _, id := range []string{"a", "b", "c", "d"} {
+		require.NoError(t, s.Push(model.Payload{ID: id, Name: "test", Data: []byte(`{"n":1}`)}))
+	}
+	assert.Equal(t, 4, s.Len())
+
+	// nothing is lost while storage is down
+	replayed, err := s.Replay(ctx, storage)
+	assert.ErrorIs(t, err, errDown)
+	assert.Zero(t, replayed)
+	assert.Equal(t, 4, s.Len())
+
+	// a restart picks up the spooled results and keeps numbering after them
+	s, err = Open(dir)
+	require.NoError(t, err)
+	assert.Equal(t, 4, s.Len())
+	require.NoError(t, s.Push(model.Payload{ID: "e", Name: "test"}))
+
+	// b was stored before its insert failed, c is rejected by healthy storage
+	storage.down = false
+	_, err = storage.Storage.Insert(ctx, model.Payload{ID: "b", Name: "test"})
+	require.NoError(t, err)
+	storage.reject = "c"
+
+	replayed, err = s.Replay(ctx, storage)
+	require.NoError(t, err)
+	assert.Equal(t, 4, replayed)
+	assert.Equal(t, 0, s.Len())
+	assert.Equal(t, []string{"a", "d", "e"}, storage.inserted)
+
+	result, err := storage.Select(ctx, "a")
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"n":1}`, string(result.Data))
+
+	failed, err := filepath.Glob(filepath.Join(dir, "*"+failedExt))
+	require.NoError(t, err)
+	assert.Len(t, failed, 1)
+
+	// set aside results are neither counted nor replayed after a restart
+	s, err = Open(dir)
+	require.NoError(t, err)
+	assert.Equal(t, 0, s.Len())
+	replayed, err = s.Replay(ctx, storage)
+	assert.NoError(t, err)
+	assert.Zero(t, replayed)
+}
+
+func TestOpen_RemovesPartialWrites(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, ".tmp-123"), []byte("{"), 0644))
+
+	s, err := Open(dir)
+	require.NoError(t, err)
+	assert.Equal(t, 0, s.Len())
+	_, err = os.Stat(filepath.Join(dir, ".tmp-123"))
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestSpool_Attach(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	storage := &flakyStorage{Storage: model.NewMemoryStorage(), down: true}
+
+	s, err := Open(dir)
+	require.NoError(t, err)
+	require.NoError(t, s.Push(model.Payload{ID: "a", Name: "test"}))
+	require.NoError(t, s.Push(model.Payload{ID: "b", Name: "test"}))
+	assert.ErrorIs(t, s.Attach(model.Artifact{ResultID: "c", Format: "gob"}), ErrNotSpooled)
+	require.NoError(t, s.Attach(model.Artifact{ResultID: "a", Format: "gob", Data: []byte("raw")}))
+
+	// attached raw results survive a restart and are stored after their result
+	s, err = Open(dir)
+	require.NoError(t, err)
+	storage.down = false
+	replayed, err := s.Replay(ctx, storage)
+	require.NoError(t, err)
+	assert.Equal(t, 2, replayed)
+
+	artifact, err := storage.SelectArtifact(ctx, "a")
+	require.NoError(t, err)
+	assert.Equal(t, "gob", artifact.Format)
+	assert.Equal(t, []byte("raw"), artifact.Data)
+	_, err = storage.SelectArtifact(ctx, "b")
+	assert.ErrorIs(t, err, model.ErrNotFound)
+
+	// replayed results can no longer be attached to
+	assert.ErrorIs(t, s.Attach(model.Artifact{ResultID: "a", Format: "gob"}), ErrNotSpooled)
+	files, err := ioutil.ReadDir(dir)
+	require.NoError(t, err)
+	assert.Empty(t, files)
+}